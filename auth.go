@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
@@ -47,14 +48,25 @@ type Auth interface {
 }
 
 // Auth authenticates the connection, trying the given list of authentication
-// mechanisms (in that order). If nil is passed, the EXTERNAL and
-// DBUS_COOKIE_SHA1 mechanisms are tried for the current user. For private
-// connections, this method must be called before sending any messages to the
-// bus. Auth must not be called on shared connections.
+// mechanisms (in that order, skipping those the server doesn't offer), limited
+// to the ones set with WithAuthMechanisms, if any. If nil is passed, the
+// EXTERNAL mechanism without an explicit identity and the DBUS_COOKIE_SHA1
+// mechanism for the current user are tried. If the address the connection was
+// dialed with has a guid key, authentication fails unless the server reports
+// that GUID. For private connections, this method must be called before
+// sending any messages to the bus. Auth must not be called on shared
+// connections.
 func (conn *Conn) Auth(methods []Auth) error {
+	if conn.noAuth {
+		conn.Start()
+		return nil
+	}
 	if methods == nil {
 		uid := strconv.Itoa(os.Geteuid())
-		methods = []Auth{AuthExternal(uid), AuthCookieSha1(uid, getHomeDir())}
+		methods = []Auth{AuthExternal(""), AuthCookieSha1(uid, getHomeDir())}
+	}
+	if conn.authMechanisms != nil {
+		methods = selectAuthMechanisms(methods, conn.authMechanisms)
 	}
 	in := bufio.NewReader(conn.transport)
 	err := conn.transport.SendNullByte()
@@ -72,58 +84,93 @@ func (conn *Conn) Auth(methods []Auth) error {
 	if len(s) < 2 || !bytes.Equal(s[0], []byte("REJECTED")) {
 		return errors.New("dbus: authentication protocol error")
 	}
-	s = s[1:]
-	for _, v := range s {
-		for _, m := range methods {
-			if name, _, status := m.FirstData(); bytes.Equal(v, name) {
-				var ok bool
-				err = authWriteLine(conn.transport, []byte("AUTH"), v)
+	offered := s[1:]
+	for _, m := range methods {
+		name, resp, status := m.FirstData()
+		if !containsMechanism(offered, name) {
+			continue
+		}
+		var ok bool
+		if len(resp) != 0 {
+			err = authWriteLine(conn.transport, []byte("AUTH"), name, resp)
+		} else {
+			err = authWriteLine(conn.transport, []byte("AUTH"), name)
+		}
+		if err != nil {
+			return err
+		}
+		switch status {
+		case AuthOk:
+			ok, err = conn.tryAuth(m, waitingForOk, in)
+		case AuthContinue:
+			ok, err = conn.tryAuth(m, waitingForData, in)
+		default:
+			panic("dbus: invalid authentication status")
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			if conn.expectedUUID != "" && conn.uuid != conn.expectedUUID {
+				return fmt.Errorf("dbus: server GUID %s doesn't match the GUID %s in the address", conn.uuid, conn.expectedUUID)
+			}
+			conn.authMechanism = string(name)
+			if conn.transport.SupportsUnixFDs() {
+				err = authWriteLine(conn, []byte("NEGOTIATE_UNIX_FD"))
 				if err != nil {
 					return err
 				}
-				switch status {
-				case AuthOk:
-					ok, err = conn.tryAuth(m, waitingForOk, in)
-				case AuthContinue:
-					ok, err = conn.tryAuth(m, waitingForData, in)
-				default:
-					panic("dbus: invalid authentication status")
-				}
+				line, err := authReadLine(in)
 				if err != nil {
 					return err
 				}
-				if ok {
-					if conn.transport.SupportsUnixFDs() {
-						err = authWriteLine(conn, []byte("NEGOTIATE_UNIX_FD"))
-						if err != nil {
-							return err
-						}
-						line, err := authReadLine(in)
-						if err != nil {
-							return err
-						}
-						switch {
-						case bytes.Equal(line[0], []byte("AGREE_UNIX_FD")):
-							conn.EnableUnixFDs()
-							conn.unixFD = true
-						case bytes.Equal(line[0], []byte("ERROR")):
-						default:
-							return errors.New("dbus: authentication protocol error")
-						}
-					}
-					err = authWriteLine(conn.transport, []byte("BEGIN"))
-					if err != nil {
-						return err
-					}
-					go conn.inWorker()
-					return nil
+				switch {
+				case bytes.Equal(line[0], []byte("AGREE_UNIX_FD")):
+					conn.EnableUnixFDs()
+					conn.unixFD = true
+				case bytes.Equal(line[0], []byte("ERROR")):
+				default:
+					return errors.New("dbus: authentication protocol error")
 				}
 			}
+			err = authWriteLine(conn.transport, []byte("BEGIN"))
+			if err != nil {
+				return err
+			}
+			conn.setState(StateConnected)
+			go conn.inWorker()
+			return nil
 		}
 	}
 	return errors.New("dbus: authentication failed")
 }
 
+// containsMechanism returns whether name is in the list of mechanisms offered
+// by the server.
+func containsMechanism(offered [][]byte, name []byte) bool {
+	for _, v := range offered {
+		if bytes.Equal(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectAuthMechanisms returns the methods whose mechanism is in names, in the
+// order of names.
+func selectAuthMechanisms(methods []Auth, names []string) []Auth {
+	selected := make([]Auth, 0, len(names))
+	for _, name := range names {
+		for _, m := range methods {
+			if n, _, _ := m.FirstData(); string(n) == name {
+				selected = append(selected, m)
+				break
+			}
+		}
+	}
+	return selected
+}
+
 // tryAuth tries to authenticate with m as the mechanism, using state as the
 // initial authState and in for reading input. It returns (true, nil) on
 // success, (false, nil) on a REJECTED and (false, someErr) if some other
@@ -224,11 +271,28 @@ func (conn *Conn) tryAuth(m Auth, state authState, in *bufio.Reader) (bool, erro
 	}
 }
 
+// authMaxLineLength is the maximum length of a line of the authentication
+// protocol that is accepted from the server, including the line terminator,
+// so that a misbehaving server can't make the client buffer unbounded data.
+const authMaxLineLength = 16384
+
+var errAuthLineTooLong = errors.New("dbus: authentication protocol line too long")
+
 // authReadLine reads a line and separates it into its fields.
 func authReadLine(in *bufio.Reader) ([][]byte, error) {
-	data, err := in.ReadBytes('\n')
-	if err != nil {
-		return nil, err
+	var data []byte
+	for {
+		chunk, err := in.ReadSlice('\n')
+		if len(data)+len(chunk) > authMaxLineLength {
+			return nil, errAuthLineTooLong
+		}
+		data = append(data, chunk...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
 	}
 	data = bytes.TrimSuffix(data, []byte("\r\n"))
 	return bytes.Split(data, []byte{' '}), nil
@@ -5,7 +5,10 @@ import (
 )
 
 // AuthExternal returns an Auth that authenticates as the given user with the
-// EXTERNAL mechanism.
+// EXTERNAL mechanism. If user is empty, no identity is sent and the server
+// derives it from the credentials of the socket (e.g. SO_PEERCRED). This
+// avoids mismatches when the numeric uid differs between the client and the
+// server, for example across a user namespace boundary.
 func AuthExternal(user string) Auth {
 	return authExternal{user}
 }
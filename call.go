@@ -2,6 +2,7 @@ package dbus
 
 import (
 	"context"
+	"encoding/binary"
 )
 
 // Call represents a pending or completed method call.
@@ -33,6 +34,32 @@ type Call struct {
 	// tracks context and canceler
 	ctx         context.Context
 	ctxCanceler context.CancelFunc
+
+	// deferred is set for calls whose reply body is decoded on demand
+	// instead of by the connection; reply then holds the undecoded reply.
+	deferred     bool
+	reply        *Message
+	limits       decodeLimits
+	orderedDicts bool
+
+	// keepReply is set for calls made with SendAndWait, which returns the
+	// whole reply message held by replyMsg.
+	keepReply bool
+	replyMsg  *Message
+}
+
+// String returns a string representation of the call similar to the format
+// of dbus-monitor, including the error or the reply body once it is done.
+func (c *Call) String() string {
+	s := "call"
+	if c.Destination != "" {
+		s += " to " + c.Destination
+	}
+	s += " path " + string(c.Path) + " method " + c.Method
+	if c.Err != nil {
+		return s + " error " + c.Err.Error()
+	}
+	return s + formatBody(c.Body)
 }
 
 func (c *Call) Context() context.Context {
@@ -52,14 +79,64 @@ func (c *Call) ContextCancel() {
 // Store stores the body of the reply into the provided pointers. It returns
 // an error if the signatures of the body and retvalues don't match, or if
 // the error status is not nil.
+//
+// For calls made with CallDeferred, Store first decodes the body of the reply
+// in the calling goroutine and sets Body accordingly. If the reply is an array
+// of structs with only fixed-size fields (e.g. "a(iiy)") and a single pointer
+// to a slice of matching Go structs is passed, it is decoded directly into
+// that slice, without boxing every element. If the reply is any other array
+// that is not a dict and a single channel is passed, the elements are decoded
+// and sent to the channel one at a time, as described for the package-level
+// Store, and Body stays nil.
+//
+// Channels are only closed by Store if it got to send to them, which isn't the
+// case if the call failed.
 func (c *Call) Store(retvalues ...interface{}) error {
 	if c.Err != nil {
 		return c.Err
 	}
+	if c.reply != nil {
+		dec := getDecoder(nil, nativeEndian, nil)
+		defer putDecoder(dec)
+		dec.limits = c.limits
+		dec.orderedDicts = c.orderedDicts
+		done, err := c.reply.decodeBodyToChan(dec, retvalues)
+		if err == nil && !done {
+			done, err = c.reply.decodeBodyInto(dec, retvalues)
+		}
+		if err != nil {
+			return err
+		}
+		if !done {
+			if err := c.reply.decodeBody(dec); err != nil {
+				return err
+			}
+		}
+		c.Body = c.reply.Body
+		c.reply = nil
+		if done {
+			return nil
+		}
+	}
 
 	return Store(c.Body, retvalues...)
 }
 
+// RawBody returns the undecoded body of the reply to a call made with
+// CallDeferred, together with its byte order and signature, so that the caller
+// can forward it or decide whether to decode it at all. It returns a nil order
+// if the call wasn't deferred, failed or if Store has already decoded the body.
+// The body can still be decoded with Store afterwards; unix fds contained in
+// the reply are only available that way.
+func (c *Call) RawBody() ([]byte, binary.ByteOrder, Signature) {
+	if c.Err != nil || c.reply == nil {
+		return nil, nil, Signature{}
+	}
+	body, order := c.reply.RawBody()
+	sig, _ := c.reply.Headers[FieldSignature].value.(Signature)
+	return body, order, sig
+}
+
 func (c *Call) done() {
 	c.Done <- c
 	c.ContextCancel()
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -37,16 +40,31 @@ type Conn struct {
 	closeOnce sync.Once
 	closeErr  error
 
-	busObj BusObject
-	unixFD bool
-	uuid   string
-
-	handler       Handler
-	signalHandler SignalHandler
-	serialGen     SerialGenerator
-	inInt         Interceptor
-	outInt        Interceptor
-	auth          []Auth
+	busObj        BusObject
+	unixFD        bool
+	uuid          string
+	expectedUUID  string
+	authMechanism string
+
+	handler        Handler
+	signalHandler  SignalHandler
+	serialGen      SerialGenerator
+	inInt          Interceptor
+	outInt         Interceptor
+	auth           []Auth
+	authMechanisms []string
+	defaultFlags   Flags
+	decodeLimits   decodeLimits
+	orderedDicts   bool
+	autoIntrospect bool
+	introspect     *exportedIntf
+	noAuth         bool
+	noHello        bool
+	peer           bool
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	sizeWarn       int
+	sizeWarnFunc   func(*Message, int)
 
 	names      *nameTracker
 	calls      *callTracker
@@ -54,11 +72,26 @@ type Conn struct {
 
 	eavesdropped    chan<- *Message
 	eavesdroppedLck sync.Mutex
+
+	// matchRules holds the rules added with AddMatchSignal that haven't been
+	// removed, once per addition, as the bus counts them.
+	matchRules    []string
+	matchRulesLck sync.Mutex
+
+	stateLck       sync.Mutex
+	state          State
+	stateListeners []func(State)
+
+	sentMessages     atomic.Uint64
+	receivedMessages atomic.Uint64
+	droppedMessages  atomic.Uint64
 }
 
 // SessionBus returns a shared connection to the session bus, connecting to it
-// if not already done.
-func SessionBus() (conn *Conn, err error) {
+// if not already done. The options are only used when a new connection is
+// made; e.g. WithContext ties the lifetime of the shared connection to a
+// context.
+func SessionBus(opts ...ConnOption) (conn *Conn, err error) {
 	sessionBusLck.Lock()
 	defer sessionBusLck.Unlock()
 	if sessionBus != nil &&
@@ -70,12 +103,26 @@ func SessionBus() (conn *Conn, err error) {
 			sessionBus = conn
 		}
 	}()
-	conn, err = ConnectSessionBus()
+	conn, err = ConnectSessionBus(opts...)
 	return
 }
 
+// SetSessionBus replaces the shared connection returned by SessionBus with
+// conn, e.g. to inject a connection to a private bus in tests. If conn is nil,
+// the next call to SessionBus connects to the session bus again. The previous
+// shared connection is not closed.
+func SetSessionBus(conn *Conn) {
+	sessionBusLck.Lock()
+	sessionBus = conn
+	sessionBusLck.Unlock()
+}
+
+// getSessionBusAddress returns the address of the session bus. An autolaunch:
+// address in DBUS_SESSION_BUS_ADDRESS is returned as is if autolaunch is true,
+// as Dial resolves it, and otherwise only allows discovering a running bus.
 func getSessionBusAddress(autolaunch bool) (string, error) {
-	if address := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); address != "" && address != "autolaunch:" {
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address != "" && (autolaunch || !isAutolaunchAddress(address)) {
 		return address, nil
 	} else if address := tryDiscoverDbusSessionBusAddress(); address != "" {
 		os.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
@@ -116,8 +163,9 @@ func SessionBusPrivateHandler(handler Handler, signalHandler SignalHandler) (*Co
 }
 
 // SystemBus returns a shared connection to the system bus, connecting to it if
-// not already done.
-func SystemBus() (conn *Conn, err error) {
+// not already done. The options are only used when a new connection is made,
+// like for SessionBus.
+func SystemBus(opts ...ConnOption) (conn *Conn, err error) {
 	systemBusLck.Lock()
 	defer systemBusLck.Unlock()
 	if systemBus != nil &&
@@ -129,10 +177,20 @@ func SystemBus() (conn *Conn, err error) {
 			systemBus = conn
 		}
 	}()
-	conn, err = ConnectSystemBus()
+	conn, err = ConnectSystemBus(opts...)
 	return
 }
 
+// SetSystemBus replaces the shared connection returned by SystemBus with conn,
+// e.g. to inject a connection to a private bus in tests. If conn is nil, the
+// next call to SystemBus connects to the system bus again. The previous shared
+// connection is not closed.
+func SetSystemBus(conn *Conn) {
+	systemBusLck.Lock()
+	systemBus = conn
+	systemBusLck.Unlock()
+}
+
 // ConnectSessionBus connects to the session bus.
 func ConnectSessionBus(opts ...ConnOption) (*Conn, error) {
 	address, err := getSessionBusAddress(true)
@@ -150,7 +208,8 @@ func ConnectSystemBus(opts ...ConnOption) (*Conn, error) {
 // Connect connects to the given address.
 //
 // Returned connection is ready to use and doesn't require calling
-// Auth and Hello methods to make it usable.
+// Auth and Hello methods to make it usable. If the WithoutHello option is
+// given, the Hello call is skipped.
 func Connect(address string, opts ...ConnOption) (*Conn, error) {
 	conn, err := Dial(address, opts...)
 	if err != nil {
@@ -160,6 +219,9 @@ func Connect(address string, opts ...ConnOption) (*Conn, error) {
 		_ = conn.Close()
 		return nil, err
 	}
+	if conn.noHello {
+		return conn, nil
+	}
 	if err = conn.Hello(); err != nil {
 		_ = conn.Close()
 		return nil, err
@@ -182,12 +244,22 @@ func SystemBusPrivateHandler(handler Handler, signalHandler SignalHandler) (*Con
 }
 
 // Dial establishes a new private connection to the message bus specified by address.
+// If address lists several alternatives separated by semicolons, they are tried
+// in order and the first one that can be connected to is used; if none can, the
+// returned error joins the errors for all of them. An autolaunch: address
+// connects to the running session bus of the current user or starts one; if
+// neither works, the error lists what was attempted.
 func Dial(address string, opts ...ConnOption) (*Conn, error) {
-	tr, err := getTransport(address)
+	tr, guid, err := getTransport(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newConn(tr, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return newConn(tr, opts...)
+	conn.expectedUUID = guid
+	return conn, nil
 }
 
 // DialHandler establishes a new private connection to the message bus specified by address, using the supplied handlers.
@@ -216,6 +288,18 @@ func WithSignalHandler(handler SignalHandler) ConnOption {
 	}
 }
 
+// WithReliableSignals makes the connection deliver signals with the handler
+// returned by NewSequentialSignalHandler. Unlike the default signal handler,
+// which hands signals for channels that are full to separate goroutines, so
+// that they may arrive out of order and are dropped if the channel is removed
+// before they are delivered, it buffers them for each channel passed to
+// Signal, so that every signal is delivered, in order, even if the receiver
+// falls behind. The buffers grow without limit while a channel isn't read
+// from.
+func WithReliableSignals() ConnOption {
+	return WithSignalHandler(NewSequentialSignalHandler())
+}
+
 // WithSerialGenerator overrides the default signals generator.
 func WithSerialGenerator(gen SerialGenerator) ConnOption {
 	return func(conn *Conn) error {
@@ -224,7 +308,11 @@ func WithSerialGenerator(gen SerialGenerator) ConnOption {
 	}
 }
 
-// WithAuth sets authentication methods for the auth conversation.
+// WithAuth sets authentication methods for the auth conversation. They are
+// tried in the given order, and if the server rejects one, e.g. EXTERNAL on a
+// TCP connection or from another user namespace, the next one is tried, so
+// passing AuthExternal("") followed by AuthAnonymous() falls back to
+// anonymous authentication where the server allows it.
 func WithAuth(methods ...Auth) ConnOption {
 	return func(conn *Conn) error {
 		conn.auth = methods
@@ -232,6 +320,174 @@ func WithAuth(methods ...Auth) ConnOption {
 	}
 }
 
+// WithAuthMechanisms restricts the auth conversation to the authentication
+// methods whose mechanism is in names, e.g. "EXTERNAL", and makes it try them
+// in the order of names, regardless of the order in which they were passed to
+// WithAuth or Auth and of the order in which the server lists them.
+func WithAuthMechanisms(names []string) ConnOption {
+	return func(conn *Conn) error {
+		conn.authMechanisms = names
+		return nil
+	}
+}
+
+// WithDefaultFlags sets flags that are added to every method call made through
+// the connection's objects, in addition to the flags passed to the call itself.
+// For example, FlagNoAutoStart keeps calls from activating services. Only
+// FlagNoAutoStart and FlagAllowInteractiveAuthorization are honored. As the
+// flags of a call can only add to them, calls that must not carry them are
+// made on an object returned by Object.WithoutDefaultFlags.
+func WithDefaultFlags(flags Flags) ConnOption {
+	return func(conn *Conn) error {
+		conn.defaultFlags = flags & (FlagNoAutoStart | FlagAllowInteractiveAuthorization)
+		return nil
+	}
+}
+
+// WithDecodeLimits restricts the complexity of incoming message bodies.
+// maxDepth limits the nesting depth of containers (arrays, structs, dict
+// entries and variants) and is capped at the limit of 64 mandated by the
+// specification; maxElements limits the total number of values decoded from a
+// single message body. A value of zero keeps the respective default, which is
+// a depth of 64 and no element limit. Bodies exceeding a limit fail to decode
+// with an InvalidMessageError; such messages are dropped like other invalid
+// ones, and calls waiting for them as replies fail with that error.
+func WithDecodeLimits(maxDepth, maxElements int) ConnOption {
+	return func(conn *Conn) error {
+		if maxDepth < 0 || maxElements < 0 {
+			return errors.New("dbus: decode limits must not be negative")
+		}
+		conn.decodeLimits = decodeLimits{maxDepth: maxDepth, maxElements: maxElements}
+		return nil
+	}
+}
+
+// WithOrderedDicts makes the connection decode dictionaries in incoming
+// messages to OrderedMap values, which keep the entries in wire order, instead
+// of Go maps. Store still accepts them for map destinations.
+func WithOrderedDicts() ConnOption {
+	return func(conn *Conn) error {
+		conn.orderedDicts = true
+		return nil
+	}
+}
+
+// WithAutoIntrospection makes the default handler answer
+// org.freedesktop.DBus.Introspectable.Introspect on exported objects that
+// don't export that interface themselves, describing their exported methods
+// and, if the value exported as org.freedesktop.DBus.Properties implements
+// PropertyIntrospector, their properties. It only applies to calls received by
+// this connection, even if its handler is shared with others, and it has no
+// effect if a handler other than the default one is set with WithHandler.
+func WithAutoIntrospection() ConnOption {
+	return func(conn *Conn) error {
+		conn.autoIntrospect = true
+		return nil
+	}
+}
+
+// WithReadTimeout makes the connection fail if no message is received within
+// d, which detects peers that have become unresponsive without closing the
+// connection. The deadline is renewed for every message, so on a connection
+// that may be idle for longer than d, the application has to make sure that
+// messages arrive regularly, e.g. by calling org.freedesktop.DBus.Peer.Ping.
+// When the deadline passes, the connection is closed and pending calls fail
+// with a timeout error. It only has an effect on transports based on a
+// net.Conn (see TransportConn).
+func WithReadTimeout(d time.Duration) ConnOption {
+	return func(conn *Conn) error {
+		conn.readTimeout = d
+		return nil
+	}
+}
+
+// WithWriteTimeout makes sending a message fail if it can't be written within
+// d, e.g. because the peer stopped reading. As a partially written message
+// can't be recovered from, the connection is closed in that case. It only has
+// an effect on transports based on a net.Conn (see TransportConn).
+func WithWriteTimeout(d time.Duration) ConnOption {
+	return func(conn *Conn) error {
+		conn.writeTimeout = d
+		return nil
+	}
+}
+
+// WithMessageSizeWarn makes the connection call warn with every message it
+// sends or receives whose encoded size exceeds threshold bytes, together with
+// that size, e.g. to log accidentally huge payloads well before they hit the
+// maximum message size of 128 MiB. The messages are processed as usual
+// afterwards. warn is called from the goroutine sending or receiving the
+// message, so it must not block; received messages are passed before their
+// body is decoded, so their Body may not be set yet.
+func WithMessageSizeWarn(threshold int, warn func(msg *Message, size int)) ConnOption {
+	return func(conn *Conn) error {
+		if threshold < 0 || warn == nil {
+			return errors.New("dbus: invalid message size warning")
+		}
+		conn.sizeWarn = threshold
+		conn.sizeWarnFunc = warn
+		return nil
+	}
+}
+
+// checkMessageSize calls the function set with WithMessageSizeWarn if msg
+// exceeds the threshold. Received messages have the size they were read with;
+// messages to be sent must have their body size cached (see cacheBodySize),
+// as the transport needs it as well.
+func (conn *Conn) checkMessageSize(msg *Message) {
+	if conn.sizeWarnFunc == nil {
+		return
+	}
+	size := msg.wireSize
+	if size == 0 {
+		var err error
+		if size, err = msg.EncodedSize(msg.sendOrder()); err != nil {
+			return
+		}
+	}
+	if size > conn.sizeWarn {
+		conn.sizeWarnFunc(msg, size)
+	}
+}
+
+// WithoutAuth makes Auth, and thus Connect, skip the authentication
+// conversation and just start processing incoming messages, for transports
+// that have been authenticated out of band or need no authentication, like
+// one end of a socket pair. Combine it with WithoutHello or WithPeerToPeer
+// unless the other end is a message bus. Passing unix file descriptors isn't
+// enabled on such connections, as it would have been negotiated during
+// authentication.
+func WithoutAuth() ConnOption {
+	return func(conn *Conn) error {
+		conn.noAuth = true
+		return nil
+	}
+}
+
+// WithoutHello makes Connect skip the org.freedesktop.DBus.Hello call, which is
+// needed when the other end of the connection is a peer rather than a message
+// bus daemon. Such a connection has no unique name, so the first element
+// returned by Names is empty, and NameAcquired and NameLost signals are not
+// used to track names.
+func WithoutHello() ConnOption {
+	return func(conn *Conn) error {
+		conn.noHello = true
+		return nil
+	}
+}
+
+// WithPeerToPeer sets up a direct connection to a peer without a message bus
+// in between. It implies WithoutHello; in addition, incoming messages are
+// dispatched regardless of their destination, and method calls made through
+// the connection's objects carry no destination.
+func WithPeerToPeer() ConnOption {
+	return func(conn *Conn) error {
+		conn.noHello = true
+		conn.peer = true
+		return nil
+	}
+}
+
 // Interceptor intercepts incoming and outgoing messages.
 type Interceptor func(msg *Message)
 
@@ -289,8 +545,15 @@ func newConn(tr transport, opts ...ConnOption) (*Conn, error) {
 	if conn.handler == nil {
 		conn.handler = NewDefaultHandler()
 	}
+	if h, ok := conn.handler.(*defaultHandler); ok && conn.autoIntrospect {
+		// The handler may be shared with other connections, so the
+		// interface is only used by this one (see lookupObject).
+		conn.introspect = newIntrospectIntf(h, true)
+	}
 	if conn.signalHandler == nil {
-		conn.signalHandler = NewDefaultSignalHandler()
+		h := NewDefaultSignalHandler()
+		h.dropped = &conn.droppedMessages
+		conn.signalHandler = h
 	}
 	if conn.serialGen == nil {
 		conn.serialGen = newSerialGenerator()
@@ -317,6 +580,7 @@ func (conn *Conn) BusObject() BusObject {
 // not be called on shared connections.
 func (conn *Conn) Close() error {
 	conn.closeOnce.Do(func() {
+		conn.setState(StateClosed)
 		conn.outHandler.close()
 		if term, ok := conn.signalHandler.(Terminator); ok {
 			term.Terminate()
@@ -332,6 +596,8 @@ func (conn *Conn) Close() error {
 		}
 		conn.eavesdroppedLck.Unlock()
 
+		conn.names.terminate()
+
 		conn.cancelCtx()
 
 		conn.closeErr = conn.transport.Close()
@@ -350,6 +616,120 @@ func (conn *Conn) Connected() bool {
 	return conn.ctx.Err() == nil
 }
 
+// Stats holds counters describing the activity of a connection, as returned
+// by Conn.Stats.
+type Stats struct {
+	// MessagesSent and MessagesReceived count the messages written to and
+	// read from the transport.
+	MessagesSent     uint64
+	MessagesReceived uint64
+
+	// PendingCalls is the number of method calls waiting for a reply.
+	PendingCalls int
+
+	// DroppedMessages counts the messages, including signals, that were
+	// discarded because the channel registered with Eavesdrop was full. It
+	// also counts the signals that the default signal handler was holding
+	// back for a full channel passed to Signal when the channel was removed
+	// or the connection closed.
+	DroppedMessages uint64
+}
+
+// Stats returns the current counters of conn, e.g. for exposing them as
+// metrics. The counters are read independently of each other, so they need
+// not be consistent with each other on a busy connection.
+func (conn *Conn) Stats() Stats {
+	return Stats{
+		MessagesSent:     conn.sentMessages.Load(),
+		MessagesReceived: conn.receivedMessages.Load(),
+		PendingCalls:     conn.PendingCalls(),
+		DroppedMessages:  conn.droppedMessages.Load(),
+	}
+}
+
+// PendingCalls returns the number of method calls made on conn that are
+// still waiting for a reply.
+func (conn *Conn) PendingCalls() int {
+	return conn.calls.pending()
+}
+
+// State describes the state of a connection, as reported to the functions
+// registered with AddStateListener.
+type State int
+
+const (
+	// StateConnecting is the state of a new connection until it has been
+	// authenticated.
+	StateConnecting State = iota
+	// StateConnected means that messages are being processed.
+	StateConnected
+	// StateDisconnected means that reading from the transport failed, e.g.
+	// because the other end closed it. The connection is closed right after.
+	StateDisconnected
+	// StateClosed means that the connection has been closed.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "invalid"
+}
+
+// State returns the current state of the connection.
+func (conn *Conn) State() State {
+	conn.stateLck.Lock()
+	defer conn.stateLck.Unlock()
+	return conn.state
+}
+
+// AddStateListener registers f to be called with the new state whenever the
+// state of the connection changes. f is called synchronously from the
+// goroutine causing the change, e.g. the one reading messages, so it must not
+// block.
+func (conn *Conn) AddStateListener(f func(State)) {
+	conn.stateLck.Lock()
+	conn.stateListeners = append(conn.stateListeners, f)
+	conn.stateLck.Unlock()
+}
+
+// setState changes the state of the connection and notifies the listeners.
+// A closed connection stays closed.
+func (conn *Conn) setState(s State) {
+	conn.stateLck.Lock()
+	if conn.state == s || conn.state == StateClosed {
+		conn.stateLck.Unlock()
+		return
+	}
+	conn.state = s
+	listeners := append([]func(State){}, conn.stateListeners...)
+	conn.stateLck.Unlock()
+	for _, f := range listeners {
+		f(s)
+	}
+}
+
+// ServerGUID returns the GUID the server sent when the connection was
+// authenticated, or an empty string if it has not been authenticated yet.
+func (conn *Conn) ServerGUID() string {
+	return conn.uuid
+}
+
+// AuthMechanism returns the name of the authentication mechanism that
+// succeeded, e.g. "EXTERNAL", or an empty string if the connection has not
+// been authenticated yet.
+func (conn *Conn) AuthMechanism() string {
+	return conn.authMechanism
+}
+
 // Eavesdrop causes conn to send all incoming messages to the given channel
 // without further processing. Method replies, errors and signals will not be
 // sent to the appropriate channels and method calls will not be handled. If nil
@@ -369,6 +749,15 @@ func (conn *Conn) getSerial() uint32 {
 	return conn.serialGen.GetSerial()
 }
 
+// Start makes conn process incoming messages without performing the
+// authentication conversation, for connections that have been authenticated by
+// other means, such as the accepting end of a peer-to-peer connection. It must
+// be called instead of Auth, before sending any messages.
+func (conn *Conn) Start() {
+	conn.setState(StateConnected)
+	go conn.inWorker()
+}
+
 // Hello sends the initial org.freedesktop.DBus.Hello call. This method must be
 // called after authentication, but before sending any other messages to the
 // bus. Hello must not be called for shared connections.
@@ -386,35 +775,50 @@ func (conn *Conn) Hello() error {
 // transport and dispatching them appropriately.
 func (conn *Conn) inWorker() {
 	sequenceGen := newSequenceGenerator()
+	dec := newDecoder(nil, nativeEndian, nil)
+	dec.limits = conn.decodeLimits
+	dec.orderedDicts = conn.orderedDicts
+	nc, _ := conn.TransportConn()
 	for {
-		msg, err := conn.ReadMessage()
+		if conn.readTimeout > 0 && nc != nil {
+			nc.SetReadDeadline(time.Now().Add(conn.readTimeout))
+		}
+		msg, err := conn.readRawMessage()
 		if err != nil {
 			if _, ok := err.(InvalidMessageError); !ok {
 				// Some read error occurred (usually EOF); we can't really do
 				// anything but to shut down all stuff and returns errors to all
 				// pending replies.
+				conn.setState(StateDisconnected)
 				conn.Close()
 				conn.calls.finalizeAllWithError(sequenceGen, err)
 				return
 			}
-			// invalid messages are ignored
+			conn.dropMessage(msg, sequenceGen, err)
 			continue
 		}
+		conn.receivedMessages.Add(1)
+		conn.checkMessageSize(msg)
+		if !conn.defersDecoding(msg) {
+			// The body has been read completely, so failing to decode it
+			// only affects this message.
+			if err := msg.decodeBody(dec); err != nil {
+				conn.dropMessage(msg, sequenceGen, err)
+				continue
+			}
+		}
 		conn.eavesdroppedLck.Lock()
 		if conn.eavesdropped != nil {
 			select {
 			case conn.eavesdropped <- msg:
 			default:
+				conn.droppedMessages.Add(1)
 			}
 			conn.eavesdroppedLck.Unlock()
 			continue
 		}
 		conn.eavesdroppedLck.Unlock()
-		dest, _ := msg.Headers[FieldDestination].value.(string)
-		found := dest == "" ||
-			!conn.names.uniqueNameIsKnown() ||
-			conn.names.isKnownName(dest)
-		if !found {
+		if !conn.isDestination(msg) {
 			// Eavesdropped a message, but no channel for it is registered.
 			// Ignore it.
 			continue
@@ -438,13 +842,43 @@ func (conn *Conn) inWorker() {
 	}
 }
 
+// dropMessage ignores the invalid message msg, which may be nil, and tells a
+// call waiting for it as its reply about err instead of letting it wait
+// forever.
+func (conn *Conn) dropMessage(msg *Message, sequenceGen *sequenceGenerator, err error) {
+	if msg == nil || (msg.Type != TypeMethodReply && msg.Type != TypeError) || !conn.isDestination(msg) {
+		return
+	}
+	if serial, ok := msg.Headers[FieldReplySerial].value.(uint32); ok {
+		conn.calls.finalizeWithError(serial, sequenceGen.next(), err)
+		conn.serialGen.RetireSerial(serial)
+	}
+}
+
+// isDestination returns whether msg is addressed to conn.
+func (conn *Conn) isDestination(msg *Message) bool {
+	dest, _ := msg.Headers[FieldDestination].value.(string)
+	return conn.peer || dest == "" ||
+		!conn.names.uniqueNameIsKnown() ||
+		conn.names.isKnownName(dest)
+}
+
+// defersDecoding returns whether the body of msg should be left undecoded
+// for the caller that is waiting for it.
+func (conn *Conn) defersDecoding(msg *Message) bool {
+	conn.eavesdroppedLck.Lock()
+	eavesdropping := conn.eavesdropped != nil
+	conn.eavesdroppedLck.Unlock()
+	return !eavesdropping && conn.calls.defersDecoding(msg)
+}
+
 func (conn *Conn) handleSignal(sequence Sequence, msg *Message) {
 	iface := msg.Headers[FieldInterface].value.(string)
 	member := msg.Headers[FieldMember].value.(string)
 	// as per http://dbus.freedesktop.org/doc/dbus-specification.html ,
 	// sender is optional for signals.
 	sender, _ := msg.Headers[FieldSender].value.(string)
-	if iface == "org.freedesktop.DBus" && sender == "org.freedesktop.DBus" {
+	if !conn.noHello && iface == "org.freedesktop.DBus" && sender == "org.freedesktop.DBus" {
 		if member == "NameLost" {
 			// If we lost the name on the bus, remove it from our
 			// tracking list.
@@ -469,6 +903,8 @@ func (conn *Conn) handleSignal(sequence Sequence, msg *Message) {
 		Name:     iface + "." + member,
 		Body:     msg.Body,
 		Sequence: sequence,
+		Serial:   msg.serial,
+		Headers:  msg.Headers,
 	}
 	conn.signalHandler.DeliverSignal(iface, member, signal)
 }
@@ -480,9 +916,54 @@ func (conn *Conn) Names() []string {
 	return conn.names.listKnownNames()
 }
 
+// NameChange describes a change of the set of well-known names owned by a
+// connection, as reported by Names.
+type NameChange struct {
+	// Name is the well-known name that was acquired or lost.
+	Name string
+	// Acquired is true if the connection now owns Name and false if it lost
+	// or released it.
+	Acquired bool
+}
+
+// NameChanges registers the given channel to be notified whenever a
+// well-known name is added to or removed from the list returned by Names,
+// whether that is caused by RequestName and ReleaseName or by the NameAcquired
+// and NameLost signals of the bus. Notifications are sent without blocking,
+// so ch should be buffered; if it is full, the notification is dropped and
+// Names can be used to find the current state. ch is closed when the
+// connection is closed.
+func (conn *Conn) NameChanges(ch chan<- NameChange) {
+	conn.names.addWatcher(ch)
+}
+
+// RemoveNameChanges removes the given channel from the list of channels
+// registered with NameChanges.
+func (conn *Conn) RemoveNameChanges(ch chan<- NameChange) {
+	conn.names.removeWatcher(ch)
+}
+
 // Object returns the object identified by the given destination name and path.
 func (conn *Conn) Object(dest string, path ObjectPath) BusObject {
-	return &Object{conn, dest, path}
+	return &Object{conn: conn, dest: dest, path: path}
+}
+
+// CallGeneric calls the method member, given as "interface.member", of the
+// object identified by dest and path, with args converted to the types in sig,
+// and waits for the reply, whose values are in the Body of the returned Call.
+// It allows calling any method without defining Go types for its arguments,
+// e.g. in a generic command line client: numbers are converted to the integer
+// or floating point type in sig if they can be represented, strings are parsed
+// like the arguments of MakeBody unless a string type is expected, slices or
+// structs may be given for D-Bus structs, and variants are unwrapped unless a
+// variant is expected. The error is that of the conversion or of the call.
+func (conn *Conn) CallGeneric(dest, path, member string, sig Signature, args []interface{}) (*Call, error) {
+	body, err := coerceBody(sig, args)
+	if err != nil {
+		return nil, err
+	}
+	call := conn.Object(dest, ObjectPath(path)).Call(member, 0, body...)
+	return call, call.Err
 }
 
 func (conn *Conn) sendMessageAndIfClosed(msg *Message, ifClosed func()) error {
@@ -492,10 +973,19 @@ func (conn *Conn) sendMessageAndIfClosed(msg *Message, ifClosed func()) error {
 	if conn.outInt != nil {
 		conn.outInt(msg)
 	}
+	if conn.sizeWarnFunc != nil {
+		msg.cacheBodySize()
+		defer msg.uncacheBodySize()
+		conn.checkMessageSize(msg)
+	}
 	err := conn.outHandler.sendAndIfClosed(msg, ifClosed)
 	if err != nil {
 		conn.handleSendError(msg, err)
-	} else if msg.Type != TypeMethodCall {
+		if conn.writeTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+			conn.setState(StateDisconnected)
+			conn.Close()
+		}
+	} else if msg.Type != TypeMethodCall || msg.Flags&FlagNoReplyExpected != 0 {
 		conn.serialGen.RetireSerial(msg.serial)
 	}
 	return err
@@ -533,15 +1023,28 @@ func (conn *Conn) handleSendError(msg *Message, err error) {
 // once the call is complete. Otherwise, ch is ignored and a Call structure is
 // returned of which only the Err member is valid.
 func (conn *Conn) Send(msg *Message, ch chan *Call) *Call {
-	return conn.send(context.Background(), msg, ch)
+	return conn.send(context.Background(), msg, ch, false, false)
 }
 
 // SendWithContext acts like Send but takes a context
 func (conn *Conn) SendWithContext(ctx context.Context, msg *Message, ch chan *Call) *Call {
-	return conn.send(ctx, msg, ch)
+	return conn.send(ctx, msg, ch, false, false)
 }
 
-func (conn *Conn) send(ctx context.Context, msg *Message, ch chan *Call) *Call {
+// SendAndWait sends the method call msg and waits for the reply, which is
+// returned as a whole, e.g. to inspect its serial or other header fields. If
+// the reply is an error message, it is returned along with the corresponding
+// Error. The call is aborted when ctx is done. It returns an error without
+// sending msg if msg is not a method call or doesn't expect a reply.
+func (conn *Conn) SendAndWait(ctx context.Context, msg *Message) (*Message, error) {
+	if msg.Type != TypeMethodCall || msg.Flags&FlagNoReplyExpected != 0 {
+		return nil, errors.New("dbus: SendAndWait needs a method call that expects a reply")
+	}
+	call := <-conn.send(ctx, msg, nil, false, true).Done
+	return call.replyMsg, call.Err
+}
+
+func (conn *Conn) send(ctx context.Context, msg *Message, ch chan *Call, deferred, keepReply bool) *Call {
 	if ctx == nil {
 		panic("nil context")
 	}
@@ -565,15 +1068,19 @@ func (conn *Conn) send(ctx context.Context, msg *Message, ch chan *Call) *Call {
 		call.Done = ch
 		call.ctx = ctx
 		call.ctxCanceler = canceler
+		call.deferred = deferred
+		call.keepReply = keepReply
+		call.limits = conn.decodeLimits
+		call.orderedDicts = conn.orderedDicts
 		conn.calls.track(msg.serial, call)
 		if ctx.Err() != nil {
 			// short path: don't even send the message if context already cancelled
-			conn.calls.handleSendError(msg, ctx.Err())
+			conn.cancelCall(msg, ctx.Err())
 			return call
 		}
 		go func() {
 			<-ctx.Done()
-			conn.calls.handleSendError(msg, ctx.Err())
+			conn.cancelCall(msg, ctx.Err())
 		}()
 		// error is handled in handleSendError
 		_ = conn.sendMessageAndIfClosed(msg, func() {
@@ -592,6 +1099,16 @@ func (conn *Conn) send(ctx context.Context, msg *Message, ch chan *Call) *Call {
 	return call
 }
 
+// cancelCall finalizes the call msg is for with err if it is still pending,
+// and retires its serial right away so that abandoned calls don't accumulate.
+// A reply that arrives later is ignored; the serial generator doesn't hand out
+// a retired serial again before wrapping around.
+func (conn *Conn) cancelCall(msg *Message, err error) {
+	if conn.calls.handleSendError(msg, err) {
+		conn.serialGen.RetireSerial(msg.serial)
+	}
+}
+
 // sendError creates an error message corresponding to the parameters and sends
 // it to conn.out.
 func (conn *Conn) sendError(err error, dest string, serial uint32) {
@@ -603,7 +1120,7 @@ func (conn *Conn) sendError(err error, dest string, serial uint32) {
 		e = em
 	case DBusError:
 		name, body := em.DBusError()
-		e = NewError(name, body)
+		e = &Error{name, body}
 	default:
 		e = MakeFailedError(err)
 	}
@@ -643,33 +1160,115 @@ func (conn *Conn) sendReply(dest string, serial uint32, values ...interface{}) {
 
 // AddMatchSignal registers the given match rule to receive broadcast
 // signals based on their contents.
+//
+// The call is bound to the connection's context, so it returns ErrClosed if
+// the connection is closed while waiting for the reply.
 func (conn *Conn) AddMatchSignal(options ...MatchOption) error {
-	return conn.AddMatchSignalContext(context.Background(), options...)
+	return conn.closedError(conn.AddMatchSignalContext(conn.ctx, options...))
 }
 
 // AddMatchSignalContext acts like AddMatchSignal but takes a context.
 func (conn *Conn) AddMatchSignalContext(ctx context.Context, options ...MatchOption) error {
+	_, err := conn.AddMatchSignalRuleContext(ctx, options...)
+	return err
+}
+
+// AddMatchSignalRule acts like AddMatchSignal, but returns the rule that was
+// registered. Removing it with its Remove method is guaranteed to remove
+// exactly this rule, without having to repeat the options.
+func (conn *Conn) AddMatchSignalRule(options ...MatchOption) (*MatchRule, error) {
+	rule, err := conn.AddMatchSignalRuleContext(conn.ctx, options...)
+	return rule, conn.closedError(err)
+}
+
+// AddMatchSignalRuleContext acts like AddMatchSignalRule but takes a context.
+func (conn *Conn) AddMatchSignalRuleContext(ctx context.Context, options ...MatchOption) (*MatchRule, error) {
 	options = append([]MatchOption{withMatchTypeSignal()}, options...)
-	return conn.busObj.CallWithContext(
+	rule := formatMatchOptions(options)
+	err := conn.busObj.CallWithContext(
 		ctx,
 		"org.freedesktop.DBus.AddMatch", 0,
-		formatMatchOptions(options),
+		rule,
 	).Store()
+	if err != nil {
+		return nil, err
+	}
+	conn.matchRulesLck.Lock()
+	conn.matchRules = append(conn.matchRules, rule)
+	conn.matchRulesLck.Unlock()
+	return &MatchRule{conn: conn, rule: rule}, nil
 }
 
 // RemoveMatchSignal removes the first rule that matches previously registered with AddMatchSignal.
+//
+// Like AddMatchSignal, it returns ErrClosed if the connection is closed while
+// waiting for the reply.
 func (conn *Conn) RemoveMatchSignal(options ...MatchOption) error {
-	return conn.RemoveMatchSignalContext(context.Background(), options...)
+	return conn.closedError(conn.RemoveMatchSignalContext(conn.ctx, options...))
+}
+
+// closedError returns ErrClosed in place of err if the call that returned err
+// was aborted because the connection has been closed. Errors sent by the
+// peer are passed through unchanged.
+func (conn *Conn) closedError(err error) error {
+	if err == nil || conn.ctx.Err() == nil {
+		return err
+	}
+	if _, ok := err.(Error); ok {
+		return err
+	}
+	return ErrClosed
 }
 
 // RemoveMatchSignalContext acts like RemoveMatchSignal but takes a context.
 func (conn *Conn) RemoveMatchSignalContext(ctx context.Context, options ...MatchOption) error {
 	options = append([]MatchOption{withMatchTypeSignal()}, options...)
-	return conn.busObj.CallWithContext(
+	return conn.removeMatchRule(ctx, formatMatchOptions(options))
+}
+
+// RemoveAllMatchSignals removes all rules that were added with AddMatchSignal
+// and not removed yet, e.g. to tear down the subscriptions of a connection
+// that stays in use. Rules that can't be removed stay registered, and the
+// errors of all failed removals are returned.
+func (conn *Conn) RemoveAllMatchSignals() error {
+	return conn.closedError(conn.RemoveAllMatchSignalsContext(conn.ctx))
+}
+
+// RemoveAllMatchSignalsContext acts like RemoveAllMatchSignals but takes a
+// context.
+func (conn *Conn) RemoveAllMatchSignalsContext(ctx context.Context) error {
+	conn.matchRulesLck.Lock()
+	rules := append([]string(nil), conn.matchRules...)
+	conn.matchRulesLck.Unlock()
+	var errs []error
+	for _, rule := range rules {
+		if err := conn.removeMatchRule(ctx, rule); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeMatchRule removes rule from the bus and, if that succeeds, stops
+// tracking one addition of it.
+func (conn *Conn) removeMatchRule(ctx context.Context, rule string) error {
+	err := conn.busObj.CallWithContext(
 		ctx,
 		"org.freedesktop.DBus.RemoveMatch", 0,
-		formatMatchOptions(options),
+		rule,
 	).Store()
+	if err != nil {
+		return err
+	}
+	conn.matchRulesLck.Lock()
+	for i, r := range conn.matchRules {
+		if r == rule {
+			conn.matchRules = append(conn.matchRules[:i], conn.matchRules[i+1:]...)
+			break
+		}
+	}
+	conn.matchRulesLck.Unlock()
+	return nil
 }
 
 // Signal registers the given channel to be passed all received signal messages.
@@ -710,16 +1309,46 @@ func (conn *Conn) SupportsUnixFDs() bool {
 	return conn.unixFD
 }
 
+// TransportConn returns the network connection that conn's transport uses,
+// e.g. a *net.UnixConn or a *net.TCPConn, which implement syscall.Conn to give
+// access to the socket, for example to set socket options like SO_RCVBUF or
+// to query the credentials of the peer. The boolean is false if there is no
+// such connection, e.g. for connections created with NewConn from something
+// other than a net.Conn.
+//
+// The returned connection is still in use by conn: it must not be read from,
+// written to or closed, and setting deadlines on it interferes with conn's
+// reading of messages. Only use it for operations that don't affect the data
+// flowing through it.
+func (conn *Conn) TransportConn() (net.Conn, bool) {
+	if t, ok := conn.transport.(interface{ netConn() (net.Conn, bool) }); ok {
+		return t.netConn()
+	}
+	return nil, false
+}
+
 // Error represents a D-Bus message of type Error.
 type Error struct {
 	Name string
 	Body []interface{}
 }
 
+// NewError returns an error with the given name and body. Use the ErrName*
+// constants for the standard errors. The name is not checked; an error with an
+// invalid name can't be sent (see NewValidError).
 func NewError(name string, body []interface{}) *Error {
 	return &Error{name, body}
 }
 
+// NewValidError acts like NewError, but returns an error if name is not a
+// valid error name, i.e. not formatted like an interface name.
+func NewValidError(name string, body []interface{}) (*Error, error) {
+	if !isValidInterface(name) {
+		return nil, fmt.Errorf("dbus: invalid error name %q", name)
+	}
+	return &Error{name, body}, nil
+}
+
 func (e Error) Error() string {
 	if len(e.Body) >= 1 {
 		s, ok := e.Body[0].(string)
@@ -730,14 +1359,62 @@ func (e Error) Error() string {
 	return e.Name
 }
 
+// Sentinel errors for the standard errors of org.freedesktop.DBus.Properties.
+// A D-Bus Error returned by a call, for example by GetProperty or SetProperty,
+// matches them with errors.Is if it has one of the corresponding names, both
+// those used by the prop package and those of the specification.
+var (
+	ErrPropNotFound  = errors.New("dbus: property not found")
+	ErrIfaceNotFound = errors.New("dbus: interface not found")
+	ErrReadOnly      = errors.New("dbus: property is read-only")
+)
+
+// Is reports whether e has the name of target, if target is a D-Bus Error,
+// or whether e is one of the errors described by the sentinel target.
+func (e Error) Is(target error) bool {
+	switch target {
+	case ErrPropNotFound:
+		return e.Name == "org.freedesktop.DBus.Properties.Error.PropertyNotFound" ||
+			e.Name == ErrNameUnknownProperty
+	case ErrIfaceNotFound:
+		return e.Name == "org.freedesktop.DBus.Properties.Error.InterfaceNotFound" ||
+			e.Name == ErrNameUnknownInterface
+	case ErrReadOnly:
+		return e.Name == "org.freedesktop.DBus.Properties.Error.ReadOnly" ||
+			e.Name == ErrNamePropertyReadOnly
+	}
+	switch t := target.(type) {
+	case Error:
+		return e.Name == t.Name
+	case *Error:
+		return t != nil && e.Name == t.Name
+	}
+	return false
+}
+
 // Signal represents a D-Bus message of type Signal. The name member is given in
-// "interface.member" notation, e.g. org.freedesktop.D-Bus.NameLost.
+// "interface.member" notation, e.g. org.freedesktop.D-Bus.NameLost. Serial is
+// the serial number the sender assigned to the message and Headers holds all
+// of its header fields.
 type Signal struct {
 	Sender   string
 	Path     ObjectPath
 	Name     string
 	Body     []interface{}
 	Sequence Sequence
+	Serial   uint32
+	Headers  map[HeaderField]Variant
+}
+
+// String returns a string representation of the signal similar to the format
+// of dbus-monitor.
+func (s *Signal) String() string {
+	str := "signal"
+	if s.Sender != "" {
+		str += " from " + s.Sender
+	}
+	str += " path " + string(s.Path) + " name " + s.Name
+	return str + formatBody(s.Body)
 }
 
 // transport is a D-Bus transport.
@@ -757,32 +1434,49 @@ type transport interface {
 	// Read / send a message, handling things like Unix FDs.
 	ReadMessage() (*Message, error)
 	SendMessage(*Message) error
+
+	// readRawMessage reads a message like ReadMessage, but leaves its body
+	// undecoded, so that the connection can decode it as configured or defer
+	// decoding it until a deferred call stores the reply.
+	readRawMessage() (*Message, error)
 }
 
 var transports = make(map[string]func(string) (transport, error))
 
-func getTransport(address string) (transport, error) {
-	var err error
-	var t transport
-
+// getTransport connects to the first usable address in address, which may
+// list several alternatives of any transport separated by semicolons, and
+// returns the transport along with the value of its guid key, if any. If no
+// address can be used, the errors for all of them are returned.
+func getTransport(address string) (transport, string, error) {
+	var errs []error
 	addresses := strings.Split(address, ";")
 	for _, v := range addresses {
-		i := strings.IndexRune(v, ':')
-		if i == -1 {
-			err = errors.New("dbus: invalid bus address (no transport)")
-			continue
-		}
-		f := transports[v[:i]]
-		if f == nil {
-			err = errors.New("dbus: invalid bus address (invalid or unsupported transport)")
-			continue
-		}
-		t, err = f(v[i+1:])
+		t, err := dialAddress(v)
 		if err == nil {
-			return t, nil
+			return t, getKey(v[strings.IndexRune(v, ':')+1:], "guid"), nil
+		}
+		if len(addresses) > 1 {
+			err = fmt.Errorf("%s: %w", v, err)
 		}
+		errs = append(errs, err)
 	}
-	return nil, err
+	if len(errs) == 1 {
+		return nil, "", errs[0]
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// dialAddress connects to the single address v.
+func dialAddress(v string) (transport, error) {
+	i := strings.IndexRune(v, ':')
+	if i == -1 {
+		return nil, errors.New("dbus: invalid bus address (no transport)")
+	}
+	f := transports[v[:i]]
+	if f == nil {
+		return nil, errors.New("dbus: invalid bus address (invalid or unsupported transport)")
+	}
+	return f(v[i+1:])
 }
 
 // getKey gets a key from a the list of keys. Returns "" on error / not found...
@@ -821,7 +1515,17 @@ func (h *outputHandler) sendAndIfClosed(msg *Message, ifClosed func()) error {
 	}
 	h.sendLck.Lock()
 	defer h.sendLck.Unlock()
-	return h.conn.SendMessage(msg)
+	if d := h.conn.writeTimeout; d > 0 {
+		if nc, ok := h.conn.TransportConn(); ok {
+			nc.SetWriteDeadline(time.Now().Add(d))
+			defer nc.SetWriteDeadline(time.Time{})
+		}
+	}
+	if err := h.conn.SendMessage(msg); err != nil {
+		return err
+	}
+	h.conn.sentMessages.Add(1)
+	return nil
 }
 
 func (h *outputHandler) close() {
@@ -862,9 +1566,11 @@ func (gen *serialGenerator) RetireSerial(serial uint32) {
 }
 
 type nameTracker struct {
-	lck    sync.RWMutex
-	unique string
-	names  map[string]struct{}
+	lck      sync.RWMutex
+	unique   string
+	names    map[string]struct{}
+	watchers []chan<- NameChange
+	closed   bool
 }
 
 func newNameTracker() *nameTracker {
@@ -875,18 +1581,73 @@ func (tracker *nameTracker) acquireUniqueConnectionName(name string) {
 	tracker.lck.Lock()
 	defer tracker.lck.Unlock()
 	tracker.unique = name
+	// The bus announces the unique name with NameAcquired, too, and the
+	// signal may be handled before the reply to Hello.
+	delete(tracker.names, name)
 }
 
 func (tracker *nameTracker) acquireName(name string) {
 	tracker.lck.Lock()
 	defer tracker.lck.Unlock()
+	if _, ok := tracker.names[name]; ok || name == tracker.unique {
+		return
+	}
 	tracker.names[name] = struct{}{}
+	tracker.notify(NameChange{Name: name, Acquired: true})
 }
 
 func (tracker *nameTracker) loseName(name string) {
 	tracker.lck.Lock()
 	defer tracker.lck.Unlock()
+	if _, ok := tracker.names[name]; !ok {
+		return
+	}
 	delete(tracker.names, name)
+	tracker.notify(NameChange{Name: name})
+}
+
+// notify sends c to all watchers that are ready to receive it. tracker.lck
+// must be held.
+func (tracker *nameTracker) notify(c NameChange) {
+	for _, ch := range tracker.watchers {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+func (tracker *nameTracker) addWatcher(ch chan<- NameChange) {
+	tracker.lck.Lock()
+	defer tracker.lck.Unlock()
+	if tracker.closed {
+		return
+	}
+	tracker.watchers = append(tracker.watchers, ch)
+}
+
+func (tracker *nameTracker) removeWatcher(ch chan<- NameChange) {
+	tracker.lck.Lock()
+	defer tracker.lck.Unlock()
+	for i := len(tracker.watchers) - 1; i >= 0; i-- {
+		if tracker.watchers[i] == ch {
+			tracker.watchers = append(tracker.watchers[:i], tracker.watchers[i+1:]...)
+		}
+	}
+}
+
+// terminate closes all watchers.
+func (tracker *nameTracker) terminate() {
+	tracker.lck.Lock()
+	defer tracker.lck.Unlock()
+	if tracker.closed {
+		return
+	}
+	for _, ch := range tracker.watchers {
+		close(ch)
+	}
+	tracker.watchers = nil
+	tracker.closed = true
 }
 
 func (tracker *nameTracker) uniqueNameIsKnown() bool {
@@ -922,6 +1683,12 @@ func newCallTracker() *callTracker {
 	return &callTracker{calls: map[uint32]*Call{}}
 }
 
+func (tracker *callTracker) pending() int {
+	tracker.lck.RLock()
+	defer tracker.lck.RUnlock()
+	return len(tracker.calls)
+}
+
 func (tracker *callTracker) track(sn uint32, call *Call) {
 	tracker.lck.Lock()
 	tracker.calls[sn] = call
@@ -934,36 +1701,64 @@ func (tracker *callTracker) handleReply(sequence Sequence, msg *Message) uint32
 	_, ok := tracker.calls[serial]
 	tracker.lck.RUnlock()
 	if ok {
-		tracker.finalizeWithBody(serial, sequence, msg.Body)
+		tracker.finalizeWithReply(serial, sequence, msg)
 	}
 	return serial
 }
 
+func (tracker *callTracker) defersDecoding(msg *Message) bool {
+	if msg.Type != TypeMethodReply {
+		return false
+	}
+	serial, _ := msg.Headers[FieldReplySerial].value.(uint32)
+	tracker.lck.RLock()
+	c, ok := tracker.calls[serial]
+	tracker.lck.RUnlock()
+	return ok && c.deferred
+}
+
 func (tracker *callTracker) handleDBusError(sequence Sequence, msg *Message) uint32 {
 	serial := msg.Headers[FieldReplySerial].value.(uint32)
 	tracker.lck.RLock()
 	_, ok := tracker.calls[serial]
 	tracker.lck.RUnlock()
 	if ok {
-		name, _ := msg.Headers[FieldErrorName].value.(string)
-		tracker.finalizeWithError(serial, sequence, Error{name, msg.Body})
+		tracker.finalizeWithErrorReply(serial, sequence, msg)
 	}
 	return serial
 }
 
-func (tracker *callTracker) handleSendError(msg *Message, err error) {
+// handleSendError finalizes the call for msg with err, and returns whether it
+// was still pending.
+func (tracker *callTracker) handleSendError(msg *Message, err error) bool {
 	if err == nil {
-		return
+		return false
 	}
-	tracker.lck.RLock()
-	_, ok := tracker.calls[msg.serial]
-	tracker.lck.RUnlock()
+	return tracker.finalizeWithError(msg.serial, NoSequence, err)
+}
+
+func (tracker *callTracker) finalizeWithReply(sn uint32, sequence Sequence, msg *Message) {
+	tracker.lck.Lock()
+	c, ok := tracker.calls[sn]
+	if ok {
+		delete(tracker.calls, sn)
+	}
+	tracker.lck.Unlock()
 	if ok {
-		tracker.finalizeWithError(msg.serial, NoSequence, err)
+		c.Body = msg.Body
+		if msg.rawOrder != nil {
+			c.reply = msg
+		}
+		if c.keepReply {
+			c.replyMsg = msg
+		}
+		c.ResponseSequence = sequence
+		c.done()
 	}
 }
 
-func (tracker *callTracker) finalizeWithBody(sn uint32, sequence Sequence, body []interface{}) {
+// finalizeWithErrorReply finalizes the call sn with the error reply msg.
+func (tracker *callTracker) finalizeWithErrorReply(sn uint32, sequence Sequence, msg *Message) {
 	tracker.lck.Lock()
 	c, ok := tracker.calls[sn]
 	if ok {
@@ -971,13 +1766,17 @@ func (tracker *callTracker) finalizeWithBody(sn uint32, sequence Sequence, body
 	}
 	tracker.lck.Unlock()
 	if ok {
-		c.Body = body
+		name, _ := msg.Headers[FieldErrorName].value.(string)
+		c.Err = Error{name, msg.Body}
+		if c.keepReply {
+			c.replyMsg = msg
+		}
 		c.ResponseSequence = sequence
 		c.done()
 	}
 }
 
-func (tracker *callTracker) finalizeWithError(sn uint32, sequence Sequence, err error) {
+func (tracker *callTracker) finalizeWithError(sn uint32, sequence Sequence, err error) bool {
 	tracker.lck.Lock()
 	c, ok := tracker.calls[sn]
 	if ok {
@@ -989,6 +1788,7 @@ func (tracker *callTracker) finalizeWithError(sn uint32, sequence Sequence, err
 		c.ResponseSequence = sequence
 		c.done()
 	}
+	return ok
 }
 
 func (tracker *callTracker) finalizeAllWithError(sequenceGen *sequenceGenerator, err error) {
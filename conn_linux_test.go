@@ -2,10 +2,18 @@ package dbus
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 // tests whether AUTH EXTERNAL is successful connecting to
@@ -90,3 +98,688 @@ func startDaemonInDifferentUserNamespace(t *testing.T) (string, *os.Process) {
 	}
 	return string(l), cmd.Process
 }
+
+// acceptPeerAuth plays the server side of the authentication conversation on
+// rw, accepting any mechanism. It reads byte by byte so that nothing after
+// BEGIN is consumed.
+func acceptPeerAuth(rw io.ReadWriter) error {
+	var b [1]byte
+	if _, err := rw.Read(b[:]); err != nil {
+		return err
+	}
+	var line []byte
+	for {
+		if _, err := rw.Read(b[:]); err != nil {
+			return err
+		}
+		line = append(line, b[0])
+		if b[0] != '\n' {
+			continue
+		}
+		var resp string
+		switch s := string(line); {
+		case s == "BEGIN\r\n":
+			return nil
+		case s == "AUTH\r\n":
+			resp = "REJECTED EXTERNAL\r\n"
+		case strings.HasPrefix(s, "AUTH "):
+			resp = "OK 0123456789abcdef0123456789abcdef\r\n"
+		default:
+			resp = "ERROR\r\n"
+		}
+		if _, err := io.WriteString(rw, resp); err != nil {
+			return err
+		}
+		line = line[:0]
+	}
+}
+
+func socketPair(t *testing.T) (net.Conn, net.Conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns [2]net.Conn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		conns[i], err = net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conns[0], conns[1]
+}
+
+func TestPeerToPeer(t *testing.T) {
+	c1, c2 := socketPair(t)
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		if err := acceptPeerAuth(c2); err != nil {
+			t.Errorf("authentication failed on the accepting side: %v", err)
+			close(accepted)
+			return
+		}
+		srv, err := NewConn(c2, WithPeerToPeer())
+		if err != nil {
+			t.Error(err)
+			close(accepted)
+			return
+		}
+		if err := srv.Export(server{}, "/org/godbus/DBus/Peer", "org.godbus.DBus.Peer"); err != nil {
+			t.Error(err)
+		}
+		srv.Start()
+		accepted <- srv
+	}()
+
+	client, err := NewConn(c1, WithPeerToPeer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Export(server{}, "/org/godbus/DBus/Client", "org.godbus.DBus.Client"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Auth([]Auth{AuthExternal("")}); err != nil {
+		t.Fatal(err)
+	}
+	srv := <-accepted
+	if srv == nil {
+		t.FailNow()
+	}
+	defer srv.Close()
+
+	var got int64
+	err = client.Object("org.godbus.Ignored", "/org/godbus/DBus/Peer").Call("org.godbus.DBus.Peer.Double", 0, int64(2)).Store(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 4 {
+		t.Errorf("got %d from the accepting side, want 4", got)
+	}
+	err = srv.Object("", "/org/godbus/DBus/Client").Call("org.godbus.DBus.Client.Double", 0, int64(21)).Store(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 42 {
+		t.Errorf("got %d from the connecting side, want 42", got)
+	}
+}
+
+func TestWithoutAuth(t *testing.T) {
+	c1, c2 := socketPair(t)
+	var conns [2]*Conn
+	for i, c := range []net.Conn{c1, c2} {
+		conn, err := NewConn(c, WithoutAuth(), WithPeerToPeer())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := conn.Auth(nil); err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	if err := conns[1].Export(server{}, "/org/godbus/DBus/Peer", "org.godbus.DBus.Peer"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got int64
+	err := conns[0].Object("", "/org/godbus/DBus/Peer").Call("org.godbus.DBus.Peer.Double", 0, int64(8)).Store(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 16 {
+		t.Errorf("got %d, want 16", got)
+	}
+
+	ch := make(chan *Signal, 1)
+	conns[0].Signal(ch)
+	if err := conns[1].Emit("/org/godbus/DBus/Peer", "org.godbus.DBus.Peer.Ping", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sig := <-ch:
+		if sig.Name != "org.godbus.DBus.Peer.Ping" || len(sig.Body) != 1 || sig.Body[0] != "hello" {
+			t.Errorf("unexpected signal: %+v", sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+}
+
+func TestInvalidBodyDropped(t *testing.T) {
+	c1, c2 := socketPair(t)
+	defer c2.Close()
+	conn, err := NewConn(c1, WithoutAuth(), WithPeerToPeer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Auth(nil); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *Signal, 2)
+	conn.Signal(signals)
+
+	// a boolean with the value 2, which fails to decode
+	invalidBody := func(msg *Message) []byte {
+		msg.Headers[FieldSignature] = MakeVariant(Signature{"b"})
+		msg.Body = []interface{}{uint32(2)}
+		return mustEncode(t, msg)
+	}
+	signal := func(member string) *Message {
+		return &Message{
+			Type:   TypeSignal,
+			serial: 1,
+			Headers: map[HeaderField]Variant{
+				FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/Peer")),
+				FieldInterface: MakeVariant("org.godbus.DBus.Peer"),
+				FieldMember:    MakeVariant(member),
+			},
+		}
+	}
+
+	call := conn.Object("", "/org/godbus/DBus/Peer").Go("org.godbus.DBus.Peer.Get", 0, nil)
+	req, err := DecodeMessage(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := &Message{
+		Type:    TypeMethodReply,
+		serial:  2,
+		Headers: map[HeaderField]Variant{FieldReplySerial: MakeVariant(req.serial)},
+	}
+	if _, err := c2.Write(invalidBody(reply)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case call := <-call.Done:
+		if _, ok := call.Err.(FormatError); !ok {
+			t.Errorf("got %v for a reply with an invalid body, want a FormatError", call.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the call to fail")
+	}
+
+	if _, err := c2.Write(invalidBody(signal("Invalid"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Write(mustEncode(t, signal("Valid"))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sig := <-signals:
+		if sig.Name != "org.godbus.DBus.Peer.Valid" {
+			t.Errorf("got signal %s, want only the valid one", sig.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the valid signal")
+	}
+	if !conn.Connected() {
+		t.Error("connection was closed because of an invalid body")
+	}
+}
+
+func TestTransportConn(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	nc, ok := bus.TransportConn()
+	if !ok {
+		t.Fatal("expected the session bus connection to have a transport connection")
+	}
+	sc, ok := nc.(syscall.Conn)
+	if !ok {
+		t.Fatalf("expected %T to implement syscall.Conn", nc)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rcvbuf int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		rcvbuf, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	if rcvbuf <= 0 {
+		t.Errorf("got SO_RCVBUF %d", rcvbuf)
+	}
+	// the connection keeps working
+	if err := bus.BusObject().Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	piped, err := NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer piped.Close()
+	if nc, ok := piped.TransportConn(); !ok || nc != c1 {
+		t.Errorf("got %v, %v for a connection over a net.Conn", nc, ok)
+	}
+
+	other, err := NewConn(rwc{Reader: strings.NewReader(""), Writer: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, ok := other.TransportConn(); ok {
+		t.Error("expected no transport connection for a connection over a plain io.ReadWriteCloser")
+	}
+}
+
+type fdKeeper chan int
+
+func (k fdKeeper) Keep(fd UnixFD) *Error {
+	dup, err := DupFD(fd)
+	if err != nil {
+		return MakeFailedError(err)
+	}
+	syscall.Close(int(fd))
+	k <- dup
+	return nil
+}
+
+func TestDupFDRetainedAfterHandler(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if !srv.SupportsUnixFDs() || !cli.SupportsUnixFDs() {
+		t.Skip("unix fd passing not supported")
+	}
+
+	kept := make(fdKeeper, 1)
+	if err := srv.Export(kept, "/org/godbus/DBus/FD", "org.godbus.DBus.FD"); err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	err = cli.Object(srv.Names()[0], "/org/godbus/DBus/FD").Call("org.godbus.DBus.FD.Keep", 0, UnixFD(r.Fd())).Err
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := os.NewFile(uintptr(<-kept), "kept")
+	defer f.Close()
+	if _, err := w.WriteString("ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("read %q from the retained fd, want %q", buf, "ping")
+	}
+}
+
+func TestEmitUnixFD(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if !srv.SupportsUnixFDs() || !cli.SupportsUnixFDs() {
+		t.Skip("unix fd passing not supported")
+	}
+
+	if err := cli.AddMatchSignal(WithMatchInterface("org.godbus.DBus.FD"), WithMatchSender(srv.Names()[0])); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *Signal, 1)
+	cli.Signal(signals)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	err = srv.Emit("/org/godbus/DBus/FD", "org.godbus.DBus.FD.Pipe", "pipe", UnixFD(r.Fd()))
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sig *Signal
+	select {
+	case sig = <-signals:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the signal")
+	}
+	if len(sig.Body) != 2 {
+		t.Fatalf("unexpected signal body %v", sig.Body)
+	}
+	fd, ok := sig.Body[1].(UnixFD)
+	if !ok {
+		t.Fatalf("got %T in the signal body, want UnixFD", sig.Body[1])
+	}
+	f := os.NewFile(uintptr(fd), "received")
+	defer f.Close()
+	if _, err := w.WriteString("ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("read %q from the received fd, want %q", buf, "ping")
+	}
+}
+
+type streamServer struct {
+	path string
+}
+
+func (s streamServer) Open() (*os.File, *Error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, MakeFailedError(err)
+	}
+	return f, nil
+}
+
+func (s streamServer) Stream() (io.Reader, *Error) {
+	return strings.NewReader("streamed contents"), nil
+}
+
+func (s streamServer) Truncated() (io.Reader, *Error) {
+	return io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("read failed"))), nil
+}
+
+// readableString implements io.Reader, but is not declared as one.
+type readableString string
+
+func (s readableString) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (s streamServer) Name() (readableString, *Error) {
+	return "name", nil
+}
+
+func TestExportReturnsFile(t *testing.T) {
+	srv, err := ConnectSessionBus(WithAutoIntrospection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if !srv.SupportsUnixFDs() || !cli.SupportsUnixFDs() {
+		t.Skip("unix fd passing not supported")
+	}
+
+	path := t.TempDir() + "/data"
+	if err := os.WriteFile(path, []byte("file contents"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Export(streamServer{path}, "/org/godbus/DBus/Stream", "org.godbus.DBus.Stream"); err != nil {
+		t.Fatal(err)
+	}
+	obj := cli.Object(srv.Names()[0], "/org/godbus/DBus/Stream")
+	for method, want := range map[string]string{
+		"Open":   "file contents",
+		"Stream": "streamed contents",
+	} {
+		var fd UnixFD
+		if err := obj.Call("org.godbus.DBus.Stream."+method, 0).Store(&fd); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		f := fd.File(method)
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: read %q, want %q", method, b, want)
+		}
+	}
+
+	var fd UnixFD
+	if err := obj.Call("org.godbus.DBus.Stream.Truncated", 0).Store(&fd); err != nil {
+		t.Fatal(err)
+	}
+	f := fd.File("Truncated")
+	b, err := io.ReadAll(f)
+	f.Close()
+	if string(b) != "partial" || !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Truncated: read %q, %v; want %q, %v", b, err, "partial", syscall.ECONNRESET)
+	}
+
+	var name string
+	if err := obj.Call("org.godbus.DBus.Stream.Name", 0).Store(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "name" {
+		t.Errorf("Name: got %q, want %q", name, "name")
+	}
+
+	var xml string
+	if err := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(xml, `<arg type="h" direction="out"/>`); n != 3 {
+		t.Errorf("got %d file descriptor results in the introspection data, want 3:\n%s", n, xml)
+	}
+}
+
+func TestUnixTransportProtoVersion(t *testing.T) {
+	c1, c2 := socketPair(t)
+	defer c1.Close()
+	defer c2.Close()
+	tr := &unixTransport{UnixConn: c1.(*net.UnixConn)}
+	if _, err := c2.Write(versionedMessages(t, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.ReadMessage(); err != InvalidMessageError("unsupported protocol version") {
+		t.Fatalf("got %v reading a message of version 0, want unsupported protocol version", err)
+	}
+	msg, err := tr.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading the message after it: %v", err)
+	}
+	if member := msg.Headers[FieldMember].Value(); member != "Second" {
+		t.Errorf("got member %v after the skipped message, want Second", member)
+	}
+}
+
+func TestUnixTransportReadMessageDecodesBody(t *testing.T) {
+	c1, c2 := socketPair(t)
+	defer c1.Close()
+	defer c2.Close()
+	tr := &unixTransport{UnixConn: c1.(*net.UnixConn)}
+	tr.EnableUnixFDs()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	msg := &Message{
+		Type: TypeSignal,
+		Headers: map[HeaderField]Variant{
+			FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/FD")),
+			FieldInterface: MakeVariant("org.godbus.DBus.FD"),
+			FieldMember:    MakeVariant("Body"),
+		},
+		Body: []interface{}{"body", UnixFD(w.Fd())},
+	}
+	msg.Headers[FieldSignature] = MakeVariant(SignatureOf(msg.Body...))
+	msg.Headers[FieldUnixFDs] = MakeVariant(uint32(1))
+	var buf bytes.Buffer
+	fds, err := msg.EncodeToWithFDs(&buf, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c2.(*net.UnixConn).WriteMsgUnix(buf.Bytes(), syscall.UnixRights(fds...), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rmsg, err := tr.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rmsg.Body) != 2 || rmsg.Body[0] != "body" {
+		t.Fatalf("got body %#v, want it to be decoded", rmsg.Body)
+	}
+	fd, ok := rmsg.Body[1].(UnixFD)
+	if !ok {
+		t.Fatalf("got %T for the fd, want UnixFD", rmsg.Body[1])
+	}
+	f := fd.File("fd")
+	defer f.Close()
+	if _, err := f.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err := r.Read(b); err != nil || b[0] != 'x' {
+		t.Errorf("got %q, %v reading what was written to the received fd", b, err)
+	}
+}
+
+func TestUnixTransportClosesFDsOfBadMessages(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		enableFDs bool
+	}{
+		{"BodyDecodeFails", true},
+		{"FDsNotEnabled", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c1, c2 := socketPair(t)
+			defer c1.Close()
+			defer c2.Close()
+			tr := &unixTransport{UnixConn: c1.(*net.UnixConn)}
+			if tc.enableFDs {
+				tr.EnableUnixFDs()
+			}
+
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			msg := &Message{
+				Type: TypeSignal,
+				Headers: map[HeaderField]Variant{
+					FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/FD")),
+					FieldInterface: MakeVariant("org.godbus.DBus.FD"),
+					FieldMember:    MakeVariant("Leak"),
+				},
+				Body: []interface{}{UnixFD(r.Fd()), Signature{"s"}},
+			}
+			msg.Headers[FieldSignature] = MakeVariant(SignatureOf(msg.Body...))
+			msg.Headers[FieldUnixFDs] = MakeVariant(uint32(1))
+			var buf bytes.Buffer
+			fds, err := msg.EncodeToWithFDs(&buf, binary.LittleEndian)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// turn the signature at the end of the body into an invalid one
+			b := buf.Bytes()
+			b[len(b)-2] = '('
+			if _, _, err := c2.(*net.UnixConn).WriteMsgUnix(b, syscall.UnixRights(fds...), nil); err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+
+			if _, err := tr.ReadMessage(); err == nil {
+				t.Fatal("bad message was accepted")
+			}
+			if _, err := w.Write([]byte{0}); !errors.Is(err, syscall.EPIPE) {
+				t.Errorf("got %v writing to the pipe, want EPIPE as the received fd should be closed", err)
+			}
+		})
+	}
+}
+
+func TestStoreChanClosesUndeliveredFDs(t *testing.T) {
+	var fds, ws []int
+	for i := 0; i < 2; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		fd, err := syscall.Dup(int(r.Fd()))
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds = append(fds, fd)
+		ws = append(ws, int(w.Fd()))
+	}
+	msg := &Message{
+		Type: TypeMethodReply,
+		Headers: map[HeaderField]Variant{
+			FieldReplySerial: MakeVariant(uint32(1)),
+			FieldUnixFDs:     MakeVariant(uint32(2)),
+		},
+		// the second index is out of range, so decoding fails after the
+		// first fd has been sent to the channel
+		Body: []interface{}{[]UnixFDIndex{0, 5, 1}},
+	}
+	msg.Headers[FieldSignature] = MakeVariant(SignatureOf(msg.Body...))
+	var buf bytes.Buffer
+	if err := msg.EncodeTo(&buf, binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	rmsg, err := readMessage(&buf, fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan UnixFD, 3)
+	ok, err := rmsg.decodeBodyToChan(newDecoder(nil, nativeEndian, nil), []interface{}{ch})
+	if !ok || err == nil {
+		t.Fatalf("got %v, %v; want the body to be streamed and fail", ok, err)
+	}
+	var got []UnixFD
+	for fd := range ch {
+		got = append(got, fd)
+	}
+	if len(got) != 1 || int(got[0]) != fds[0] {
+		t.Fatalf("got %v from the channel, want [%d]", got, fds[0])
+	}
+	defer syscall.Close(fds[0])
+	if _, err := syscall.Write(ws[0], []byte{0}); err != nil {
+		t.Errorf("got %v writing to the pipe of the delivered fd, want it to stay open", err)
+	}
+	if _, err := syscall.Write(ws[1], []byte{0}); !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("got %v writing to the pipe of the undelivered fd, want EPIPE", err)
+	}
+}
@@ -1,11 +1,18 @@
 package dbus
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -31,6 +38,67 @@ func TestSessionBus(t *testing.T) {
 	}
 }
 
+func TestSetSessionBus(t *testing.T) {
+	shared, err := SessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer private.Close()
+
+	SetSessionBus(private)
+	if conn, err := SessionBus(); err != nil || conn != private {
+		t.Fatalf("expected the injected connection, got %p (%v)", conn, err)
+	}
+
+	SetSessionBus(nil)
+	fresh, err := SessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == private || fresh == shared {
+		t.Error("expected a fresh connection after resetting")
+	}
+	if !shared.Connected() {
+		t.Error("resetting should not close the previous connection")
+	}
+	shared.Close()
+}
+
+func TestSessionBusWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	SetSessionBus(nil)
+	conn, err := SessionBus(WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, err := SessionBus(); err != nil || same != conn {
+		t.Fatalf("expected the shared connection, got %p (%v)", same, err)
+	}
+
+	cancel()
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelling the context didn't close the shared connection")
+	}
+	if conn.Connected() {
+		t.Error("expected the shared connection to be closed")
+	}
+	fresh, err := SessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == conn || !fresh.Connected() {
+		t.Error("expected a new shared connection after the old one was closed")
+	}
+}
+
 func TestSystemBus(t *testing.T) {
 	oldConn, err := SystemBus()
 	if err != nil {
@@ -350,6 +418,96 @@ func waitSignal(sigc <-chan *Signal, name string) *Signal {
 	}
 }
 
+func TestAddMatchSignalReturnsOnClose(t *testing.T) {
+	// The peer never answers, so AddMatch stays in flight until Close.
+	reader, pipewriter := io.Pipe()
+	defer pipewriter.Close()
+	bus, err := NewConn(rwc{Reader: reader, Writer: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bus.AddMatchSignal(WithMatchInterface("org.godbus.DBus.Test"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	bus.Close()
+	select {
+	case err := <-errc:
+		if err != ErrClosed {
+			t.Errorf("got %v, want ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddMatchSignal did not return after Close")
+	}
+	if err := bus.RemoveMatchSignal(WithMatchInterface("org.godbus.DBus.Test")); err != ErrClosed {
+		t.Errorf("got %v from RemoveMatchSignal, want ErrClosed", err)
+	}
+}
+
+func TestRemoveAllMatchSignals(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	rules := [][]MatchOption{
+		{WithMatchInterface("org.godbus.DBus.Test1")},
+		{WithMatchInterface("org.godbus.DBus.Test2"), WithMatchMember("Foo")},
+		{WithMatchInterface("org.godbus.DBus.Test2"), WithMatchMember("Foo")},
+		{WithMatchObjectPath("/org/godbus/DBus/Test")},
+	}
+	for _, rule := range rules {
+		if err := bus.AddMatchSignal(rule...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bus.RemoveMatchSignal(rules[0]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.RemoveAllMatchSignals(); err != nil {
+		t.Fatal(err)
+	}
+	// The bus reports rules that aren't registered (anymore).
+	for _, rule := range rules {
+		var dbusErr Error
+		if err := bus.RemoveMatchSignal(rule...); !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.DBus.Error.MatchRuleNotFound" {
+			t.Errorf("got %v from RemoveMatchSignal, want MatchRuleNotFound", err)
+		}
+	}
+	if err := bus.RemoveAllMatchSignals(); err != nil {
+		t.Errorf("got %v from RemoveAllMatchSignals without rules", err)
+	}
+}
+
+func TestMatchSignalRuleRemove(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	rule, err := bus.AddMatchSignalRule(WithMatchInterface("org.godbus.DBus.Test"), WithMatchMember("Foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "type='signal',interface='org.godbus.DBus.Test',member='Foo'"; rule.String() != want {
+		t.Errorf("got rule %q, want %q", rule, want)
+	}
+	if err := rule.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.RemoveAllMatchSignals(); err != nil {
+		t.Errorf("got %v from RemoveAllMatchSignals after removing the rule", err)
+	}
+	if err := rule.Remove(); !errors.Is(err, Error{Name: ErrNameMatchRuleNotFound}) {
+		t.Errorf("got %v removing the rule twice, want MatchRuleNotFound", err)
+	}
+}
+
 const (
 	SCPPInterface         = "org.godbus.DBus.StatefulTest"
 	SCPPPath              = "/org/godbus/DBus/StatefulTest"
@@ -804,6 +962,102 @@ func TestCancellingContextClosesConnection(t *testing.T) {
 	}
 }
 
+func TestStateListener(t *testing.T) {
+	reader, pipewriter := io.Pipe()
+	defer reader.Close()
+	bus, err := NewConn(rwc{Reader: reader, Writer: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := bus.State(); s != StateConnecting {
+		t.Errorf("got state %v for a new connection, want %v", s, StateConnecting)
+	}
+	var mu sync.Mutex
+	var states []State
+	closed := make(chan struct{})
+	bus.AddStateListener(func(s State) {
+		mu.Lock()
+		states = append(states, s)
+		mu.Unlock()
+		if s == StateClosed {
+			close(closed)
+		}
+	})
+
+	go func() {
+		_, err := pipewriter.Write([]byte("REJECTED name\r\nOK myuuid\r\n"))
+		if err != nil {
+			t.Errorf("error writing to pipe: %v", err)
+		}
+		// the transport hits EOF after authentication
+		pipewriter.Close()
+	}()
+	if err := bus.Auth([]Auth{fakeAuth{}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the connection to be closed")
+	}
+	bus.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []State{StateConnected, StateDisconnected, StateClosed}
+	if fmt.Sprint(states) != fmt.Sprint(want) {
+		t.Errorf("got states %v, want %v", states, want)
+	}
+	if s := bus.State(); s != StateClosed {
+		t.Errorf("got final state %v, want %v", s, StateClosed)
+	}
+}
+
+func TestReliableSignals(t *testing.T) {
+	receiver, err := ConnectSessionBus(WithReliableSignals())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	emitter, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	const count = 200
+	signals := make(chan *Signal, 1)
+	receiver.Signal(signals)
+	for i := 0; i < count; i++ {
+		err := emitter.EmitWithOptions("/org/godbus/DBus/Burst", "org.godbus.DBus.Burst.Tick",
+			[]EmitOption{WithEmitDestination(receiver.Names()[0])}, uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// wait for the burst to be queued up before reading anything
+	if err := emitter.BusObject().Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	timeout := time.After(5 * time.Second)
+	for next := uint32(0); next < count; {
+		select {
+		case sig := <-signals:
+			if sig.Name != "org.godbus.DBus.Burst.Tick" {
+				continue
+			}
+			if n := sig.Body[0].(uint32); n != next {
+				t.Fatalf("got signal %d, want %d", n, next)
+			}
+			next++
+		case <-timeout:
+			t.Fatalf("timed out after %d of %d signals", next, count)
+		}
+	}
+}
+
 // TestTimeoutContextClosesConnection checks that a Conn instance is closed after
 // the passed context's deadline is missed.
 // The test also checks that there's no data race between Conn creation and its
@@ -825,3 +1079,860 @@ func TestTimeoutContextClosesConnection(t *testing.T) {
 		t.Errorf("expected connection to be closed, but got: %v", err)
 	}
 }
+
+func TestDefaultFlags(t *testing.T) {
+	flags := make(chan Flags, 1)
+	bus, err := ConnectSessionBus(
+		WithDefaultFlags(FlagNoAutoStart),
+		WithOutgoingInterceptor(func(msg *Message) {
+			if msg.Type == TypeMethodCall && msg.Headers[FieldMember].value == "Ping" {
+				flags <- msg.Flags
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	if err := bus.BusObject().Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	if f := <-flags; f != FlagNoAutoStart {
+		t.Errorf("expected flags %#x, got %#x", FlagNoAutoStart, f)
+	}
+
+	obj := bus.BusObject().(*Object).WithoutDefaultFlags()
+	if err := obj.Call("org.freedesktop.DBus.Peer.Ping", FlagAllowInteractiveAuthorization).Err; err != nil {
+		t.Fatal(err)
+	}
+	if f := <-flags; f != FlagAllowInteractiveAuthorization {
+		t.Errorf("expected flags %#x without the defaults, got %#x", FlagAllowInteractiveAuthorization, f)
+	}
+}
+
+func TestServerGUIDAndAuthMechanism(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	guid := bus.ServerGUID()
+	if len(guid) != 32 {
+		t.Errorf("expected a 32 character GUID, got %q", guid)
+	}
+	if _, err := hex.DecodeString(guid); err != nil {
+		t.Errorf("expected a hex GUID, got %q: %v", guid, err)
+	}
+	if bus.AuthMechanism() == "" {
+		t.Error("expected the auth mechanism to be set")
+	}
+}
+
+func TestAuthExternalIdentity(t *testing.T) {
+	for _, tc := range []struct {
+		user string
+		want string
+	}{
+		{"", "\x00AUTH\r\nAUTH EXTERNAL\r\nBEGIN\r\n"},
+		{"1000", "\x00AUTH\r\nAUTH EXTERNAL 31303030\r\nBEGIN\r\n"},
+	} {
+		reader, pipewriter := io.Pipe()
+		var out bytes.Buffer
+		bus, err := NewConn(rwc{Reader: reader, Writer: &out})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_, err := pipewriter.Write([]byte("REJECTED EXTERNAL\r\nOK 0123456789abcdef0123456789abcdef\r\n"))
+			if err != nil {
+				t.Errorf("error writing to pipe: %v", err)
+			}
+		}()
+		if err := bus.Auth([]Auth{AuthExternal(tc.user)}); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != tc.want {
+			t.Errorf("user %q: sent %q, want %q", tc.user, got, tc.want)
+		}
+		bus.Close()
+		pipewriter.Close()
+		reader.Close()
+	}
+}
+
+func TestAuthExternalNoIdentity(t *testing.T) {
+	bus, err := SessionBusPrivate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	if err = bus.Auth([]Auth{AuthExternal("")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = bus.Hello(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthMechanismOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []ConnOption
+		want string
+	}{
+		{"ClientOrder", []ConnOption{WithAuth(AuthAnonymous(), AuthExternal(""))}, "AUTH ANONYMOUS\r\n"},
+		{"Preference", []ConnOption{
+			WithAuth(AuthExternal(""), AuthAnonymous()),
+			WithAuthMechanisms([]string{"DBUS_COOKIE_SHA1", "ANONYMOUS", "EXTERNAL"}),
+		}, "AUTH ANONYMOUS\r\n"},
+		{"Restricted", []ConnOption{
+			WithAuth(AuthAnonymous(), AuthExternal("")),
+			WithAuthMechanisms([]string{"EXTERNAL"}),
+		}, "AUTH EXTERNAL\r\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reader, pipewriter := io.Pipe()
+			defer reader.Close()
+			var out bytes.Buffer
+			bus, err := NewConn(rwc{Reader: reader, Writer: &out}, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bus.Close()
+			go func() {
+				_, err := pipewriter.Write([]byte("REJECTED EXTERNAL DBUS_COOKIE_SHA1 ANONYMOUS\r\nOK 0123456789abcdef0123456789abcdef\r\n"))
+				if err != nil {
+					t.Errorf("error writing to pipe: %v", err)
+				}
+			}()
+			if err := bus.Auth(bus.auth); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.SplitAfter(out.String(), "\r\n")
+			if len(lines) < 2 || lines[1] != tc.want {
+				t.Errorf("sent %q, want %q as the first mechanism", out.String(), tc.want)
+			}
+		})
+	}
+}
+
+type endlessReader byte
+
+func (r endlessReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = byte(r)
+	}
+	return len(b), nil
+}
+
+func TestAuthLineTooLong(t *testing.T) {
+	bus, err := NewConn(rwc{Reader: endlessReader('A'), Writer: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	if err := bus.Auth([]Auth{AuthExternal("")}); err != errAuthLineTooLong {
+		t.Errorf("got %v, want %v", err, errAuthLineTooLong)
+	}
+}
+
+func TestSignalSerialAndHeaders(t *testing.T) {
+	serials := make(chan uint32, 1)
+	emitter, err := ConnectSessionBus(WithOutgoingInterceptor(func(msg *Message) {
+		if msg.Type == TypeSignal {
+			serials <- msg.Serial()
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	receiver, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	if err := receiver.AddMatchSignal(
+		WithMatchInterface("org.godbus.DBus.TestSerial"),
+		WithMatchSender(emitter.Names()[0]),
+	); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *Signal, 1)
+	receiver.Signal(ch)
+
+	if err := emitter.Emit("/org/godbus/DBus/TestSerial", "org.godbus.DBus.TestSerial.Ping", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	sent := <-serials
+
+	timeout := time.After(5 * time.Second)
+	for {
+		var sig *Signal
+		select {
+		case sig = <-ch:
+		case <-timeout:
+			t.Fatal("timed out waiting for the signal")
+		}
+		if sig.Name != "org.godbus.DBus.TestSerial.Ping" {
+			// e.g. NameAcquired
+			continue
+		}
+		if sig.Serial != sent {
+			t.Errorf("got serial %d, want %d", sig.Serial, sent)
+		}
+		if member := sig.Headers[FieldMember].Value(); member != "Ping" {
+			t.Errorf("got member header %v, want Ping", member)
+		}
+		if sender := sig.Headers[FieldSender].Value(); sender != sig.Sender {
+			t.Errorf("got sender header %v, want %s", sender, sig.Sender)
+		}
+		return
+	}
+}
+
+// servePeer accepts a single connection on l and plays a peer that is not a
+// message bus: it accepts any authentication and answers every method call
+// except org.freedesktop.DBus.Hello with a "pong" reply.
+func servePeer(t *testing.T, l net.Listener) {
+	c, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	in := bufio.NewReader(c)
+	if _, err := in.ReadByte(); err != nil {
+		return
+	}
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == "BEGIN\r\n" {
+			break
+		}
+		switch {
+		case line == "AUTH\r\n":
+			_, err = io.WriteString(c, "REJECTED EXTERNAL\r\n")
+		case strings.HasPrefix(line, "AUTH "):
+			_, err = io.WriteString(c, "OK 0123456789abcdef0123456789abcdef\r\n")
+		default:
+			_, err = io.WriteString(c, "ERROR\r\n")
+		}
+		if err != nil {
+			return
+		}
+	}
+	for serial := uint32(1); ; serial++ {
+		msg, err := DecodeMessage(in)
+		if err != nil {
+			return
+		}
+		if msg.Type != TypeMethodCall {
+			continue
+		}
+		reply := &Message{
+			Type:    TypeMethodReply,
+			Headers: map[HeaderField]Variant{FieldReplySerial: MakeVariant(msg.serial)},
+			Body:    []interface{}{"pong"},
+			serial:  serial,
+		}
+		if member, _ := msg.Headers[FieldMember].value.(string); member == "Hello" {
+			reply.Type = TypeError
+			reply.Headers[FieldErrorName] = MakeVariant("org.freedesktop.DBus.Error.UnknownMethod")
+			reply.Body = []interface{}{"no Hello here"}
+		}
+		reply.Headers[FieldSignature] = MakeVariant(SignatureOf(reply.Body...))
+		if err := reply.EncodeTo(c, nativeEndian); err != nil {
+			t.Errorf("error writing reply: %v", err)
+			return
+		}
+	}
+}
+
+func TestDialAlternativeAddresses(t *testing.T) {
+	good := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if good == "" {
+		t.Skip("DBUS_SESSION_BUS_ADDRESS not set")
+	}
+	conn, err := Connect("unix:path=/nonexistent/bus;nosuch:key=value;" + good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	_, err = Dial("unix:path=/nonexistent/bus;nosuch:key=value")
+	if err == nil {
+		t.Fatal("expected Dial to fail")
+	}
+	for _, want := range []string{"unix:path=/nonexistent/bus: ", "nosuch:key=value: dbus: invalid bus address"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+func TestParseAutolaunchKeys(t *testing.T) {
+	for _, tc := range []struct {
+		keys  string
+		scope string
+		err   string
+	}{
+		{keys: ""},
+		{keys: "scope=*user", scope: "*user"},
+		{keys: "scope=my%2cscope,guid=0123456789abcdef0123456789abcdef", scope: "my,scope"},
+		{keys: "guid=0123456789abcdef0123456789abcdef"},
+		{keys: "path=/tmp/bus", err: `unknown key "path"`},
+		{keys: "scope", err: `malformed key "scope"`},
+		{keys: "scope=%zz", err: "invalid"},
+	} {
+		scope, err := parseAutolaunchKeys(tc.keys)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tc.keys, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: got error %v, want one containing %q", tc.keys, err, tc.err)
+		case scope != tc.scope:
+			t.Errorf("%q: got scope %q, want %q", tc.keys, scope, tc.scope)
+		}
+	}
+
+	if _, err := Dial("autolaunch:path=/tmp/bus"); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("got %v from Dial with an invalid autolaunch address", err)
+	}
+	if _, err := Dial("unix:tmpdir=/tmp"); err == nil || !strings.Contains(err.Error(), "only valid for listening") {
+		t.Errorf("got %v from Dial with a listen-only address", err)
+	}
+}
+
+func TestConnectVerifiesGUID(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := fmt.Sprintf("tcp:host=%s,port=%s", host, port)
+
+	go servePeer(t, l)
+	if _, err := Connect(address+",guid=fedcba9876543210fedcba9876543210", WithAuth(AuthExternal("")), WithoutHello()); err == nil {
+		t.Fatal("expected Connect to fail on a server with another GUID")
+	}
+
+	go servePeer(t, l)
+	conn, err := Connect(address+",guid=0123456789abcdef0123456789abcdef", WithAuth(AuthExternal("")), WithoutHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if guid := conn.ServerGUID(); guid != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("got GUID %q", guid)
+	}
+}
+
+func TestConnectWithoutHello(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := fmt.Sprintf("tcp:host=%s,port=%s", host, port)
+
+	go servePeer(t, l)
+	if _, err := Connect(address, WithAuth(AuthExternal(""))); err == nil {
+		t.Fatal("expected Connect to fail on a peer without Hello")
+	}
+
+	go servePeer(t, l)
+	conn, err := Connect(address, WithAuth(AuthExternal("")), WithoutHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if names := conn.Names(); len(names) != 1 || names[0] != "" {
+		t.Errorf("expected no names on a peer connection, got %v", names)
+	}
+	var s string
+	err = conn.Object("", "/org/godbus/DBus/Peer").Call("org.godbus.DBus.Peer.Ping", 0).Store(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "pong" {
+		t.Errorf("got %q, want %q", s, "pong")
+	}
+}
+
+func TestNewError(t *testing.T) {
+	for _, name := range []string{ErrNameFailed, ErrNameSpawnExecFailed, "org.godbus.Test.Error_1"} {
+		e, err := NewValidError(name, []interface{}{"message"})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if e.Name != name || e.Error() != "message" {
+			t.Errorf("got %v (%s), want %s", e.Name, e.Error(), name)
+		}
+	}
+	for _, name := range []string{"", "Failed", "org..Failed", ".org.Failed", "org.godbus.1Failed", "org.godbus.Fail-ed"} {
+		if _, err := NewValidError(name, nil); err == nil {
+			t.Errorf("expected an error for %q", name)
+		}
+		// NewError keeps accepting any name
+		if e := NewError(name, nil); e.Name != name {
+			t.Errorf("got %q from NewError, want %q", e.Name, name)
+		}
+	}
+}
+
+// errorBodyServer replies to its method with an error whose body has the
+// given number of elements.
+type errorBodyServer struct{}
+
+func (errorBodyServer) Fail(n uint32) *Error {
+	body := []interface{}{"first", "second"}[:n]
+	return NewError("org.godbus.DBus.Test.Error", body)
+}
+
+func TestErrorReplyBody(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if err := srv.Export(errorBodyServer{}, "/org/godbus/DBus/Test", "org.godbus.DBus.Test"); err != nil {
+		t.Fatal(err)
+	}
+	obj := cli.Object(srv.Names()[0], "/org/godbus/DBus/Test")
+	for n, want := range []string{"org.godbus.DBus.Test.Error", "first", "first"} {
+		err := obj.Call("org.godbus.DBus.Test.Fail", 0, uint32(n)).Err
+		var dbusErr Error
+		if !errors.As(err, &dbusErr) {
+			t.Fatalf("body of %d elements: got %v, want a D-Bus error", n, err)
+		}
+		if len(dbusErr.Body) != n || dbusErr.Error() != want {
+			t.Errorf("body of %d elements: got %q with body %v, want %q", n, dbusErr.Error(), dbusErr.Body, want)
+		}
+	}
+}
+
+func TestSendAndWait(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	newCall := func(member string) *Message {
+		return &Message{
+			Type: TypeMethodCall,
+			Headers: map[HeaderField]Variant{
+				FieldDestination: MakeVariant("org.freedesktop.DBus"),
+				FieldPath:        MakeVariant(ObjectPath("/org/freedesktop/DBus")),
+				FieldInterface:   MakeVariant("org.freedesktop.DBus"),
+				FieldMember:      MakeVariant(member),
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg := newCall("GetId")
+	reply, err := bus.SendAndWait(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != TypeMethodReply {
+		t.Errorf("got reply of type %v, want %v", reply.Type, TypeMethodReply)
+	}
+	if serial := reply.Headers[FieldReplySerial].Value(); serial != msg.Serial() {
+		t.Errorf("got reply serial %v, want %d", serial, msg.Serial())
+	}
+	if id, ok := reply.Body[0].(string); !ok || id == "" {
+		t.Errorf("unexpected reply body %v", reply.Body)
+	}
+
+	msg = newCall("NoSuchMethod")
+	reply, err = bus.SendAndWait(ctx, msg)
+	if !errors.Is(err, Error{Name: ErrNameUnknownMethod}) {
+		t.Errorf("got %v calling an unknown method, want UnknownMethod", err)
+	}
+	if reply == nil || reply.Type != TypeError || reply.Headers[FieldReplySerial].Value() != msg.Serial() {
+		t.Errorf("got error reply %v for the call with serial %d", reply, msg.Serial())
+	}
+
+	msg = newCall("GetId")
+	msg.Flags = FlagNoReplyExpected
+	if _, err := bus.SendAndWait(ctx, msg); err == nil {
+		t.Error("expected an error for a call that expects no reply")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	// nobody reads from c2, so writes to c1 block
+	bus, err := NewConn(c1, WithPeerToPeer(), WithWriteTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	err = bus.Emit("/org/godbus/DBus/Timeout", "org.godbus.DBus.Timeout.Stuck")
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if s := bus.State(); s != StateClosed {
+		t.Errorf("got state %v after a write timeout, want %v", s, StateClosed)
+	}
+	if err := bus.Emit("/org/godbus/DBus/Timeout", "org.godbus.DBus.Timeout.Stuck"); err != ErrClosed {
+		t.Errorf("expected ErrClosed after a write timeout, got %v", err)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go io.Copy(io.Discard, c2)
+	bus, err := NewConn(c1, WithPeerToPeer(), WithReadTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	bus.Start()
+
+	// the peer never replies
+	call := bus.Object("", "/org/godbus/DBus/Timeout").Call("org.godbus.DBus.Timeout.Wait", 0)
+	if !errors.Is(call.Err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the call to fail with a timeout error, got %v", call.Err)
+	}
+	select {
+	case <-bus.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection to be closed after the read timeout")
+	}
+}
+
+// listServer replies to its method with an array of the given length.
+type listServer struct{}
+
+func (listServer) List(n uint32) ([]uint32, *Error) {
+	return make([]uint32, n), nil
+}
+
+func TestDecodeLimitsDropMessage(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus(WithDecodeLimits(0, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if err := srv.Export(listServer{}, "/org/godbus/DBus/List", "org.godbus.DBus.List"); err != nil {
+		t.Fatal(err)
+	}
+	obj := cli.Object(srv.Names()[0], "/org/godbus/DBus/List")
+
+	var list []uint32
+	err = obj.Call("org.godbus.DBus.List.List", 0, uint32(100)).Store(&list)
+	if _, ok := err.(InvalidMessageError); !ok {
+		t.Errorf("got %v for a reply exceeding the element limit, want an InvalidMessageError", err)
+	}
+	if err := cli.AddMatchSignal(WithMatchSender(srv.Names()[0]), WithMatchInterface("org.godbus.DBus.List")); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *Signal, 2)
+	cli.Signal(signals)
+	if err := srv.Emit("/org/godbus/DBus/List", "org.godbus.DBus.List.Big", make([]uint32, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Emit("/org/godbus/DBus/List", "org.godbus.DBus.List.Small", make([]uint32, 1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sig := <-signals:
+		if sig.Name != "org.godbus.DBus.List.Small" {
+			t.Errorf("got signal %s, want only the one within the limits", sig.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the signal within the limits")
+	}
+
+	// the connection survives the messages it dropped
+	if err := obj.Call("org.godbus.DBus.List.List", 0, uint32(5)).Store(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 5 || !cli.Connected() {
+		t.Errorf("got %v, connected: %t", list, cli.Connected())
+	}
+}
+
+type genericServer struct{}
+
+type genericPair struct {
+	A int32
+	B string
+}
+
+func (genericServer) Describe(u uint32, s string, p genericPair, xs []int64, m map[string]Variant) (string, []genericPair, *Error) {
+	return fmt.Sprintf("%d %s %d %s %v %d", u, s, p.A, p.B, xs, len(m)), []genericPair{p}, nil
+}
+
+func TestCallGeneric(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if err := srv.Export(genericServer{}, "/org/godbus/DBus/Generic", "org.godbus.DBus.Generic"); err != nil {
+		t.Fatal(err)
+	}
+	sig := ParseSignatureMust("us(is)axa{sv}")
+
+	call, err := cli.CallGeneric(srv.Names()[0], "/org/godbus/DBus/Generic", "org.godbus.DBus.Generic.Describe", sig,
+		[]interface{}{"42", "hello", []interface{}{7, "seven"}, []int{1, 2}, map[string]interface{}{"x": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(call.Body) != 2 {
+		t.Fatalf("got reply %v, want two values", call.Body)
+	}
+	if got, want := call.Body[0], "42 hello 7 seven [1 2] 1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	pairs, ok := call.Body[1].([][]interface{})
+	if !ok || len(pairs) != 1 || len(pairs[0]) != 2 || pairs[0][0] != int32(7) || pairs[0][1] != "seven" {
+		t.Errorf("got %#v, want the struct (7, \"seven\")", call.Body[1])
+	}
+
+	for _, args := range [][]interface{}{
+		{-1, "hello", []interface{}{7, "seven"}, []int{}, map[string]interface{}{}},
+		{1, "hello", []interface{}{7}, []int{}, map[string]interface{}{}},
+		{1, "hello", []interface{}{7, "seven"}, []int{}},
+	} {
+		if _, err := cli.CallGeneric(srv.Names()[0], "/org/godbus/DBus/Generic", "org.godbus.DBus.Generic.Describe", sig, args); err == nil {
+			t.Errorf("arguments %v were accepted", args)
+		}
+	}
+}
+
+type slowServer chan struct{}
+
+func (s slowServer) Wait() *Error {
+	<-s
+	return nil
+}
+
+func TestPendingCallsAndStats(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	release := make(slowServer)
+	if err := srv.Export(release, "/org/godbus/DBus/Slow", "org.godbus.DBus.Slow"); err != nil {
+		t.Fatal(err)
+	}
+	before := cli.Stats()
+	if before.PendingCalls != 0 {
+		t.Fatalf("got %d pending calls on an idle connection", before.PendingCalls)
+	}
+	if before.MessagesSent == 0 || before.MessagesReceived == 0 {
+		t.Errorf("expected the Hello call to be counted, got %+v", before)
+	}
+
+	call := cli.Object(srv.Names()[0], "/org/godbus/DBus/Slow").Go("org.godbus.DBus.Slow.Wait", 0, nil)
+	if n := cli.PendingCalls(); n != 1 {
+		t.Errorf("got %d pending calls during a slow call, want 1", n)
+	}
+	close(release)
+	if err := (<-call.Done).Err; err != nil {
+		t.Fatal(err)
+	}
+	after := cli.Stats()
+	if after.PendingCalls != 0 {
+		t.Errorf("got %d pending calls after the reply", after.PendingCalls)
+	}
+	if after.MessagesSent != before.MessagesSent+1 || after.MessagesReceived < before.MessagesReceived+1 {
+		t.Errorf("unexpected counters %+v after one call, were %+v", after, before)
+	}
+}
+
+func TestStatsDroppedSignals(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if err := cli.AddMatchSignal(WithMatchSender(srv.Names()[0]), WithMatchInterface("org.godbus.DBus.Dropped")); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *Signal, 1)
+	cli.Signal(signals)
+	for i := 0; i < 3; i++ {
+		if err := srv.Emit("/org/godbus/DBus/Dropped", "org.godbus.DBus.Dropped.Signal", int32(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the bus keeps the order of the messages of srv, so the signals have
+	// been handed to the signal handler once the reply has been received
+	if err := cli.Object(srv.Names()[0], "/").Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	// one signal fits into the channel, the others are dropped with it
+	cli.RemoveSignal(signals)
+	if dropped := cli.Stats().DroppedMessages; dropped != 2 {
+		t.Errorf("got %d dropped messages, want 2", dropped)
+	}
+}
+
+func TestCancelledCallsAreForgotten(t *testing.T) {
+	srv, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	release := make(slowServer)
+	defer close(release)
+	if err := srv.Export(release, "/org/godbus/DBus/Slow", "org.godbus.DBus.Slow"); err != nil {
+		t.Fatal(err)
+	}
+	obj := cli.Object(srv.Names()[0], "/org/godbus/DBus/Slow")
+	gen := cli.serialGen.(*serialGenerator)
+	usedSerials := func() int {
+		gen.lck.Lock()
+		defer gen.lck.Unlock()
+		return len(gen.serialUsed)
+	}
+	before := usedSerials()
+
+	const n = 500
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		if i%2 == 0 {
+			// cancelled before the call is even sent
+			cancel()
+		}
+		call := obj.GoWithContext(ctx, "org.godbus.DBus.Slow.Wait", 0, nil)
+		cancel()
+		if err := (<-call.Done).Err; !errors.Is(err, context.Canceled) {
+			t.Fatalf("call %d: got error %v, want %v", i, err, context.Canceled)
+		}
+	}
+	if pending := cli.PendingCalls(); pending != 0 {
+		t.Errorf("got %d pending calls after cancelling all of them", pending)
+	}
+	// the serials are retired right after the calls are finalized
+	deadline := time.Now().Add(5 * time.Second)
+	for usedSerials() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if used := usedSerials(); used > before {
+		t.Errorf("%d serials still in use after cancelling %d calls, were %d", used, n, before)
+	}
+}
+
+func TestMessageSizeWarn(t *testing.T) {
+	type warning struct {
+		member string
+		size   int
+	}
+	sent := make(chan warning, 10)
+	srv, err := ConnectSessionBus(WithMessageSizeWarn(1024, func(msg *Message, size int) {
+		member, _ := msg.Headers[FieldMember].Value().(string)
+		sent <- warning{member, size}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	received := make(chan warning, 10)
+	cli, err := ConnectSessionBus(WithMessageSizeWarn(1024, func(msg *Message, size int) {
+		member, _ := msg.Headers[FieldMember].Value().(string)
+		received <- warning{member, size}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if err := cli.AddMatchSignal(WithMatchSender(srv.Names()[0]), WithMatchInterface("org.godbus.DBus.Size")); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *Signal, 2)
+	cli.Signal(signals)
+	if err := srv.Emit("/org/godbus/DBus/Size", "org.godbus.DBus.Size.Small", "small"); err != nil {
+		t.Fatal(err)
+	}
+	large := make([]byte, 4096)
+	if err := srv.Emit("/org/godbus/DBus/Size", "org.godbus.DBus.Size.Large", large); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-signals:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the signals")
+		}
+	}
+
+	for name, warnings := range map[string]chan warning{"sent": sent, "received": received} {
+		select {
+		case w := <-warnings:
+			if w.member != "Large" || w.size <= len(large) {
+				t.Errorf("%s: got warning for %s of %d bytes, want Large of more than %d bytes", name, w.member, w.size, len(large))
+			}
+		default:
+			t.Errorf("%s: no warning for the large signal", name)
+		}
+		select {
+		case w := <-warnings:
+			t.Errorf("%s: unexpected warning for %s of %d bytes", name, w.member, w.size)
+		default:
+		}
+	}
+}
@@ -3,8 +3,12 @@ package dbus
 import (
 	"errors"
 	"fmt"
+	"math"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
@@ -32,16 +36,34 @@ var (
 // D-Bus wire format was passed to a function.
 type InvalidTypeError struct {
 	Type reflect.Type
+
+	// Path locates the offending value when encoding a message body, e.g.
+	// body[1]["key"].Field[0] for a value below the second body element. It
+	// is empty if the location is not known. Nil interface values are
+	// reported with their interface type.
+	Path string
 }
 
 func (e InvalidTypeError) Error() string {
+	if e.Path != "" {
+		return "dbus: invalid type " + e.Type.String() + " at " + e.Path
+	}
 	return "dbus: invalid type " + e.Type.String()
 }
 
 // Store copies the values contained in src to dest, which must be a slice of
 // pointers. It converts slices of interfaces from src to corresponding structs
-// in dest. An error is returned if the lengths of src and dest or the types of
-// their elements don't match.
+// in dest. Variants in src are unwrapped unless the destination is a Variant,
+// at any depth, so e.g. a variant holding a struct can be stored directly into
+// a Go struct, whose fields may in turn receive variant-wrapped structs. An
+// error is returned if the lengths of src and dest or the types of their
+// elements don't match.
+//
+// An array can also be stored into a channel (of a type like chan<- T), in
+// which case its elements are converted to T and sent one by one, and the
+// channel is closed afterwards, so the channel should be read from another
+// goroutine. For calls made with CallDeferred, (*Call).Store decodes the
+// elements as it sends them, so the array is never held in memory as a whole.
 func Store(src []interface{}, dest ...interface{}) error {
 	if len(src) != len(dest) {
 		return errors.New("dbus.Store: length mismatch")
@@ -55,6 +77,118 @@ func Store(src []interface{}, dest ...interface{}) error {
 	return nil
 }
 
+// StructToMap converts a struct into a map from field names to values, e.g.
+// for logging a reply generically or encoding it as JSON. v is either a
+// []interface{}, as the decoder returns for D-Bus structs and as found in
+// message bodies, or a Go struct. names gives the keys for the fields in order,
+// typically the argument names from the introspection data. Fields without a
+// (non-empty) name are keyed by their position, starting at "0", or by their
+// Go field name for Go structs. Nested values are not converted.
+func StructToMap(v interface{}, names ...string) (map[string]interface{}, error) {
+	var keys []string
+	var fields []interface{}
+	switch rv := reflect.ValueOf(v); {
+	case rv.IsValid() && rv.Type() == interfacesType:
+		fields = v.([]interface{})
+		for i := range fields {
+			keys = append(keys, strconv.Itoa(i))
+		}
+	case rv.Kind() == reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath == "" && field.Tag.Get("dbus") != "-" {
+				keys = append(keys, field.Name)
+				fields = append(fields, rv.Field(i).Interface())
+			}
+		}
+	default:
+		return nil, fmt.Errorf("dbus: cannot convert %T to a map", v)
+	}
+	m := make(map[string]interface{}, len(fields))
+	for i, field := range fields {
+		key := keys[i]
+		if i < len(names) && names[i] != "" {
+			key = names[i]
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("dbus: duplicate field name %q", key)
+		}
+		m[key] = field
+	}
+	return m, nil
+}
+
+// A TypeResolver chooses the concrete type to store a value into when the
+// destination passed to Store is of an interface type it was registered for
+// with RegisterTypeResolver. It is passed the value to be stored, with variants
+// unwrapped, e.g. a map[string]Variant for a{sv}, and returns a type that
+// implements the interface.
+type TypeResolver func(src interface{}) (reflect.Type, error)
+
+var (
+	typeResolversLck sync.RWMutex
+	typeResolvers    = make(map[reflect.Type]TypeResolver)
+)
+
+// RegisterTypeResolver registers resolve to be used by Store for destinations
+// of the interface type iface, which allows decoding polymorphic values, such
+// as a{sv} dictionaries with a field that tells their kind, into the matching
+// Go types. The value is stored into the resolved type like into any other
+// destination, except that a map[string]Variant can be stored into a struct:
+// each field receives the entry of the same name, or of the name given in its
+// `dbus:"Name"` tag, as described for Object.StorePropertiesInto. Registering
+// nil removes the resolver for iface.
+//
+// It panics if iface is not a non-empty interface type.
+func RegisterTypeResolver(iface reflect.Type, resolve TypeResolver) {
+	if iface.Kind() != reflect.Interface || iface.NumMethod() == 0 {
+		panic("dbus: RegisterTypeResolver needs a non-empty interface type, got " + iface.String())
+	}
+	typeResolversLck.Lock()
+	defer typeResolversLck.Unlock()
+	if resolve == nil {
+		delete(typeResolvers, iface)
+		return
+	}
+	typeResolvers[iface] = resolve
+}
+
+func lookupTypeResolver(iface reflect.Type) TypeResolver {
+	typeResolversLck.RLock()
+	defer typeResolversLck.RUnlock()
+	return typeResolvers[iface]
+}
+
+// storeResolved stores src into the interface dest, using resolve to choose
+// the concrete type.
+func storeResolved(dest, src reflect.Value, resolve TypeResolver) error {
+	src = getVariantValue(src)
+	t, err := resolve(src.Interface())
+	if err != nil {
+		return err
+	}
+	if t == nil || !t.Implements(dest.Type()) {
+		return fmt.Errorf("dbus.Store: resolved type %v doesn't implement %s", t, dest.Type())
+	}
+	v := reflect.New(t).Elem()
+	sv := v
+	if sv.Kind() == reflect.Ptr {
+		sv.Set(reflect.New(t.Elem()))
+		sv = sv.Elem()
+	}
+	if props, ok := src.Interface().(map[string]Variant); ok && sv.Kind() == reflect.Struct {
+		err = storeProperties(props, sv)
+	} else {
+		err = store(sv, src)
+	}
+	if err != nil {
+		return err
+	}
+	dest.Set(v)
+	return nil
+}
+
 func storeInterfaces(src, dest interface{}) error {
 	return store(reflect.ValueOf(dest), reflect.ValueOf(src))
 }
@@ -66,6 +200,17 @@ func store(dest, src reflect.Value) error {
 		}
 		return store(dest.Elem(), src)
 	}
+	if dest.Kind() == reflect.Interface && dest.NumMethod() > 0 {
+		if resolve := lookupTypeResolver(dest.Type()); resolve != nil {
+			return storeResolved(dest, src, resolve)
+		}
+	}
+	if dest.Kind() == reflect.Chan {
+		return storeChan(dest, src)
+	}
+	if src.Type() == orderedMapType {
+		return storeOrderedMap(dest, src)
+	}
 	switch src.Kind() {
 	case reflect.Slice:
 		return storeSlice(dest, src)
@@ -76,6 +221,37 @@ func store(dest, src reflect.Value) error {
 	}
 }
 
+// storeChan sends the elements of the array src to the channel dest, each
+// converted like Store does, and closes the channel afterwards.
+func storeChan(dest, src reflect.Value) error {
+	if dest.IsNil() || dest.Type().ChanDir()&reflect.SendDir == 0 {
+		return fmt.Errorf("dbus.Store: cannot send to %s", dest.Type())
+	}
+	defer dest.Close()
+	if isVariant(src.Type()) {
+		src = getVariantValue(src)
+	}
+	if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+		return fmt.Errorf("dbus.Store: type mismatch: cannot send %s to %s", src.Type(), dest.Type())
+	}
+	for i := 0; i < src.Len(); i++ {
+		if err := sendElem(dest, src.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendElem converts src to the element type of the channel dest and sends it.
+func sendElem(dest, src reflect.Value) error {
+	v := reflect.New(dest.Type().Elem()).Elem()
+	if err := store(v, src); err != nil {
+		return err
+	}
+	dest.Send(v)
+	return nil
+}
+
 func storeBase(dest, src reflect.Value) error {
 	return setDest(dest, src)
 }
@@ -90,6 +266,9 @@ func setDest(dest, src reflect.Value) error {
 		src = getVariantValue(src)
 		return store(dest, src)
 	}
+	if isNumber(src.Kind()) && isNumber(dest.Kind()) {
+		return setNumber(dest, src)
+	}
 	if !src.Type().ConvertibleTo(dest.Type()) {
 		return fmt.Errorf(
 			"dbus.Store: type mismatch: cannot convert %s to %s",
@@ -99,6 +278,71 @@ func setDest(dest, src reflect.Value) error {
 	return nil
 }
 
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// setNumber stores the integer or floating point number src in dest, which
+// may be of a different numeric kind. It fails instead of silently changing
+// the value if src doesn't fit into dest, is negative for an unsigned dest or
+// has a fractional part for an integer dest.
+func setNumber(dest, src reflect.Value) error {
+	if src.Type() == dest.Type() {
+		dest.Set(src)
+		return nil
+	}
+	if !numberFits(src, dest.Type()) {
+		return fmt.Errorf("dbus.Store: value %v of type %s cannot be represented as %s",
+			src, src.Type(), dest.Type())
+	}
+	dest.Set(src.Convert(dest.Type()))
+	return nil
+}
+
+// numberFits returns whether the number src can be converted to the numeric
+// type t without changing its value.
+func numberFits(src reflect.Value, t reflect.Type) bool {
+	dest := reflect.Zero(t)
+	ok := true
+	switch src.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := src.Int()
+		switch dest.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			ok = !dest.OverflowInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			ok = i >= 0 && !dest.OverflowUint(uint64(i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := src.Uint()
+		switch dest.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			ok = u <= math.MaxInt64 && !dest.OverflowInt(int64(u))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			ok = !dest.OverflowUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		f := src.Float()
+		switch dest.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			ok = f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 &&
+				!dest.OverflowInt(int64(f))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			ok = f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 &&
+				!dest.OverflowUint(uint64(f))
+		case reflect.Float32:
+			ok = math.IsInf(f, 0) || math.IsNaN(f) || !dest.OverflowFloat(f)
+		}
+	}
+	return ok
+}
+
 func kindsAreCompatible(dest, src reflect.Type) bool {
 	switch {
 	case isVariant(dest):
@@ -116,6 +360,8 @@ func isConvertibleTo(dest, src reflect.Type) bool {
 		return true
 	case dest.Kind() == reflect.Interface:
 		return true
+	case src == orderedMapType:
+		return dest == orderedMapType || dest.Kind() == reflect.Map || dest.Kind() == reflect.Slice
 	case dest.Kind() == reflect.Slice:
 		return src.Kind() == reflect.Slice &&
 			isConvertibleTo(dest.Elem(), src.Elem())
@@ -334,6 +580,13 @@ func (o ObjectPath) IsValid() bool {
 // documentation for more information about Unix file descriptor passing.
 type UnixFD int32
 
+// File returns a new *os.File with the given name for fd, e.g. to read from a
+// descriptor returned by an exported method as a file or stream. The file
+// takes ownership of fd, which is closed when the file is closed.
+func (fd UnixFD) File(name string) *os.File {
+	return os.NewFile(uintptr(fd), name)
+}
+
 // A UnixFDIndex is the representation of a Unix file descriptor in a message.
 type UnixFDIndex uint32
 
@@ -346,7 +599,7 @@ func alignment(t reflect.Type) int {
 		return 4
 	case signatureType:
 		return 1
-	case interfacesType:
+	case interfacesType, orderedMapType:
 		return 4
 	}
 	switch t.Kind() {
@@ -421,6 +674,38 @@ func isValidMember(s string) bool {
 	return true
 }
 
+// ValidBusName returns whether s is a valid bus name, either a unique
+// connection name such as ":1.42" or a well-known name such as
+// "org.freedesktop.DBus". Unlike in well-known names, the elements of unique
+// names may begin with a digit.
+func ValidBusName(s string) bool {
+	if len(s) == 0 || len(s) > 255 {
+		return false
+	}
+	unique := s[0] == ':'
+	if unique {
+		s = s[1:]
+	}
+	elem := strings.Split(s, ".")
+	if len(elem) < 2 {
+		return false
+	}
+	for _, v := range elem {
+		if len(v) == 0 {
+			return false
+		}
+		if !unique && v[0] >= '0' && v[0] <= '9' {
+			return false
+		}
+		for _, c := range v {
+			if !isMemberChar(c) && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func isMemberChar(c rune) bool {
 	return (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') ||
 		(c >= 'a' && c <= 'z') || c == '_'
@@ -3,6 +3,7 @@ package dbus
 import (
 	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -55,3 +56,42 @@ func Test_VariantOfSlicePtr(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestValidBusName(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		valid bool
+	}{
+		{"org.freedesktop.DBus", true},
+		{"org.godbus.DBus.Test", true},
+		{"a.b", true},
+		{"com.example.my-service", true},
+		{"com.example._private", true},
+		{"org.example.v2", true},
+		{":1.42", true},
+		{":1.0", true},
+		{":abc.def", true},
+		{":1.2-3._4", true},
+		{"", false},
+		{":", false},
+		{"org", false},
+		{":1", false},
+		{".org.example", false},
+		{"org.example.", false},
+		{"org..example", false},
+		{":1..2", false},
+		{"org.2example", false},
+		{"1org.example", false},
+		{"org.example/path", false},
+		{"org.exa mple", false},
+		{"org.exämple", false},
+		{"org.example:1", false},
+		{"::1.2", false},
+		{"org." + strings.Repeat("a", 251), true},
+		{"org." + strings.Repeat("a", 252), false},
+	} {
+		if got := ValidBusName(tc.name); got != tc.valid {
+			t.Errorf("ValidBusName(%q) = %v, want %v", tc.name, got, tc.valid)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package dbus
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"strings"
+	"sync"
 	"unsafe"
 )
 
@@ -13,6 +17,18 @@ type decoder struct {
 	pos   int
 	fds   []int
 
+	// usedFDs records the elements of fds decoded since the last Reset, so
+	// that callers can tell which of them were handed out.
+	usedFDs []int
+
+	// limits restricts the complexity of decoded values; elements counts the
+	// values decoded so far by the current call to Decode.
+	limits   decodeLimits
+	elements int
+
+	// orderedDicts makes dictionaries decode to OrderedMap values.
+	orderedDicts bool
+
 	// The following fields are used to reduce memory allocs.
 	conv *stringConverter
 	buf  []byte
@@ -20,6 +36,26 @@ type decoder struct {
 	y    [1]byte
 }
 
+// maxContainerDepth is the maximum nesting depth of containers allowed by the
+// specification.
+const maxContainerDepth = 64
+
+// decodeLimits holds the limits applied when decoding message bodies. Zero
+// values select the defaults: the container depth limit of the specification
+// and no limit on the number of elements.
+type decodeLimits struct {
+	maxDepth    int
+	maxElements int
+}
+
+// depth returns the effective container depth limit.
+func (l decodeLimits) depth() int {
+	if l.maxDepth <= 0 || l.maxDepth > maxContainerDepth {
+		return maxContainerDepth
+	}
+	return l.maxDepth
+}
+
 // newDecoder returns a new decoder that reads values from in. The input is
 // expected to be in the given byte order.
 func newDecoder(in io.Reader, order binary.ByteOrder, fds []int) *decoder {
@@ -31,12 +67,47 @@ func newDecoder(in io.Reader, order binary.ByteOrder, fds []int) *decoder {
 	return dec
 }
 
+// maxPooledBufferSize is the largest buffer capacity that is kept when a
+// decoder or an encoding buffer is returned to its pool, so that a single large
+// message doesn't pin its memory.
+const maxPooledBufferSize = 1 << 16
+
+// decoderPool holds decoders for the stateless decoding entry points such as
+// DecodeMessage. Reusing them avoids allocating a new string buffer for every
+// message.
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		return newDecoder(nil, nativeEndian, nil)
+	},
+}
+
+// getDecoder returns a pooled decoder that reads values from in.
+func getDecoder(in io.Reader, order binary.ByteOrder, fds []int) *decoder {
+	dec := decoderPool.Get().(*decoder)
+	dec.Reset(in, order, fds)
+	return dec
+}
+
+// putDecoder returns dec to the pool; it must not be used afterwards. Strings
+// returned by dec stay valid, as the string buffer is only ever appended to.
+func putDecoder(dec *decoder) {
+	dec.Reset(nil, nil, nil)
+	dec.limits = decodeLimits{}
+	dec.orderedDicts = false
+	dec.elements = 0
+	if cap(dec.buf) > maxPooledBufferSize {
+		dec.buf = nil
+	}
+	decoderPool.Put(dec)
+}
+
 // Reset resets the decoder to be reading from in.
 func (dec *decoder) Reset(in io.Reader, order binary.ByteOrder, fds []int) {
 	dec.in = in
 	dec.order = order
 	dec.pos = 0
 	dec.fds = fds
+	dec.usedFDs = dec.usedFDs[:0]
 
 	if dec.conv == nil {
 		dec.conv = newStringConverter(stringConverterBufferSize)
@@ -61,14 +132,11 @@ func (dec *decoder) binread(v interface{}) {
 
 func (dec *decoder) Decode(sig Signature) (vs []interface{}, err error) {
 	defer func() {
-		var ok bool
-		v := recover()
-		if err, ok = v.(error); ok {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				err = FormatError("unexpected EOF")
-			}
+		if v := recover(); v != nil {
+			vs, err = nil, recoveredError(v)
 		}
 	}()
+	dec.elements = 0
 	vs = make([]interface{}, 0)
 	s := sig.str
 	for s != "" {
@@ -83,6 +151,19 @@ func (dec *decoder) Decode(sig Signature) (vs []interface{}, err error) {
 	return vs, nil
 }
 
+// recoveredError converts a value recovered from a panic while decoding to the
+// error returned to the caller.
+func recoveredError(v interface{}) error {
+	err, ok := v.(error)
+	if !ok {
+		return FormatError(fmt.Sprint(v))
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errUnexpectedEOF
+	}
+	return err
+}
+
 // read2buf reads exactly n bytes from the reader dec.in into the buffer dec.buf
 // to reduce memory allocs.
 // The buffer grows automatically.
@@ -106,7 +187,57 @@ func (dec *decoder) decodeU() uint32 {
 	return dec.order.Uint32(dec.buf)
 }
 
+// typeFor returns the type of the values that dec decodes for the signature
+// s, which differs from typeFor for dictionaries if dec.orderedDicts is set
+// and for unix fds, which are decoded as UnixFD if dec has received fds.
+func (dec *decoder) typeFor(s string) reflect.Type {
+	withFDs := len(dec.fds) > 0 && strings.IndexByte(s, 'h') >= 0
+	if !dec.orderedDicts && !withFDs {
+		return typeFor(s)
+	}
+	switch {
+	case s[0] == 'h' && withFDs:
+		return unixFDType
+	case strings.HasPrefix(s, "a{"):
+		if dec.orderedDicts {
+			return orderedMapType
+		}
+		i := strings.LastIndex(s, "}")
+		return reflect.MapOf(dec.typeFor(s[2:3]), dec.typeFor(s[3:i]))
+	case s[0] == 'a':
+		return reflect.SliceOf(dec.typeFor(s[1:]))
+	}
+	return typeFor(s)
+}
+
+// decodeOrderedMap decodes the dictionary of type s as an OrderedMap.
+func (dec *decoder) decodeOrderedMap(s string, depth int) OrderedMap {
+	ksig := s[2:3]
+	vsig := s[3 : len(s)-1]
+	if !isKeyType(typeFor(ksig)) {
+		panic(InvalidTypeError{Type: typeFor(ksig)})
+	}
+	m := OrderedMap{sig: Signature{s}}
+	length := dec.decodeU()
+	// Even for empty maps, the correct padding must be included
+	dec.align(8)
+	spos := dec.pos
+	for dec.pos < spos+int(length) {
+		dec.align(8)
+		kv := dec.decode(ksig, depth+2)
+		vv := dec.decode(vsig, depth+2)
+		m.Entries = append(m.Entries, DictEntry{kv, vv})
+	}
+	return m
+}
+
 func (dec *decoder) decode(s string, depth int) interface{} {
+	if dec.limits.maxElements > 0 {
+		dec.elements++
+		if dec.elements > dec.limits.maxElements {
+			panic(errElementLimit)
+		}
+	}
 	dec.align(alignment(typeFor(s)))
 	switch s[0] {
 	case 'y':
@@ -171,8 +302,8 @@ func (dec *decoder) decode(s string, depth int) interface{} {
 		}
 		return sig
 	case 'v':
-		if depth >= 64 {
-			panic(FormatError("input exceeds container depth limit"))
+		if depth >= dec.limits.depth() {
+			panic(errDepthLimit)
 		}
 		var variant Variant
 		sig := dec.decode("g", depth).(Signature)
@@ -192,17 +323,24 @@ func (dec *decoder) decode(s string, depth int) interface{} {
 	case 'h':
 		idx := dec.decodeU()
 		if int(idx) < len(dec.fds) {
+			dec.usedFDs = append(dec.usedFDs, dec.fds[idx])
 			return UnixFD(dec.fds[idx])
 		}
+		if len(dec.fds) > 0 {
+			panic(InvalidMessageError("invalid index for unix fd"))
+		}
 		return UnixFDIndex(idx)
 	case 'a':
 		if len(s) > 1 && s[1] == '{' {
 			ksig := s[2:3]
 			vsig := s[3 : len(s)-1]
-			v := reflect.MakeMap(reflect.MapOf(typeFor(ksig), typeFor(vsig)))
-			if depth >= 63 {
-				panic(FormatError("input exceeds container depth limit"))
+			if depth >= dec.limits.depth()-1 {
+				panic(errDepthLimit)
+			}
+			if dec.orderedDicts {
+				return dec.decodeOrderedMap(s, depth)
 			}
+			v := reflect.MakeMap(reflect.MapOf(dec.typeFor(ksig), dec.typeFor(vsig)))
 			length := dec.decodeU()
 			// Even for empty maps, the correct padding must be included
 			dec.align(8)
@@ -210,7 +348,7 @@ func (dec *decoder) decode(s string, depth int) interface{} {
 			for dec.pos < spos+int(length) {
 				dec.align(8)
 				if !isKeyType(v.Type().Key()) {
-					panic(InvalidTypeError{v.Type()})
+					panic(InvalidTypeError{Type: v.Type()})
 				}
 				kv := dec.decode(ksig, depth+2)
 				vv := dec.decode(vsig, depth+2)
@@ -218,8 +356,8 @@ func (dec *decoder) decode(s string, depth int) interface{} {
 			}
 			return v.Interface()
 		}
-		if depth >= 64 {
-			panic(FormatError("input exceeds container depth limit"))
+		if depth >= dec.limits.depth() {
+			panic(errDepthLimit)
 		}
 		sig := s[1:]
 		length := dec.decodeU()
@@ -228,7 +366,7 @@ func (dec *decoder) decode(s string, depth int) interface{} {
 		if s := sigByteSize(sig); s != 0 {
 			capacity = int(length) / s
 		}
-		v := reflect.MakeSlice(reflect.SliceOf(typeFor(sig)), 0, capacity)
+		v := reflect.MakeSlice(reflect.SliceOf(dec.typeFor(sig)), 0, capacity)
 		// Even for empty arrays, the correct padding must be included
 		align := alignment(typeFor(s[1:]))
 		if len(s) > 1 && s[1] == '(' {
@@ -245,8 +383,8 @@ func (dec *decoder) decode(s string, depth int) interface{} {
 		}
 		return v.Interface()
 	case '(':
-		if depth >= 64 {
-			panic(FormatError("input exceeds container depth limit"))
+		if depth >= dec.limits.depth() {
+			panic(errDepthLimit)
 		}
 		dec.align(8)
 		v := make([]interface{}, 0)
@@ -266,6 +404,166 @@ func (dec *decoder) decode(s string, depth int) interface{} {
 	}
 }
 
+// DecodeElements decodes an array with the signature sig, which must be an
+// array but not a dict, and calls fn with each element as soon as it is
+// decoded instead of collecting the elements in a slice. It stops at the first
+// error returned by fn.
+func (dec *decoder) DecodeElements(sig string, fn func(interface{}) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = recoveredError(v)
+		}
+	}()
+	if dec.limits.depth() <= 0 {
+		panic(errDepthLimit)
+	}
+	if dec.limits.maxElements > 0 {
+		dec.elements++
+		if dec.elements > dec.limits.maxElements {
+			panic(errElementLimit)
+		}
+	}
+	length := dec.decodeU()
+	align := alignment(typeFor(sig[1:]))
+	if sig[1] == '(' {
+		align = 8
+	}
+	dec.align(align)
+	spos := dec.pos
+	for dec.pos < spos+int(length) {
+		if err := fn(dec.decode(sig[1:], 1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixedKinds maps the fixed-size basic types to the kinds of Go values they
+// can be decoded into by decodeFixedStructs.
+var fixedKinds = map[byte]reflect.Kind{
+	'y': reflect.Uint8,
+	'b': reflect.Bool,
+	'n': reflect.Int16,
+	'q': reflect.Uint16,
+	'i': reflect.Int32,
+	'u': reflect.Uint32,
+	'x': reflect.Int64,
+	't': reflect.Uint64,
+	'd': reflect.Float64,
+}
+
+// fixedStructFields returns the indices of the fields of the struct type t
+// that correspond to the fields of the struct signature sig (e.g. "(iiy)"), if
+// sig consists only of fixed-size basic types and t's exported fields match
+// them one to one. Otherwise, it returns nil.
+func fixedStructFields(sig string, t reflect.Type) []int {
+	if t.Kind() != reflect.Struct || len(sig) < 3 || sig[0] != '(' || sig[len(sig)-1] != ')' {
+		return nil
+	}
+	fields := sig[1 : len(sig)-1]
+	idx := make([]int, 0, len(fields))
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("dbus") == "-" {
+			continue
+		}
+		n := len(idx)
+		if n == len(fields) || fixedKinds[fields[n]] != field.Type.Kind() {
+			return nil
+		}
+		idx = append(idx, i)
+	}
+	if len(idx) != len(fields) {
+		return nil
+	}
+	return idx
+}
+
+// DecodeFixedStructs decodes an array of structs with the signature "a"+sig,
+// where sig consists only of fixed-size basic types, directly into the slice
+// dst points to. fields are the indices of the struct fields as returned by
+// fixedStructFields. Unlike Decode, this doesn't box every element and field
+// in an interface{}.
+func (dec *decoder) DecodeFixedStructs(sig string, fields []int, dst reflect.Value) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = recoveredError(v)
+		}
+	}()
+	if dec.limits.depth() < 2 {
+		panic(errDepthLimit)
+	}
+	length := dec.decodeU()
+	dec.align(8)
+	s := reflect.New(dst.Type()).Elem()
+	if size := sigByteSize(sig); size != 0 {
+		s.Set(reflect.MakeSlice(dst.Type(), 0, int(length)/size))
+	}
+	sig = sig[1 : len(sig)-1]
+	spos := dec.pos
+	for dec.pos < spos+int(length) {
+		if dec.limits.maxElements > 0 {
+			dec.elements += 1 + len(fields)
+			if dec.elements > dec.limits.maxElements {
+				panic(errElementLimit)
+			}
+		}
+		dec.align(8)
+		if s.Len() < s.Cap() {
+			s.SetLen(s.Len() + 1)
+		} else {
+			s.Set(reflect.Append(s, reflect.Zero(s.Type().Elem())))
+		}
+		elem := s.Index(s.Len() - 1)
+		for i, c := range []byte(sig) {
+			f := elem.Field(fields[i])
+			switch c {
+			case 'y':
+				dec.read2buf(1)
+				dec.pos++
+				f.SetUint(uint64(dec.buf[0]))
+			case 'b':
+				switch dec.decodeU() {
+				case 0:
+					f.SetBool(false)
+				case 1:
+					f.SetBool(true)
+				default:
+					panic(FormatError("invalid value for boolean"))
+				}
+			case 'n', 'q':
+				dec.align(2)
+				dec.read2buf(2)
+				dec.pos += 2
+				if c == 'n' {
+					f.SetInt(int64(int16(dec.order.Uint16(dec.buf))))
+				} else {
+					f.SetUint(uint64(dec.order.Uint16(dec.buf)))
+				}
+			case 'i':
+				f.SetInt(int64(int32(dec.decodeU())))
+			case 'u':
+				f.SetUint(uint64(dec.decodeU()))
+			case 'x', 't', 'd':
+				dec.align(8)
+				dec.read2buf(8)
+				dec.pos += 8
+				u := dec.order.Uint64(dec.buf)
+				switch c {
+				case 'x':
+					f.SetInt(int64(u))
+				case 't':
+					f.SetUint(u)
+				default:
+					f.SetFloat(math.Float64frombits(u))
+				}
+			}
+		}
+	}
+	dst.Set(s)
+	return nil
+}
+
 // sigByteSize tries to calculates size of the given signature in bytes.
 //
 // It returns zero when it can't, for example when it contains non-fixed size
@@ -314,6 +612,17 @@ func sigByteSize(sig string) int {
 // A FormatError is an error in the wire format.
 type FormatError string
 
+var (
+	// errUnexpectedEOF is returned when the input ends in the middle of a
+	// value.
+	errUnexpectedEOF = FormatError("unexpected EOF")
+
+	// errDepthLimit and errElementLimit are returned when the input exceeds
+	// the decode limits.
+	errDepthLimit   = FormatError("input exceeds container depth limit")
+	errElementLimit = FormatError("input exceeds element limit")
+)
+
 func (e FormatError) Error() string {
 	return "dbus: wire format error: " + string(e)
 }
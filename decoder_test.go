@@ -3,6 +3,7 @@ package dbus
 import (
 	"bytes"
 	"encoding/binary"
+	"reflect"
 	"testing"
 )
 
@@ -86,3 +87,148 @@ func TestSigByteSize(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeLimits(t *testing.T) {
+	// Nest ten variants around a single uint32.
+	var nested interface{} = uint32(1)
+	for i := 0; i < 10; i++ {
+		nested = MakeVariant(nested)
+	}
+	buf := new(bytes.Buffer)
+	if err := newEncoder(buf, binary.LittleEndian, nil).Encode(nested); err != nil {
+		t.Fatal(err)
+	}
+	variants := buf.Bytes()
+
+	buf = new(bytes.Buffer)
+	if err := newEncoder(buf, binary.LittleEndian, nil).Encode(make([]uint32, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	array := buf.Bytes()
+
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		sig     string
+		limits  decodeLimits
+		wantErr bool
+	}{
+		{"variants/default", variants, "v", decodeLimits{}, false},
+		{"variants/depth 10", variants, "v", decodeLimits{maxDepth: 10}, false},
+		{"variants/depth 9", variants, "v", decodeLimits{maxDepth: 9}, true},
+		{"variants/elements", variants, "v", decodeLimits{maxElements: 15}, true},
+		{"array/default", array, "au", decodeLimits{}, false},
+		{"array/elements 1001", array, "au", decodeLimits{maxElements: 1001}, false},
+		{"array/elements 1000", array, "au", decodeLimits{maxElements: 1000}, true},
+		{"array/depth 1", array, "au", decodeLimits{maxDepth: 1}, false},
+	} {
+		dec := newDecoder(bytes.NewReader(tc.data), binary.LittleEndian, nil)
+		dec.limits = tc.limits
+		_, err := dec.Decode(Signature{tc.sig})
+		if tc.wantErr {
+			if _, ok := err.(FormatError); !ok {
+				t.Errorf("%s: expected FormatError, got %v", tc.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
+
+type fixedPoint struct {
+	X, Y  int32
+	Flags uint8
+	Z     float64
+	On    bool
+}
+
+func encodeFixedPoints(tb testing.TB, n int) ([]fixedPoint, []byte) {
+	points := make([]fixedPoint, n)
+	for i := range points {
+		points[i] = fixedPoint{int32(i), -int32(i), uint8(i), float64(i) / 2, i%2 == 0}
+	}
+	buf := new(bytes.Buffer)
+	if err := newEncoder(buf, binary.LittleEndian, nil).Encode(points); err != nil {
+		tb.Fatal(err)
+	}
+	return points, buf.Bytes()
+}
+
+func TestDecodeFixedStructs(t *testing.T) {
+	for _, n := range []int{0, 1, 100} {
+		want, data := encodeFixedPoints(t, n)
+		fields := fixedStructFields("(iiydb)", reflect.TypeOf(fixedPoint{}))
+		if fields == nil {
+			t.Fatal("expected fixedPoint to match (iiydb)")
+		}
+		var got []fixedPoint
+		dec := newDecoder(bytes.NewReader(data), binary.LittleEndian, nil)
+		if err := dec.DecodeFixedStructs("(iiydb)", fields, reflect.ValueOf(&got).Elem()); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) || (n > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFixedStructFields(t *testing.T) {
+	type withString struct {
+		A int32
+		B string
+	}
+	type withIgnored struct {
+		A int32
+		b int32
+		C chan int `dbus:"-"`
+		D uint8
+	}
+	for _, tc := range []struct {
+		sig  string
+		v    interface{}
+		want []int
+	}{
+		{"(iiydb)", fixedPoint{}, []int{0, 1, 2, 3, 4}},
+		{"(iy)", withIgnored{}, []int{0, 3}},
+		{"(is)", withString{}, nil},
+		{"(ii)", fixedPoint{}, nil},
+		{"(iiydbi)", fixedPoint{}, nil},
+		{"(uiydb)", fixedPoint{}, nil},
+		{"i", int32(0), nil},
+	} {
+		if got := fixedStructFields(tc.sig, reflect.TypeOf(tc.v)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %T: got %v, want %v", tc.sig, tc.v, got, tc.want)
+		}
+	}
+}
+
+func BenchmarkDecodeStructArray(b *testing.B) {
+	_, data := encodeFixedPoints(b, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var points []fixedPoint
+		dec := newDecoder(bytes.NewReader(data), binary.LittleEndian, nil)
+		vs, err := dec.Decode(Signature{"a(iiydb)"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := Store(vs, &points); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeFixedStructs(b *testing.B) {
+	_, data := encodeFixedPoints(b, 10000)
+	fields := fixedStructFields("(iiydb)", reflect.TypeOf(fixedPoint{}))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var points []fixedPoint
+		dec := newDecoder(bytes.NewReader(data), binary.LittleEndian, nil)
+		if err := dec.DecodeFixedStructs("(iiydb)", fields, reflect.ValueOf(&points).Elem()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
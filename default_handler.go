@@ -3,16 +3,21 @@ package dbus
 import (
 	"bytes"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
-func newIntrospectIntf(h *defaultHandler) *exportedIntf {
+// newIntrospectIntf returns the org.freedesktop.DBus.Introspectable interface
+// of h. If auto is set, Introspect also describes the exported interfaces (see
+// WithAutoIntrospection).
+func newIntrospectIntf(h *defaultHandler, auto bool) *exportedIntf {
 	methods := make(map[string]Method)
 	methods["Introspect"] = exportedMethod{
 		reflect.ValueOf(func(msg Message) (string, *Error) {
 			path := msg.Headers[FieldPath].value.(ObjectPath)
-			return h.introspectPath(path), nil
+			return h.introspectPath(path, auto), nil
 		}),
 	}
 	return newExportedIntf(methods, true)
@@ -28,7 +33,7 @@ func NewDefaultHandler() *defaultHandler {
 		objects:     make(map[ObjectPath]*exportedObj),
 		defaultIntf: make(map[string]*exportedIntf),
 	}
-	h.defaultIntf["org.freedesktop.DBus.Introspectable"] = newIntrospectIntf(h)
+	h.defaultIntf["org.freedesktop.DBus.Introspectable"] = newIntrospectIntf(h, false)
 	return h
 }
 
@@ -43,10 +48,15 @@ func (h *defaultHandler) PathExists(path ObjectPath) bool {
 	return ok
 }
 
-func (h *defaultHandler) introspectPath(path ObjectPath) string {
+func (h *defaultHandler) introspectPath(path ObjectPath, auto bool) string {
+	h.RLock()
+	defer h.RUnlock()
 	subpath := make(map[string]struct{})
 	var xml bytes.Buffer
 	xml.WriteString("<node>")
+	if auto {
+		h.introspectInterfaces(&xml, path)
+	}
 	for obj := range h.objects {
 		p := string(path)
 		if p != "/" {
@@ -65,18 +75,30 @@ func (h *defaultHandler) introspectPath(path ObjectPath) string {
 }
 
 func (h *defaultHandler) LookupObject(path ObjectPath) (ServerObject, bool) {
+	return h.lookupObject(path, nil)
+}
+
+// lookupObject implements LookupObject. If introspect isn't nil, it is used as
+// the Introspectable interface of objects that don't export one themselves,
+// instead of the default one.
+func (h *defaultHandler) lookupObject(path ObjectPath, introspect *exportedIntf) (ServerObject, bool) {
 	h.RLock()
 	defer h.RUnlock()
 	object, ok := h.objects[path]
 	if ok {
+		if introspect != nil {
+			if _, exists := object.LookupInterface("org.freedesktop.DBus.Introspectable"); !exists {
+				return &introspectedObj{object, introspect}, true
+			}
+		}
 		return object, ok
 	}
 
 	// If an object wasn't found for this exact path,
 	// look for a matching subtree registration
 	subtreeObject := newExportedObject()
-	path = path[:strings.LastIndex(string(path), "/")]
-	for len(path) > 0 {
+	for path != "/" {
+		path = parentPath(path)
 		object, ok = h.objects[path]
 		if ok {
 			for name, iface := range object.interfaces {
@@ -87,20 +109,63 @@ func (h *defaultHandler) LookupObject(path ObjectPath) (ServerObject, bool) {
 			}
 			break
 		}
-
-		path = path[:strings.LastIndex(string(path), "/")]
 	}
 
 	for name, intf := range h.defaultIntf {
 		if _, exists := subtreeObject.interfaces[name]; exists {
 			continue
 		}
+		if name == "org.freedesktop.DBus.Introspectable" && introspect != nil {
+			intf = introspect
+		}
 		subtreeObject.interfaces[name] = intf
 	}
 
 	return subtreeObject, true
 }
 
+// objectExists returns whether path refers to an exported object, an object
+// below a subtree export, or a parent node of an exported object. Calls to
+// other paths only reach the interfaces implemented for every path.
+func (h *defaultHandler) objectExists(path ObjectPath) bool {
+	h.RLock()
+	defer h.RUnlock()
+	if path == "/" {
+		return true
+	}
+	if _, ok := h.objects[path]; ok {
+		return true
+	}
+	prefix := string(path) + "/"
+	for obj := range h.objects {
+		if strings.HasPrefix(string(obj), prefix) {
+			return true
+		}
+	}
+	for p := path; p != "/"; {
+		p = parentPath(p)
+		if object, ok := h.objects[p]; ok {
+			for _, iface := range object.interfaces {
+				if iface.isFallbackInterface() {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// parentPath returns the path of the parent of the object at path, which
+// must not be the root.
+func parentPath(path ObjectPath) ObjectPath {
+	i := strings.LastIndex(string(path), "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
 func (h *defaultHandler) AddObject(path ObjectPath, object *exportedObj) {
 	h.Lock()
 	h.objects[path] = object
@@ -113,6 +178,36 @@ func (h *defaultHandler) DeleteObject(path ObjectPath) {
 	h.Unlock()
 }
 
+// paths returns the sorted paths of all exported objects.
+func (h *defaultHandler) paths() []ObjectPath {
+	h.RLock()
+	defer h.RUnlock()
+	paths := make([]ObjectPath, 0, len(h.objects))
+	for path := range h.objects {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i] < paths[j] })
+	return paths
+}
+
+// interfaces returns the sorted names of the interfaces exported at path.
+func (h *defaultHandler) interfaces(path ObjectPath) []string {
+	h.RLock()
+	obj, ok := h.objects[path]
+	h.RUnlock()
+	if !ok {
+		return nil
+	}
+	obj.mu.RLock()
+	defer obj.mu.RUnlock()
+	names := make([]string, 0, len(obj.interfaces))
+	for name := range obj.interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type exportedMethod struct {
 	reflect.Value
 }
@@ -203,16 +298,21 @@ func (obj *exportedObj) DeleteInterface(name string) {
 	delete(obj.interfaces, name)
 }
 
+// LookupMethod looks up a method called without an interface. If several
+// interfaces have a method of that name, the one of the interface whose name
+// sorts first is chosen, so that such calls are dispatched consistently.
 func (obj *exportedObj) LookupMethod(name string) (Method, bool) {
 	obj.mu.RLock()
 	defer obj.mu.RUnlock()
-	for _, intf := range obj.interfaces {
-		method, exists := intf.LookupMethod(name)
-		if exists {
-			return method, exists
+	var method Method
+	var iface string
+	for ifaceName, intf := range obj.interfaces {
+		m, exists := intf.LookupMethod(name)
+		if exists && (method == nil || ifaceName < iface) {
+			method, iface = m, ifaceName
 		}
 	}
-	return nil, false
+	return method, method != nil
 }
 
 func newExportedIntf(methods map[string]Method, includeSubtree bool) *exportedIntf {
@@ -225,11 +325,29 @@ func newExportedIntf(methods map[string]Method, includeSubtree bool) *exportedIn
 type exportedIntf struct {
 	methods map[string]Method
 
+	// value is the value the methods were exported from, if any.
+	value interface{}
+
+	// nameTransform, if set, maps the member names of incoming calls to the
+	// keys of methods.
+	nameTransform func(string) string
+
+	// props holds the properties declared by the tagged fields of value, if
+	// it is a pointer to a struct.
+	props map[string]structProperty
+
 	// Whether or not this export is for the entire subtree
 	includeSubtree bool
+
+	// raw is set for interfaces exported with ExportRawHandler, which have no
+	// methods of their own.
+	raw RawMethodHandler
 }
 
 func (obj *exportedIntf) LookupMethod(name string) (Method, bool) {
+	if obj.nameTransform != nil {
+		name = obj.nameTransform(name)
+	}
 	out, exists := obj.methods[name]
 	return out, exists
 }
@@ -251,6 +369,10 @@ type defaultSignalHandler struct {
 	mu      sync.RWMutex
 	closed  bool
 	signals []*signalChannelData
+
+	// dropped, if not nil, counts the signals that were waiting for a full
+	// channel when it was removed or the handler terminated.
+	dropped *atomic.Uint64
 }
 
 func (sh *defaultSignalHandler) DeliverSignal(intf, name string, signal *Signal) {
@@ -286,8 +408,9 @@ func (sh *defaultSignalHandler) AddSignal(ch chan<- *Signal) {
 		return
 	}
 	sh.signals = append(sh.signals, &signalChannelData{
-		ch:   ch,
-		done: make(chan struct{}),
+		ch:      ch,
+		done:    make(chan struct{}),
+		dropped: sh.dropped,
 	})
 }
 
@@ -308,9 +431,10 @@ func (sh *defaultSignalHandler) RemoveSignal(ch chan<- *Signal) {
 }
 
 type signalChannelData struct {
-	wg   sync.WaitGroup
-	ch   chan<- *Signal
-	done chan struct{}
+	wg      sync.WaitGroup
+	ch      chan<- *Signal
+	done    chan struct{}
+	dropped *atomic.Uint64
 }
 
 func (scd *signalChannelData) deliver(signal *Signal) {
@@ -328,6 +452,9 @@ func (scd *signalChannelData) deferredDeliver(signal *Signal) {
 	select {
 	case scd.ch <- signal:
 	case <-scd.done:
+		if scd.dropped != nil {
+			scd.dropped.Add(1)
+		}
 	}
 	scd.wg.Done()
 }
@@ -65,6 +65,15 @@ If it returns true, all method of Connection will translate messages containing
 UnixFD's to messages that are accompanied by the given file descriptors with the
 UnixFD values being substituted by the correct indices. Similarly, the indices
 of incoming messages are automatically resolved. It shouldn't be necessary to use
-UnixFDIndex.
+UnixFDIndex. This applies to signals sent with Emit as well, which are then
+received with the descriptors by every connection that supports them. Messages
+decoded with DecodeMessage keep the indices, which ResolveFDs substitutes with
+descriptors obtained otherwise.
+
+File descriptors received in incoming messages are owned by the receiver: the
+package never closes them, so a method handler or signal consumer may keep
+using them after it returns and is responsible for closing them. DupFD can be
+used to obtain an independent copy, e.g. when the original is handed over to
+an os.File.
 */
 package dbus
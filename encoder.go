@@ -3,8 +3,12 @@ package dbus
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 )
@@ -15,6 +19,18 @@ type encoder struct {
 	fds   []int
 	order binary.ByteOrder
 	pos   int
+
+	// direct makes the encoder write arrays straight to out after
+	// determining their length in a separate sizing pass, instead of
+	// encoding them into a buffer first. This saves memory for large arrays
+	// at the cost of encoding each value once more per array it is nested
+	// in.
+	direct bool
+
+	// sizeOnly makes the encoder only determine the size of the encoding:
+	// arrays are written with a placeholder length, so that their elements
+	// are encoded a single time whatever their nesting.
+	sizeOnly bool
 }
 
 // NewEncoder returns a new encoder that writes to out in the given byte order.
@@ -70,6 +86,17 @@ func (enc *encoder) binwrite(v interface{}) {
 func (enc *encoder) Encode(vs ...interface{}) (err error) {
 	defer func() {
 		err, _ = recover().(error)
+		if e, ok := err.(InvalidTypeError); ok && e.Path == "" {
+			// Only find out where the value is on failure, to keep
+			// the encoder from tracking it all the time.
+			for i, v := range vs {
+				path := "body[" + strconv.Itoa(i) + "]"
+				if path, t, ok := invalidTypeAt(reflect.ValueOf(v), path); ok {
+					err = InvalidTypeError{Type: t, Path: path}
+					break
+				}
+			}
+		}
 	}()
 	for _, v := range vs {
 		enc.encode(reflect.ValueOf(v), 0)
@@ -77,6 +104,23 @@ func (enc *encoder) Encode(vs ...interface{}) (err error) {
 	return nil
 }
 
+// EncodedSize returns the number of bytes that the values vs occupy when
+// encoded in the given byte order, as the body of a message, without keeping
+// the encoded data. It returns the error that encoding the values would
+// return, e.g. an InvalidTypeError.
+func EncodedSize(order binary.ByteOrder, vs ...interface{}) (int, error) {
+	if order != binary.LittleEndian && order != binary.BigEndian {
+		return 0, errors.New("dbus: invalid byte order")
+	}
+	var cw countingWriter
+	enc := newEncoder(&cw, order, nil)
+	enc.sizeOnly = true
+	if err := enc.Encode(vs...); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
 // encode encodes the given value to the writer and panics on error. depth holds
 // the depth of the container nesting.
 func (enc *encoder) encode(v reflect.Value, depth int) {
@@ -151,30 +195,17 @@ func (enc *encoder) encode(v reflect.Value, depth int) {
 	case reflect.Ptr:
 		enc.encode(v.Elem(), depth)
 	case reflect.Slice, reflect.Array:
-		// Lookahead offset: 4 bytes for uint32 length (with alignment),
-		// plus alignment for elements.
-		n := enc.padding(0, 4) + 4
-		offset := enc.pos + n + enc.padding(n, alignment(v.Type().Elem()))
-
-		var buf bytes.Buffer
-		bufenc := newEncoderAtOffset(&buf, offset, enc.order, enc.fds)
-
-		for i := 0; i < v.Len(); i++ {
-			bufenc.encode(v.Index(i), depth+1)
-		}
-
-		if buf.Len() > 1<<26 {
-			panic(FormatError("input exceeds array size limitation"))
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			enc.encodeArray(1, depth, func(e *encoder) {
+				e.writeBytes(v)
+			})
+			break
 		}
-
-		enc.fds = bufenc.fds
-		enc.encode(reflect.ValueOf(uint32(buf.Len())), depth)
-		length := buf.Len()
-		enc.align(alignment(v.Type().Elem()))
-		if _, err := buf.WriteTo(enc.out); err != nil {
-			panic(err)
-		}
-		enc.pos += length
+		enc.encodeArray(alignment(v.Type().Elem()), depth, func(e *encoder) {
+			for i := 0; i < v.Len(); i++ {
+				e.encode(v.Index(i), depth+1)
+			}
+		})
 	case reflect.Struct:
 		switch t := v.Type(); t {
 		case signatureType:
@@ -192,6 +223,8 @@ func (enc *encoder) encode(v reflect.Value, depth int) {
 			variant := v.Interface().(Variant)
 			enc.encode(reflect.ValueOf(variant.sig), depth+1)
 			enc.encode(reflect.ValueOf(variant.value), depth+1)
+		case orderedMapType:
+			enc.encodeOrderedMap(v.Interface().(OrderedMap), depth)
 		default:
 			for i := 0; i < v.Type().NumField(); i++ {
 				field := t.Field(i)
@@ -204,32 +237,230 @@ func (enc *encoder) encode(v reflect.Value, depth int) {
 		// Maps are arrays of structures, so they actually increase the depth by
 		// 2.
 		if !isKeyType(v.Type().Key()) {
-			panic(InvalidTypeError{v.Type()})
+			panic(InvalidTypeError{Type: v.Type()})
 		}
 		keys := v.MapKeys()
-		// Lookahead offset: 4 bytes for uint32 length (with alignment),
-		// plus 8-byte alignment
-		n := enc.padding(0, 4) + 4
-		offset := enc.pos + n + enc.padding(n, 8)
-
-		var buf bytes.Buffer
-		bufenc := newEncoderAtOffset(&buf, offset, enc.order, enc.fds)
-		for _, k := range keys {
-			bufenc.align(8)
-			bufenc.encode(k, depth+2)
-			bufenc.encode(v.MapIndex(k), depth+2)
-		}
-		enc.fds = bufenc.fds
-		enc.encode(reflect.ValueOf(uint32(buf.Len())), depth)
-		length := buf.Len()
-		enc.align(8)
-		if _, err := buf.WriteTo(enc.out); err != nil {
-			panic(err)
-		}
-		enc.pos += length
+		sortMapKeys(keys)
+		enc.encodeArray(8, depth, func(e *encoder) {
+			for _, k := range keys {
+				e.align(8)
+				e.encode(k, depth+2)
+				e.encode(v.MapIndex(k), depth+2)
+			}
+		})
 	case reflect.Interface:
+		if v.IsNil() {
+			panic(InvalidTypeError{Type: v.Type()})
+		}
 		enc.encode(reflect.ValueOf(MakeVariant(v.Interface())), depth)
 	default:
-		panic(InvalidTypeError{v.Type()})
+		panic(InvalidTypeError{Type: v.Type()})
+	}
+}
+
+// encodeOrderedMap encodes m like a map, keeping the order of its entries.
+func (enc *encoder) encodeOrderedMap(m OrderedMap, depth int) {
+	enc.encodeArray(8, depth, func(e *encoder) {
+		for _, entry := range m.Entries {
+			k := reflect.ValueOf(entry.Key)
+			if !k.IsValid() || !isKeyType(k.Type()) {
+				panic(FormatError("invalid key in OrderedMap"))
+			}
+			e.align(8)
+			e.encode(k, depth+2)
+			e.encode(reflect.ValueOf(entry.Value), depth+2)
+		}
+	})
+}
+
+// encodeArray encodes an array whose elements are aligned to elemAlign and
+// encoded by elems, which is passed the encoder to use. The length of the
+// array has to be known before its elements are written, so unless enc is
+// direct or sizeOnly, they are encoded into a buffer first.
+func (enc *encoder) encodeArray(elemAlign int, depth int, elems func(e *encoder)) {
+	if enc.sizeOnly {
+		enc.encode(reflect.ValueOf(uint32(0)), depth)
+		enc.align(elemAlign)
+		start := enc.pos
+		elems(enc)
+		if enc.pos-start > 1<<26 {
+			panic(FormatError("input exceeds array size limitation"))
+		}
+		return
+	}
+
+	// Lookahead offset: 4 bytes for uint32 length (with alignment),
+	// plus alignment for elements.
+	n := enc.padding(0, 4) + 4
+	offset := enc.pos + n + enc.padding(n, elemAlign)
+
+	if enc.direct {
+		var cw countingWriter
+		sizer := newEncoderAtOffset(&cw, offset, enc.order, nil)
+		sizer.sizeOnly = true
+		elems(sizer)
+		if cw.n > 1<<26 {
+			panic(FormatError("input exceeds array size limitation"))
+		}
+		enc.encode(reflect.ValueOf(uint32(cw.n)), depth)
+		enc.align(elemAlign)
+		elems(enc)
+		return
+	}
+
+	var buf bytes.Buffer
+	bufenc := newEncoderAtOffset(&buf, offset, enc.order, enc.fds)
+	elems(bufenc)
+	if buf.Len() > 1<<26 {
+		panic(FormatError("input exceeds array size limitation"))
+	}
+	enc.fds = bufenc.fds
+	enc.encode(reflect.ValueOf(uint32(buf.Len())), depth)
+	length := buf.Len()
+	enc.align(elemAlign)
+	if _, err := buf.WriteTo(enc.out); err != nil {
+		panic(err)
+	}
+	enc.pos += length
+}
+
+// writeBytes writes the elements of v, a slice or array of a byte type, with
+// a single write.
+func (enc *encoder) writeBytes(v reflect.Value) {
+	var b []byte
+	if v.Kind() == reflect.Slice {
+		b = v.Bytes()
+	} else {
+		b = make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+	}
+	if _, err := enc.out.Write(b); err != nil {
+		panic(err)
+	}
+	enc.pos += len(b)
+}
+
+// sortMapKeys sorts the keys of a map, which are all of the same basic type.
+// Encoding the entries in this order makes the encoding of a map, including
+// its padding, and hence its size deterministic.
+func sortMapKeys(keys []reflect.Value) {
+	if len(keys) < 2 {
+		return
+	}
+	var less func(a, b reflect.Value) bool
+	switch keys[0].Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		less = func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float32, reflect.Float64:
+		less = func(a, b reflect.Value) bool { return a.Float() < b.Float() }
+	case reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	case reflect.Bool:
+		less = func(a, b reflect.Value) bool { return !a.Bool() && b.Bool() }
+	default:
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+}
+
+// countingWriter counts the bytes written to it and discards them.
+type countingWriter struct {
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+// invalidTypeAt returns the path to the first value in v, whose own path is
+// path, that can't be encoded, and the offending type.
+func invalidTypeAt(v reflect.Value, path string) (string, reflect.Type, bool) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return "", nil, false
+	case reflect.Interface:
+		if v.IsNil() {
+			return path, v.Type(), true
+		}
+		return invalidTypeAt(v.Elem(), path)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return invalidTypeAt(v.Elem(), path)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if p, t, ok := invalidTypeAt(v.Index(i), path+"["+strconv.Itoa(i)+"]"); ok {
+				return p, t, ok
+			}
+		}
+	case reflect.Map:
+		if !isKeyType(v.Type().Key()) {
+			return path, v.Type(), true
+		}
+		keys := v.MapKeys()
+		sortMapKeys(keys)
+		for _, k := range keys {
+			if p, t, ok := invalidTypeAt(v.MapIndex(k), path+"["+formatPathKey(k)+"]"); ok {
+				return p, t, ok
+			}
+		}
+	case reflect.Struct:
+		switch t := v.Type(); t {
+		case variantType:
+			return invalidTypeAt(reflect.ValueOf(v.Interface().(Variant).value), path)
+		case signatureType:
+			return "", nil, false
+		case orderedMapType:
+			for _, e := range v.Interface().(OrderedMap).Entries {
+				k := reflect.ValueOf(e.Key)
+				if !k.IsValid() {
+					continue
+				}
+				if p, t, ok := invalidTypeAt(reflect.ValueOf(e.Value), path+"["+formatPathKey(k)+"]"); ok {
+					return p, t, ok
+				}
+			}
+			return "", nil, false
+		default:
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if field.PkgPath == "" && field.Tag.Get("dbus") != "-" {
+					if p, t, ok := invalidTypeAt(v.Field(i), path+"."+field.Name); ok {
+						return p, t, ok
+					}
+				}
+			}
+		}
+	}
+	// None of the contained values is at fault, but the type itself may be,
+	// e.g. for an empty slice of an unsupported element type.
+	if t, ok := invalidTypeOf(v.Type()); ok {
+		return path, t, true
+	}
+	return "", nil, false
+}
+
+// invalidTypeOf returns the type that makes t unrepresentable in D-Bus, if
+// any.
+func invalidTypeOf(t reflect.Type) (invalid reflect.Type, ok bool) {
+	defer func() {
+		if e, isInvalid := recover().(InvalidTypeError); isInvalid {
+			invalid, ok = e.Type, true
+		}
+	}()
+	if t != orderedMapType {
+		signatureOfType(t)
+	}
+	return nil, false
+}
+
+// formatPathKey formats the map key k for the Path of an InvalidTypeError.
+func formatPathKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return strconv.Quote(k.String())
 	}
+	return fmt.Sprint(k.Interface())
 }
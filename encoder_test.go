@@ -3,6 +3,7 @@ package dbus
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -86,6 +87,136 @@ func TestEncodeMapStringInterface(t *testing.T) {
 	}
 }
 
+func TestEncodeInvalidTypeInInterface(t *testing.T) {
+	type options struct {
+		Name  string
+		Extra []interface{}
+	}
+	for _, tc := range []struct {
+		vs   []interface{}
+		typ  string
+		path string
+	}{
+		{[]interface{}{map[string]interface{}{"ok": 1, "bad": make(chan int)}}, "chan int", `body[0]["bad"]`},
+		{[]interface{}{"x", options{"n", []interface{}{int32(1), func() {}}}}, "func()", "body[1].Extra[1]"},
+		{[]interface{}{map[string]interface{}{"nested": map[string]interface{}{"nil": nil}}}, "interface {}", `body[0]["nested"]["nil"]`},
+		{[]interface{}{[]interface{}{[]chan int{}}}, "chan int", "body[0][0]"},
+		{[]interface{}{map[uint32]interface{}{7: MakeVariant(map[string]interface{}{"c": complex(1, 2)})}}, "complex128", `body[0][7]["c"]`},
+	} {
+		enc := newEncoder(new(bytes.Buffer), binary.LittleEndian, nil)
+		err := enc.Encode(tc.vs...)
+		var typeErr InvalidTypeError
+		if !errors.As(err, &typeErr) {
+			t.Errorf("got %v, want an InvalidTypeError", err)
+			continue
+		}
+		if typeErr.Type.String() != tc.typ || typeErr.Path != tc.path {
+			t.Errorf("got type %s at %s, want %s at %s", typeErr.Type, typeErr.Path, tc.typ, tc.path)
+		}
+		if want := "dbus: invalid type " + tc.typ + " at " + tc.path; err.Error() != want {
+			t.Errorf("got error %q, want %q", err, want)
+		}
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	for _, vs := range [][]interface{}{
+		{},
+		{byte(1)},
+		{byte(1), int64(2)},
+		{"hello", ObjectPath("/a/b"), Signature{"a{sv}"}},
+		{[]byte("some bytes"), []int64{1, 2, 3}},
+		{byte(1), []struct {
+			A byte
+			B []string
+		}{{1, []string{"x"}}, {2, nil}}},
+		{map[string]interface{}{"a": uint16(1), "b": []interface{}{"c", 1.5}}},
+		{map[string]map[int32]interface{}{"x": {1: byte(1), 2: int64(2), 3: "s"}, "y": {4: true}}},
+		{MakeVariant(map[uint32]Variant{1: MakeVariant(true)})},
+		{NewOrderedMap(Signature{"a{sv}"}).Add("x", MakeVariant(int32(1))).Add("y", MakeVariant("z"))},
+		{UnixFD(3), [3]byte{1, 2, 3}},
+	} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			buf := new(bytes.Buffer)
+			if err := newEncoder(buf, order, nil).Encode(vs...); err != nil {
+				t.Fatal(err)
+			}
+			size, err := EncodedSize(order, vs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != buf.Len() {
+				t.Errorf("got size %d for %v, encoded %d bytes", size, vs, buf.Len())
+			}
+		}
+	}
+	if _, err := EncodedSize(binary.LittleEndian, "ok", make(chan int)); err == nil {
+		t.Error("expected an error for an invalid type")
+	}
+	if _, err := EncodedSize(nil, "ok"); err == nil {
+		t.Error("expected an error for an invalid byte order")
+	}
+}
+
+// nestedArrays returns a value nested depth arrays deep, e.g. [][]int32{{1}}
+// for depth 2.
+func nestedArrays(depth int) interface{} {
+	v := reflect.ValueOf(int32(1))
+	for i := 0; i < depth; i++ {
+		s := reflect.MakeSlice(reflect.SliceOf(v.Type()), 1, 1)
+		s.Index(0).Set(v)
+		v = s
+	}
+	return v.Interface()
+}
+
+// writeCounter counts the writes made to it.
+type writeCounter struct {
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return len(p), nil
+}
+
+func TestEncodedSizeDeepNesting(t *testing.T) {
+	const depth = 21
+	v := nestedArrays(depth)
+	buf := new(bytes.Buffer)
+	if err := newEncoder(buf, binary.LittleEndian, nil).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	size, err := EncodedSize(binary.LittleEndian, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != buf.Len() {
+		t.Errorf("got size %d, encoded %d bytes", size, buf.Len())
+	}
+
+	// every array is sized a single time, so the number of writes grows
+	// linearly with the depth
+	w := new(writeCounter)
+	enc := newEncoder(w, binary.LittleEndian, nil)
+	enc.sizeOnly = true
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes > 4*depth {
+		t.Errorf("sizing a value nested %d arrays deep took %d writes", depth, w.writes)
+	}
+}
+
+func BenchmarkEncodedSizeDeepNesting(b *testing.B) {
+	v := nestedArrays(21)
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodedSize(binary.LittleEndian, v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 type empty interface{}
 
 func TestEncodeMapStringNamedInterface(t *testing.T) {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -62,3 +63,26 @@ DBUS_SESSION_BUS_WINDOWID=16777217`
 		}
 	}
 }
+
+func TestAutolaunchFailureListsAttempts(t *testing.T) {
+	if tryDiscoverDbusSessionBusAddress() != "" {
+		t.Skip("a running session bus is discovered")
+	}
+	mockedExitStatus = 1
+	mockedStdout = ""
+	execCommand = fakeExecCommand
+	defer func() {
+		execCommand = exec.Command
+		mockedExitStatus = 0
+	}()
+
+	_, err := Dial("autolaunch:scope=*user")
+	if err == nil {
+		t.Fatal("expected autolaunch to fail")
+	}
+	for _, want := range []string{"discovering a running session bus", "launching a session bus: exit status 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
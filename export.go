@@ -1,6 +1,7 @@
 package dbus
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
@@ -8,25 +9,81 @@ import (
 	"strings"
 )
 
+// Names of the standard errors defined by the specification and the reference
+// implementation.
+const (
+	ErrNameFailed                           = "org.freedesktop.DBus.Error.Failed"
+	ErrNameNoMemory                         = "org.freedesktop.DBus.Error.NoMemory"
+	ErrNameServiceUnknown                   = "org.freedesktop.DBus.Error.ServiceUnknown"
+	ErrNameNameHasNoOwner                   = "org.freedesktop.DBus.Error.NameHasNoOwner"
+	ErrNameNoReply                          = "org.freedesktop.DBus.Error.NoReply"
+	ErrNameIOError                          = "org.freedesktop.DBus.Error.IOError"
+	ErrNameBadAddress                       = "org.freedesktop.DBus.Error.BadAddress"
+	ErrNameNotSupported                     = "org.freedesktop.DBus.Error.NotSupported"
+	ErrNameLimitsExceeded                   = "org.freedesktop.DBus.Error.LimitsExceeded"
+	ErrNameAccessDenied                     = "org.freedesktop.DBus.Error.AccessDenied"
+	ErrNameAuthFailed                       = "org.freedesktop.DBus.Error.AuthFailed"
+	ErrNameNoServer                         = "org.freedesktop.DBus.Error.NoServer"
+	ErrNameTimeout                          = "org.freedesktop.DBus.Error.Timeout"
+	ErrNameNoNetwork                        = "org.freedesktop.DBus.Error.NoNetwork"
+	ErrNameAddressInUse                     = "org.freedesktop.DBus.Error.AddressInUse"
+	ErrNameDisconnected                     = "org.freedesktop.DBus.Error.Disconnected"
+	ErrNameInvalidArgs                      = "org.freedesktop.DBus.Error.InvalidArgs"
+	ErrNameFileNotFound                     = "org.freedesktop.DBus.Error.FileNotFound"
+	ErrNameFileExists                       = "org.freedesktop.DBus.Error.FileExists"
+	ErrNameUnknownMethod                    = "org.freedesktop.DBus.Error.UnknownMethod"
+	ErrNameUnknownObject                    = "org.freedesktop.DBus.Error.UnknownObject"
+	ErrNameUnknownInterface                 = "org.freedesktop.DBus.Error.UnknownInterface"
+	ErrNameUnknownProperty                  = "org.freedesktop.DBus.Error.UnknownProperty"
+	ErrNamePropertyReadOnly                 = "org.freedesktop.DBus.Error.PropertyReadOnly"
+	ErrNameTimedOut                         = "org.freedesktop.DBus.Error.TimedOut"
+	ErrNameMatchRuleNotFound                = "org.freedesktop.DBus.Error.MatchRuleNotFound"
+	ErrNameMatchRuleInvalid                 = "org.freedesktop.DBus.Error.MatchRuleInvalid"
+	ErrNameInvalidSignature                 = "org.freedesktop.DBus.Error.InvalidSignature"
+	ErrNameInconsistentMessage              = "org.freedesktop.DBus.Error.InconsistentMessage"
+	ErrNameInteractiveAuthorizationRequired = "org.freedesktop.DBus.Error.InteractiveAuthorizationRequired"
+	ErrNameUnixProcessIdUnknown             = "org.freedesktop.DBus.Error.UnixProcessIdUnknown"
+	ErrNameObjectPathInUse                  = "org.freedesktop.DBus.Error.ObjectPathInUse"
+	ErrNameSELinuxSecurityContextUnknown    = "org.freedesktop.DBus.Error.SELinuxSecurityContextUnknown"
+	ErrNameSpawnExecFailed                  = "org.freedesktop.DBus.Error.Spawn.ExecFailed"
+	ErrNameSpawnServiceNotFound             = "org.freedesktop.DBus.Error.Spawn.ServiceNotFound"
+	ErrNameSpawnChildExited                 = "org.freedesktop.DBus.Error.Spawn.ChildExited"
+)
+
 var (
 	ErrMsgInvalidArg = Error{
-		"org.freedesktop.DBus.Error.InvalidArgs",
+		ErrNameInvalidArgs,
 		[]interface{}{"Invalid type / number of args"},
 	}
+	ErrMsgUnknownObject = Error{
+		ErrNameUnknownObject,
+		[]interface{}{"No such object"},
+	}
+	// Deprecated: the specification names this error UnknownObject, which
+	// is what method calls on unknown paths return; use ErrMsgUnknownObject.
 	ErrMsgNoObject = Error{
 		"org.freedesktop.DBus.Error.NoSuchObject",
 		[]interface{}{"No such object"},
 	}
 	ErrMsgUnknownMethod = Error{
-		"org.freedesktop.DBus.Error.UnknownMethod",
+		ErrNameUnknownMethod,
 		[]interface{}{"Unknown / invalid method"},
 	}
 	ErrMsgUnknownInterface = Error{
-		"org.freedesktop.DBus.Error.UnknownInterface",
+		ErrNameUnknownInterface,
 		[]interface{}{"Object does not implement the interface"},
 	}
 )
 
+func MakeUnknownObjectError(path ObjectPath) Error {
+	return Error{
+		ErrNameUnknownObject,
+		[]interface{}{fmt.Sprintf("No such object '%s'", string(path))},
+	}
+}
+
+// Deprecated: use MakeUnknownObjectError, which returns the error name from
+// the specification.
 func MakeNoObjectError(path ObjectPath) Error {
 	return Error{
 		"org.freedesktop.DBus.Error.NoSuchObject",
@@ -36,23 +93,34 @@ func MakeNoObjectError(path ObjectPath) Error {
 
 func MakeUnknownMethodError(methodName string) Error {
 	return Error{
-		"org.freedesktop.DBus.Error.UnknownMethod",
+		ErrNameUnknownMethod,
 		[]interface{}{fmt.Sprintf("Unknown / invalid method '%s'", methodName)},
 	}
 }
 
 func MakeUnknownInterfaceError(ifaceName string) Error {
 	return Error{
-		"org.freedesktop.DBus.Error.UnknownInterface",
+		ErrNameUnknownInterface,
 		[]interface{}{fmt.Sprintf("Object does not implement the interface '%s'", ifaceName)},
 	}
 }
 
+// MakeFailedError converts err to a D-Bus error named
+// org.freedesktop.DBus.Error.Failed with the message of err as its body, so
+// that exported methods can return ordinary Go errors to their callers.
 func MakeFailedError(err error) *Error {
-	return &Error{
-		"org.freedesktop.DBus.Error.Failed",
-		[]interface{}{err.Error()},
+	return MakeNamedError(ErrNameFailed, err)
+}
+
+// MakeNamedError acts like MakeFailedError, but uses the given error name. If
+// name is not a valid error name, org.freedesktop.DBus.Error.Failed is used
+// instead, so that the caller still gets the message of err.
+func MakeNamedError(name string, err error) *Error {
+	e, nameErr := NewValidError(name, []interface{}{err.Error()})
+	if nameErr != nil {
+		return NewError(ErrNameFailed, []interface{}{err.Error()})
 	}
+	return e
 }
 
 // Sender is a type which can be used in exported methods to receive the message
@@ -145,14 +213,30 @@ func (conn *Conn) decodeArguments(m Method, sender string, msg *Message) ([]inte
 // handleCall handles the given method call (i.e. looks if it's one of the
 // pre-implemented ones and searches for a corresponding handler if not).
 func (conn *Conn) handleCall(msg *Message) {
-	name := msg.Headers[FieldMember].value.(string)
-	path := msg.Headers[FieldPath].value.(ObjectPath)
-	ifaceName, _ := msg.Headers[FieldInterface].value.(string)
+	// Messages normally pass validateHeader before they get here, but don't
+	// rely on it: a malformed header must result in an error reply rather
+	// than a panic.
 	sender, hasSender := msg.Headers[FieldSender].value.(string)
 	serial := msg.serial
+	if _, ok := msg.Headers[FieldSender]; ok && !hasSender {
+		conn.sendError(ErrMsgInvalidArg, "", serial)
+		return
+	}
 
+	name, _ := msg.Headers[FieldMember].value.(string)
 	if len(name) == 0 {
 		conn.sendError(ErrMsgUnknownMethod, sender, serial)
+		return
+	}
+	path, _ := msg.Headers[FieldPath].value.(ObjectPath)
+	if !path.IsValid() {
+		conn.sendError(ErrMsgUnknownObject, sender, serial)
+		return
+	}
+	ifaceName, ok := msg.Headers[FieldInterface].value.(string)
+	if _, present := msg.Headers[FieldInterface]; present && !ok {
+		conn.sendError(ErrMsgUnknownInterface, sender, serial)
+		return
 	}
 
 	if ifaceName == "org.freedesktop.DBus.Peer" {
@@ -167,20 +251,33 @@ func (conn *Conn) handleCall(msg *Message) {
 		return
 	}
 
-	object, ok := conn.handler.LookupObject(path)
+	object, ok := conn.lookupObject(path)
 	if !ok {
-		conn.sendError(MakeNoObjectError(path), sender, serial)
+		conn.sendError(MakeUnknownObjectError(path), sender, serial)
 		return
 	}
 
 	iface, exists := object.LookupInterface(ifaceName)
 	if !exists {
+		if !conn.objectExists(path) {
+			conn.sendError(MakeUnknownObjectError(path), sender, serial)
+			return
+		}
 		conn.sendError(MakeUnknownInterfaceError(ifaceName), sender, serial)
 		return
 	}
 
+	if intf, ok := iface.(*exportedIntf); ok && intf.raw != nil {
+		conn.handleRawCall(intf.raw, msg)
+		return
+	}
+
 	m, exists := iface.LookupMethod(name)
 	if !exists {
+		if !conn.objectExists(path) {
+			conn.sendError(MakeUnknownObjectError(path), sender, serial)
+			return
+		}
 		conn.sendError(MakeUnknownMethodError(name), sender, serial)
 		return
 	}
@@ -195,6 +292,12 @@ func (conn *Conn) handleCall(msg *Message) {
 		conn.sendError(err, sender, serial)
 		return
 	}
+	ret, files, err := conn.streamReturnValues(m, ret)
+	if err != nil {
+		conn.sendError(MakeFailedError(err), sender, serial)
+		return
+	}
+	defer closeFiles(files)
 
 	if msg.Flags&FlagNoReplyExpected == 0 {
 		reply := new(Message)
@@ -218,7 +321,60 @@ func (conn *Conn) handleCall(msg *Message) {
 
 // Emit emits the given signal on the message bus. The name parameter must be
 // formatted as "interface.member", e.g., "org.freedesktop.DBus.NameLost".
+//
+// File descriptors given as UnixFD values are passed along with the signal, as
+// for method calls. This requires a connection for which SupportsUnixFDs
+// returns true; otherwise, Emit returns an error instead of sending the
+// signal. The descriptors are duplicated by the kernel while sending, so the
+// caller may close them once Emit returns.
 func (conn *Conn) Emit(path ObjectPath, name string, values ...interface{}) error {
+	return conn.EmitWithOptions(path, name, nil, values...)
+}
+
+// EmitInterfacesAdded emits the org.freedesktop.DBus.ObjectManager
+// InterfacesAdded signal from the object manager at manager, announcing that
+// the object at path gained the given interfaces. ifaces maps interface names
+// to their properties and their values.
+func (conn *Conn) EmitInterfacesAdded(manager, path ObjectPath, ifaces map[string]map[string]Variant) error {
+	return conn.Emit(manager, "org.freedesktop.DBus.ObjectManager.InterfacesAdded", path, ifaces)
+}
+
+// EmitInterfacesRemoved emits the org.freedesktop.DBus.ObjectManager
+// InterfacesRemoved signal from the object manager at manager, announcing that
+// the given interfaces were removed from the object at path.
+func (conn *Conn) EmitInterfacesRemoved(manager, path ObjectPath, ifaces []string) error {
+	return conn.Emit(manager, "org.freedesktop.DBus.ObjectManager.InterfacesRemoved", path, ifaces)
+}
+
+// EmitOption is an option for EmitWithOptions.
+type EmitOption func(msg *Message)
+
+// WithEmitDestination sends the signal to the given connection only instead of
+// broadcasting it. The recipient receives it even if it has no matching rule.
+func WithEmitDestination(dest string) EmitOption {
+	return func(msg *Message) {
+		msg.Headers[FieldDestination] = MakeVariant(dest)
+	}
+}
+
+// WithEmitFlags sets the flags of the signal message.
+func WithEmitFlags(flags Flags) EmitOption {
+	return func(msg *Message) {
+		msg.Flags = flags
+	}
+}
+
+// WithEmitByteOrder sends the signal in the given byte order instead of the
+// native one.
+func WithEmitByteOrder(order binary.ByteOrder) EmitOption {
+	return func(msg *Message) {
+		msg.order = order
+	}
+}
+
+// EmitWithOptions acts like Emit, but applies the given options to the signal
+// message before sending it.
+func (conn *Conn) EmitWithOptions(path ObjectPath, name string, opts []EmitOption, values ...interface{}) error {
 	i := strings.LastIndex(name, ".")
 	if i == -1 {
 		return errors.New("dbus: invalid method name")
@@ -235,6 +391,9 @@ func (conn *Conn) Emit(path ObjectPath, name string, values ...interface{}) erro
 	if len(values) > 0 {
 		msg.Headers[FieldSignature] = MakeVariant(SignatureOf(values...))
 	}
+	for _, opt := range opts {
+		opt(msg)
+	}
 
 	var closed bool
 	err := conn.sendMessageAndIfClosed(msg, func() {
@@ -264,12 +423,37 @@ func (conn *Conn) Emit(path ObjectPath, name string, values ...interface{}) erro
 // received on the bus. Again, parameters of this type do not contribute to the
 // dbus signature of the method.
 //
+// Return values of type *os.File or of an interface type like io.Reader are
+// sent as Unix file descriptors (signature "h"), which requires a connection
+// for which SupportsUnixFDs returns true. A file is sent as is, while the
+// contents of any other reader are copied in the background into a pipe whose
+// read end is sent; the reader is closed afterwards if it is an io.Closer.
+// The package closes returned files once the reply has been sent. Clients can
+// use UnixFD.File to read from the received descriptor.
+//
+// If v is a pointer to a struct, its exported fields tagged with
+// `dbus:",property"` (read-only) or `dbus:",property,readwrite"` are made
+// available as properties of iface through org.freedesktop.DBus.Properties,
+// which is implemented for the object unless it is exported explicitly, e.g.
+// with the prop package. The properties are named like the fields, unless the
+// tag gives a name before the options, as in `dbus:"Name,property"`; the same
+// tag is read by Object.StorePropertiesInto, so a struct can be used with
+// both. Setting a property through that interface emits PropertiesChanged if
+// its value changed. Accesses through the interface are serialized with each
+// other; the application changes the fields with UpdateProperties to serialize
+// its own accesses with them and signal the change.
+//
 // Every method call is executed in a new goroutine, so the method may be called
 // in multiple goroutines at once.
 //
 // Method calls on the interface org.freedesktop.DBus.Peer will be automatically
 // handled for every object.
 //
+// A method call that specifies an interface is only dispatched to the value
+// exported for that interface. If it doesn't specify one and several
+// interfaces of the object have a method of that name, the interface whose
+// name sorts first is used.
+//
 // Passing nil as the first parameter will cause conn to cease handling calls on
 // the given combination of path and interface.
 //
@@ -287,7 +471,7 @@ func (conn *Conn) Export(v interface{}, path ObjectPath, iface string) error {
 // it is sent back to the caller as an error. Otherwise, a method reply is
 // sent with the other return values as its body.
 func (conn *Conn) ExportAll(v interface{}, path ObjectPath, iface string) error {
-	return conn.export(getAllMethods(v, nil), path, iface, false)
+	return conn.export(v, getAllMethods(v, nil), nil, path, iface, false)
 }
 
 // ExportWithMap works exactly like Export but provides the ability to remap
@@ -296,7 +480,17 @@ func (conn *Conn) ExportAll(v interface{}, path ObjectPath, iface string) error
 // The keys in the map are the real method names (exported on the struct), and
 // the values are the method names to be exported on DBus.
 func (conn *Conn) ExportWithMap(v interface{}, mapping map[string]string, path ObjectPath, iface string) error {
-	return conn.export(getMethods(v, mapping), path, iface, false)
+	return conn.export(v, getMethods(v, mapping), nil, path, iface, false)
+}
+
+// ExportWithNameTransform works exactly like Export, but the member names of
+// incoming method calls are passed to transform to obtain the name of the
+// method of v to call, e.g. to map "Get_Status" to "GetStatus". Unlike
+// ExportWithMap, this handles interfaces with systematically different names
+// without listing every method. If transform returns a name that v has no
+// suitable method for, the call fails with an UnknownMethod error.
+func (conn *Conn) ExportWithNameTransform(v interface{}, transform func(dbusName string) string, path ObjectPath, iface string) error {
+	return conn.export(v, getMethods(v, nil), transform, path, iface, false)
 }
 
 // ExportSubtree works exactly like Export but registers the given value for
@@ -319,7 +513,7 @@ func (conn *Conn) ExportSubtree(v interface{}, path ObjectPath, iface string) er
 // The keys in the map are the real method names (exported on the struct), and
 // the values are the method names to be exported on DBus.
 func (conn *Conn) ExportSubtreeWithMap(v interface{}, mapping map[string]string, path ObjectPath, iface string) error {
-	return conn.export(getMethods(v, mapping), path, iface, true)
+	return conn.export(v, getMethods(v, mapping), nil, path, iface, true)
 }
 
 // ExportMethodTable like Export registers the given methods as an object
@@ -341,6 +535,67 @@ func (conn *Conn) ExportSubtreeMethodTable(methods map[string]interface{}, path
 	return conn.exportMethodTable(methods, path, iface, true)
 }
 
+// RawMethodHandler handles method calls without any decoding of arguments or
+// encoding of return values. It returns the reply to send, which may be a
+// method reply or an error, or nil to send no reply.
+type RawMethodHandler func(msg *Message) *Message
+
+// ExportRawHandler registers handler to be called for all method calls on the
+// given path and interface, bypassing the reflection done by Export. This
+// gives full control over dispatching and building replies; the handler is
+// called with the received message and returns the reply. The reply serial
+// and destination of the reply are filled in by conn unless they are set
+// already, and the reply is dropped if the caller didn't expect one.
+//
+// Calls are only dispatched to handler if they specify the interface. As with
+// Export, every call is executed in a new goroutine, and passing a nil handler
+// ceases handling calls on the given combination of path and interface.
+func (conn *Conn) ExportRawHandler(handler RawMethodHandler, path ObjectPath, iface string) error {
+	h, ok := conn.handler.(*defaultHandler)
+	if !ok {
+		return fmt.Errorf(
+			`dbus: export only allowed on the default handler. Received: %T"`,
+			conn.handler)
+	}
+	if !path.IsValid() {
+		return fmt.Errorf(`dbus: Invalid path name: "%s"`, path)
+	}
+	if handler == nil {
+		return conn.unexport(h, path, iface)
+	}
+	if !h.PathExists(path) {
+		h.AddObject(path, newExportedObject())
+	}
+	intf := newExportedIntf(nil, false)
+	intf.raw = handler
+	h.objects[path].AddInterface(iface, intf)
+	return nil
+}
+
+// handleRawCall passes msg to the raw handler and sends the reply it returns.
+func (conn *Conn) handleRawCall(handler RawMethodHandler, msg *Message) {
+	reply := handler(msg)
+	if reply == nil || msg.Flags&FlagNoReplyExpected != 0 {
+		return
+	}
+	if reply.Headers == nil {
+		reply.Headers = make(map[HeaderField]Variant)
+	}
+	if _, ok := reply.Headers[FieldReplySerial]; !ok {
+		reply.Headers[FieldReplySerial] = MakeVariant(msg.serial)
+	}
+	if sender, ok := msg.Headers[FieldSender]; ok {
+		if _, ok := reply.Headers[FieldDestination]; !ok {
+			reply.Headers[FieldDestination] = sender
+		}
+	}
+	if err := conn.sendMessageAndIfClosed(reply, nil); err != nil {
+		if _, ok := err.(FormatError); ok {
+			fmt.Fprintf(os.Stderr, "dbus: dropping invalid raw reply to %s: %s\n", msg.Headers[FieldPath].value, err)
+		}
+	}
+}
+
 func (conn *Conn) exportMethodTable(methods map[string]interface{}, path ObjectPath, iface string, includeSubtree bool) error {
 	var out map[string]reflect.Value
 	if methods != nil {
@@ -359,7 +614,24 @@ func (conn *Conn) exportMethodTable(methods map[string]interface{}, path ObjectP
 			out[name] = rval
 		}
 	}
-	return conn.export(out, path, iface, includeSubtree)
+	return conn.export(nil, out, nil, path, iface, includeSubtree)
+}
+
+// lookupObject looks up the object at path in the handler of conn. With
+// WithAutoIntrospection, the default handler uses the Introspectable interface
+// of conn, which describes the exported interfaces.
+func (conn *Conn) lookupObject(path ObjectPath) (ServerObject, bool) {
+	if h, ok := conn.handler.(*defaultHandler); ok && conn.introspect != nil {
+		return h.lookupObject(path, conn.introspect)
+	}
+	return conn.handler.LookupObject(path)
+}
+
+// objectExists returns whether there is an object at path. Custom handlers
+// decide this in LookupObject, so it's only consulted for the default handler.
+func (conn *Conn) objectExists(path ObjectPath) bool {
+	h, ok := conn.handler.(*defaultHandler)
+	return !ok || h.objectExists(path)
 }
 
 func (conn *Conn) unexport(h *defaultHandler, path ObjectPath, iface string) error {
@@ -368,13 +640,16 @@ func (conn *Conn) unexport(h *defaultHandler, path ObjectPath, iface string) err
 		obj.DeleteInterface(iface)
 		if len(obj.interfaces) == 0 {
 			h.DeleteObject(path)
+		} else if props := obj.interfaces[propertiesIntf]; len(obj.interfaces) == 1 && props != nil && isStructProperties(props) {
+			// only the properties of the removed structs are left
+			h.DeleteObject(path)
 		}
 	}
 	return nil
 }
 
 // export is the worker function for all exports/registrations.
-func (conn *Conn) export(methods map[string]reflect.Value, path ObjectPath, iface string, includeSubtree bool) error {
+func (conn *Conn) export(v interface{}, methods map[string]reflect.Value, transform func(string) string, path ObjectPath, iface string, includeSubtree bool) error {
 	h, ok := conn.handler.(*defaultHandler)
 	if !ok {
 		return fmt.Errorf(
@@ -391,6 +666,14 @@ func (conn *Conn) export(methods map[string]reflect.Value, path ObjectPath, ifac
 		return conn.unexport(h, path, iface)
 	}
 
+	var props map[string]structProperty
+	if iface != propertiesIntf {
+		var err error
+		if props, err = structProperties(v); err != nil {
+			return err
+		}
+	}
+
 	// If this is the first handler for this path, make a new map to hold all
 	// handlers for this path.
 	if !h.PathExists(path) {
@@ -404,11 +687,41 @@ func (conn *Conn) export(methods map[string]reflect.Value, path ObjectPath, ifac
 
 	// Finally, save this handler
 	obj := h.objects[path]
-	obj.AddInterface(iface, newExportedIntf(exportedMethods, includeSubtree))
+	intf := newExportedIntf(exportedMethods, includeSubtree)
+	intf.value = v
+	intf.nameTransform = transform
+	intf.props = props
+	obj.AddInterface(iface, intf)
+	if props != nil {
+		conn.addStructProperties(obj, path)
+	}
 
 	return nil
 }
 
+// ExportedPaths returns the sorted paths of all objects exported on conn with
+// Export and its variants. It returns nil if conn doesn't use the default
+// handler.
+func (conn *Conn) ExportedPaths() []ObjectPath {
+	h, ok := conn.handler.(*defaultHandler)
+	if !ok {
+		return nil
+	}
+	return h.paths()
+}
+
+// ExportedInterfaces returns the sorted names of the interfaces exported at
+// path. Interfaces that are implemented for every object, such as
+// org.freedesktop.DBus.Peer and org.freedesktop.DBus.Introspectable, are only
+// included if they were exported explicitly.
+func (conn *Conn) ExportedInterfaces(path ObjectPath) []string {
+	h, ok := conn.handler.(*defaultHandler)
+	if !ok {
+		return nil
+	}
+	return h.interfaces(path)
+}
+
 // ReleaseName calls org.freedesktop.DBus.ReleaseName and awaits a response.
 func (conn *Conn) ReleaseName(name string) (ReleaseNameReply, error) {
 	var r uint32
@@ -416,19 +729,74 @@ func (conn *Conn) ReleaseName(name string) (ReleaseNameReply, error) {
 	if err != nil {
 		return 0, err
 	}
+	if ReleaseNameReply(r) == ReleaseNameReplyReleased {
+		conn.names.loseName(name)
+	}
 	return ReleaseNameReply(r), nil
 }
 
 // RequestName calls org.freedesktop.DBus.RequestName and awaits a response.
+//
+// If the name is acquired right away, it is included in Names when
+// RequestName returns. If the request is queued, the name is added once the
+// bus signals that it has been acquired.
 func (conn *Conn) RequestName(name string, flags RequestNameFlags) (RequestNameReply, error) {
 	var r uint32
 	err := conn.busObj.Call("org.freedesktop.DBus.RequestName", 0, name, flags).Store(&r)
 	if err != nil {
 		return 0, err
 	}
+	switch RequestNameReply(r) {
+	case RequestNameReplyPrimaryOwner, RequestNameReplyAlreadyOwner:
+		conn.names.acquireName(name)
+	}
 	return RequestNameReply(r), nil
 }
 
+// ListNames calls org.freedesktop.DBus.ListNames and returns the names that
+// currently have an owner on the bus, including unique connection names.
+func (conn *Conn) ListNames() ([]string, error) {
+	var names []string
+	err := conn.busObj.Call("org.freedesktop.DBus.ListNames", 0).Store(&names)
+	return names, err
+}
+
+// ListActivatableNames calls org.freedesktop.DBus.ListActivatableNames and
+// returns the names of the services that the bus can start on demand.
+func (conn *Conn) ListActivatableNames() ([]string, error) {
+	var names []string
+	err := conn.busObj.Call("org.freedesktop.DBus.ListActivatableNames", 0).Store(&names)
+	return names, err
+}
+
+// GetConnectionCredentials calls org.freedesktop.DBus.GetConnectionCredentials
+// and returns the credentials of the connection that owns the given name, as
+// far as the bus knows them.
+func (conn *Conn) GetConnectionCredentials(name string) (*ConnectionCredentials, error) {
+	var raw map[string]Variant
+	err := conn.busObj.Call("org.freedesktop.DBus.GetConnectionCredentials", 0, name).Store(&raw)
+	if err != nil {
+		return nil, err
+	}
+	creds := &ConnectionCredentials{Raw: raw}
+	if err := storeProperties(raw, reflect.ValueOf(creds).Elem()); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// StartServiceByName calls org.freedesktop.DBus.StartServiceByName to
+// activate the service that provides the given name. The flags are currently
+// unused by the specification and should be 0.
+func (conn *Conn) StartServiceByName(name string, flags uint32) (StartServiceReply, error) {
+	var r uint32
+	err := conn.busObj.Call("org.freedesktop.DBus.StartServiceByName", 0, name, flags).Store(&r)
+	if err != nil {
+		return 0, err
+	}
+	return StartServiceReply(r), nil
+}
+
 // ReleaseNameReply is the reply to a ReleaseName call.
 type ReleaseNameReply uint32
 
@@ -438,6 +806,28 @@ const (
 	ReleaseNameReplyNotOwner
 )
 
+// ConnectionCredentials are the credentials of a connection returned by
+// GetConnectionCredentials. Credentials that the bus didn't report are nil.
+type ConnectionCredentials struct {
+	UnixUserID         *uint32
+	UnixGroupIDs       []uint32
+	ProcessID          *uint32
+	WindowsSID         *string
+	LinuxSecurityLabel []byte
+
+	// Raw holds all credentials as returned by the bus, including those
+	// without a field above, such as ProcessFD.
+	Raw map[string]Variant `dbus:"-"`
+}
+
+// StartServiceReply is the reply to a StartServiceByName call.
+type StartServiceReply uint32
+
+const (
+	StartServiceReplySuccess StartServiceReply = 1 + iota
+	StartServiceReplyAlreadyRunning
+)
+
 // RequestNameFlags represents the possible flags for a RequestName call.
 type RequestNameFlags uint32
 
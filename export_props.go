@@ -0,0 +1,293 @@
+package dbus
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const propertiesIntf = "org.freedesktop.DBus.Properties"
+
+// structProperty is a struct field exported as a property.
+type structProperty struct {
+	index    int
+	sig      string
+	writable bool
+}
+
+// propertyTag parses the `dbus` tag of a struct field that stands for a
+// property. Its grammar is `dbus:"[Name][,option]..."`, where Name is the name
+// of the property, which defaults to the name of the field, and the options
+// are those described for Export. It returns false for fields tagged with
+// `dbus:"-"`.
+func propertyTag(field reflect.StructField) (name string, opts []string, ok bool) {
+	tag := field.Tag.Get("dbus")
+	if tag == "-" {
+		return "", nil, false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name, parts[1:], true
+}
+
+// structProperties returns the properties declared by the fields of the struct
+// v points to whose tag has the property option, keyed by property name. It
+// returns nil if there are none.
+func structProperties(v interface{}) (map[string]structProperty, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, nil
+	}
+	t := rv.Elem().Type()
+	var props map[string]structProperty
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, ok := propertyTag(field)
+		if !ok {
+			continue
+		}
+		var isProp, writable bool
+		for _, opt := range opts {
+			switch opt {
+			case "property":
+				isProp = true
+			case "readwrite":
+				writable = true
+			default:
+				return nil, fmt.Errorf("dbus: invalid property option %q on field %s", opt, field.Name)
+			}
+		}
+		if !isProp {
+			if writable {
+				return nil, fmt.Errorf("dbus: readwrite option without property option on field %s", field.Name)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			return nil, fmt.Errorf("dbus: property field %s is not exported", field.Name)
+		}
+		sig, ok := typeSignature(field.Type)
+		if !ok {
+			return nil, fmt.Errorf("dbus: property field %s has invalid type %s", field.Name, field.Type)
+		}
+		if _, dup := props[name]; dup {
+			return nil, fmt.Errorf("dbus: several fields for property %s", name)
+		}
+		if props == nil {
+			props = make(map[string]structProperty)
+		}
+		props[name] = structProperty{index: i, sig: sig, writable: writable}
+	}
+	return props, nil
+}
+
+// typeSignature returns the signature of t, or false if t has no D-Bus
+// representation.
+func typeSignature(t reflect.Type) (sig string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			sig, ok = "", false
+		}
+	}()
+	return SignatureOfType(t).str, true
+}
+
+// exportedProps implements org.freedesktop.DBus.Properties for the tagged
+// fields of the structs exported on an object.
+type exportedProps struct {
+	conn *Conn
+	path ObjectPath
+	obj  *exportedObj
+
+	// mu serializes accesses to the fields made through this interface.
+	mu sync.Mutex
+}
+
+// addStructProperties makes obj implement org.freedesktop.DBus.Properties for
+// the tagged fields of exported structs, unless it exports that interface
+// itself.
+func (conn *Conn) addStructProperties(obj *exportedObj, path ObjectPath) {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	if _, ok := obj.interfaces[propertiesIntf]; ok {
+		return
+	}
+	p := &exportedProps{conn: conn, path: path, obj: obj}
+	methods := make(map[string]Method)
+	for name, method := range getMethods(p, nil) {
+		methods[name] = exportedMethod{method}
+	}
+	intf := newExportedIntf(methods, false)
+	intf.value = p
+	obj.interfaces[propertiesIntf] = intf
+}
+
+// isStructProperties returns whether intf is the interface added by
+// addStructProperties.
+func isStructProperties(intf *exportedIntf) bool {
+	_, ok := intf.value.(*exportedProps)
+	return ok
+}
+
+// lookup returns the struct exported for iface and its properties.
+func (p *exportedProps) lookup(iface string) (reflect.Value, map[string]structProperty, *Error) {
+	p.obj.mu.RLock()
+	intf, ok := p.obj.interfaces[iface]
+	p.obj.mu.RUnlock()
+	if !ok || intf.props == nil {
+		return reflect.Value{}, nil, NewError(ErrNameUnknownInterface, []interface{}{"No properties for interface " + iface})
+	}
+	return reflect.ValueOf(intf.value).Elem(), intf.props, nil
+}
+
+// Get implements org.freedesktop.DBus.Properties.Get.
+func (p *exportedProps) Get(iface, name string) (Variant, *Error) {
+	v, props, err := p.lookup(iface)
+	if err != nil {
+		return Variant{}, err
+	}
+	prop, ok := props[name]
+	if !ok {
+		return Variant{}, NewError(ErrNameUnknownProperty, []interface{}{"No such property " + name})
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return MakeVariantWithSignature(v.Field(prop.index).Interface(), Signature{prop.sig}), nil
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.GetAll.
+func (p *exportedProps) GetAll(iface string) (map[string]Variant, *Error) {
+	v, props, err := p.lookup(iface)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := make(map[string]Variant, len(props))
+	for name, prop := range props {
+		all[name] = MakeVariantWithSignature(v.Field(prop.index).Interface(), Signature{prop.sig})
+	}
+	return all, nil
+}
+
+// Set implements org.freedesktop.DBus.Properties.Set. It emits
+// PropertiesChanged if the value changed.
+func (p *exportedProps) Set(iface, name string, value Variant) *Error {
+	v, props, err := p.lookup(iface)
+	if err != nil {
+		return err
+	}
+	prop, ok := props[name]
+	if !ok {
+		return NewError(ErrNameUnknownProperty, []interface{}{"No such property " + name})
+	}
+	if !prop.writable {
+		return NewError(ErrNamePropertyReadOnly, []interface{}{"Property " + name + " is read-only"})
+	}
+	field := v.Field(prop.index)
+	newv := reflect.New(field.Type()).Elem()
+	if err := store(newv, reflect.ValueOf(value)); err != nil {
+		return NewError(ErrNameInvalidArgs, []interface{}{err.Error()})
+	}
+	p.mu.Lock()
+	unchanged := MakeVariant(field.Interface()).Equal(MakeVariant(newv.Interface()))
+	field.Set(newv)
+	p.mu.Unlock()
+	if !unchanged {
+		p.conn.emitPropertiesChanged(p.path, iface, map[string]Variant{
+			name: MakeVariantWithSignature(newv.Interface(), Signature{prop.sig}),
+		})
+	}
+	return nil
+}
+
+// IntrospectProperties implements PropertyIntrospector.
+func (p *exportedProps) IntrospectProperties(iface string) []PropertyInfo {
+	_, props, err := p.lookup(iface)
+	if err != nil {
+		return nil
+	}
+	out := make([]PropertyInfo, 0, len(props))
+	for name, prop := range props {
+		access := "read"
+		if prop.writable {
+			access = "readwrite"
+		}
+		out = append(out, PropertyInfo{Name: name, Type: prop.sig, Access: access})
+	}
+	return out
+}
+
+// EmitPropertiesChanged emits org.freedesktop.DBus.Properties.PropertiesChanged
+// with the current values of the given properties of the struct exported at
+// path for iface, whose properties are declared with tagged fields (see
+// Export). Changes made through the Properties interface are signalled
+// automatically.
+func (conn *Conn) EmitPropertiesChanged(path ObjectPath, iface string, names ...string) error {
+	return conn.UpdateProperties(path, iface, nil, names...)
+}
+
+// UpdateProperties calls update, if it isn't nil, while holding the lock that
+// serializes the accesses made through org.freedesktop.DBus.Properties to the
+// struct exported at path for iface (see Export), so that update can change
+// its tagged fields without racing with them. It then emits PropertiesChanged
+// with the new values of the given properties.
+func (conn *Conn) UpdateProperties(path ObjectPath, iface string, update func(), names ...string) error {
+	p, err := conn.structProps(path)
+	if err != nil {
+		return err
+	}
+	v, props, dbusErr := p.lookup(iface)
+	if dbusErr != nil {
+		return dbusErr
+	}
+	for _, name := range names {
+		if _, ok := props[name]; !ok {
+			return fmt.Errorf("dbus: no property %s in interface %s", name, iface)
+		}
+	}
+	changed := make(map[string]Variant, len(names))
+	p.mu.Lock()
+	if update != nil {
+		update()
+	}
+	for _, name := range names {
+		prop := props[name]
+		changed[name] = MakeVariantWithSignature(v.Field(prop.index).Interface(), Signature{prop.sig})
+	}
+	p.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	return conn.emitPropertiesChanged(path, iface, changed)
+}
+
+// structProps returns the Properties implementation added for the tagged
+// fields of the structs exported at path.
+func (conn *Conn) structProps(path ObjectPath) (*exportedProps, error) {
+	h, ok := conn.handler.(*defaultHandler)
+	if !ok {
+		return nil, fmt.Errorf("dbus: no properties exported at %s", path)
+	}
+	h.RLock()
+	obj, ok := h.objects[path]
+	h.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("dbus: no properties exported at %s", path)
+	}
+	obj.mu.RLock()
+	intf := obj.interfaces[propertiesIntf]
+	obj.mu.RUnlock()
+	if intf == nil || !isStructProperties(intf) {
+		return nil, fmt.Errorf("dbus: no properties exported at %s", path)
+	}
+	return intf.value.(*exportedProps), nil
+}
+
+func (conn *Conn) emitPropertiesChanged(path ObjectPath, iface string, changed map[string]Variant) error {
+	return conn.Emit(path, propertiesIntf+".PropertiesChanged", iface, changed, []string{})
+}
@@ -0,0 +1,80 @@
+package dbus
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+)
+
+var (
+	fileType   = reflect.TypeOf((*os.File)(nil))
+	readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+)
+
+// isStreamType returns whether values of type t, when returned by an exported
+// method, are sent as a Unix file descriptor (see streamReturnValues).
+func isStreamType(t reflect.Type) bool {
+	return t == fileType || t.Kind() == reflect.Interface && t.Implements(readerType)
+}
+
+// streamReturnValues replaces the return values of m that are declared as
+// *os.File or as an interface implementing io.Reader by UnixFDs. A file is sent
+// as is; the contents of any other reader are copied into a pipe whose read end
+// is sent. Values of other declared types are left alone, even if they happen
+// to implement io.Reader. The returned files must be closed once the reply has
+// been sent.
+func (conn *Conn) streamReturnValues(m Method, ret []interface{}) ([]interface{}, []*os.File, error) {
+	em, ok := m.(exportedMethod)
+	if !ok {
+		return ret, nil, nil
+	}
+	t := em.Type()
+	var files []*os.File
+	for i, v := range ret {
+		if i >= t.NumOut() || !isStreamType(t.Out(i)) {
+			continue
+		}
+		var f *os.File
+		switch v := v.(type) {
+		case *os.File:
+			if v == nil {
+				continue
+			}
+			f = v
+		case io.Reader:
+			pr, pw, err := newStreamPipe()
+			if err != nil {
+				closeFiles(files)
+				return nil, nil, err
+			}
+			go copyToPipe(pw, v)
+			f = pr
+		default:
+			continue
+		}
+		files = append(files, f)
+		if !conn.unixFD {
+			closeFiles(files)
+			return nil, nil, errors.New("dbus: connection does not support passing file descriptors")
+		}
+		ret[i] = UnixFD(f.Fd())
+	}
+	return ret, files, nil
+}
+
+// copyToPipe copies r to w and closes both. If reading r fails, w is closed
+// with the error (see closeStream).
+func copyToPipe(w *os.File, r io.Reader) {
+	_, err := io.Copy(w, r)
+	closeStream(w, err)
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
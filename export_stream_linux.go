@@ -0,0 +1,40 @@
+package dbus
+
+import (
+	"os"
+	"syscall"
+)
+
+// newStreamPipe returns a connected pair of Unix stream sockets used to send
+// the contents of a reader returned by an exported method. A single byte is
+// queued on the write end and the read end is shut down for writing, so that
+// closing the write end without consuming that byte resets the connection:
+// the peer then reads the data written so far followed by ECONNRESET instead
+// of a normal end of file (see closeStream).
+func newStreamPipe() (r, w *os.File, err error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+	if _, err := syscall.Write(fds[0], []byte{0}); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, nil, os.NewSyscallError("write", err)
+	}
+	if err := syscall.Shutdown(fds[0], syscall.SHUT_WR); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, nil, os.NewSyscallError("shutdown", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "dbus-stream"), os.NewFile(uintptr(fds[1]), "dbus-stream"), nil
+}
+
+// closeStream closes the write end of a pipe returned by newStreamPipe. If err
+// is nil the peer sees a normal end of file; otherwise the connection is reset.
+func closeStream(w *os.File, err error) {
+	if err == nil {
+		var b [1]byte
+		w.Read(b[:])
+	}
+	w.Close()
+}
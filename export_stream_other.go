@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package dbus
+
+import (
+	"fmt"
+	"os"
+)
+
+// newStreamPipe returns the ends of a pipe used to send the contents of a
+// reader returned by an exported method.
+func newStreamPipe() (r, w *os.File, err error) {
+	return os.Pipe()
+}
+
+// closeStream closes the write end of a pipe returned by newStreamPipe. A
+// plain pipe cannot carry an error to the peer, so a non-nil err is reported
+// on stderr.
+func closeStream(w *os.File, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbus: failed to stream reply: %s\n", err)
+	}
+	w.Close()
+}
@@ -1,10 +1,17 @@
 package dbus
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 type lowerCaseExport struct{}
@@ -357,6 +364,74 @@ func TestExportWithMap_bypassAlias(t *testing.T) {
 	}
 }
 
+type snakeCaseExport struct {
+	mode string
+}
+
+func (e *snakeCaseExport) GetStatus() (string, *Error) {
+	return "running in " + e.mode + " mode", nil
+}
+
+func (e *snakeCaseExport) SetMode(mode string) *Error {
+	e.mode = mode
+	return nil
+}
+
+func (e *snakeCaseExport) Reset() *Error {
+	e.mode = ""
+	return nil
+}
+
+// snakeToCamel maps D-Bus member names like "get_status" to "GetStatus".
+func snakeToCamel(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func TestExportWithNameTransform(t *testing.T) {
+	connection, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer connection.Close()
+
+	err = connection.ExportWithNameTransform(&snakeCaseExport{}, snakeToCamel, "/org/guelfey/DBus/Test", "org.guelfey.DBus.Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := connection.Object(connection.Names()[0], "/org/guelfey/DBus/Test")
+
+	if err := object.Call("org.guelfey.DBus.Test.set_mode", 0, "safe").Err; err != nil {
+		t.Fatalf("Unexpected error calling set_mode: %s", err)
+	}
+	var status string
+	if err := object.Call("org.guelfey.DBus.Test.get_status", 0).Store(&status); err != nil {
+		t.Fatalf("Unexpected error calling get_status: %s", err)
+	}
+	if status != "running in safe mode" {
+		t.Errorf("got status %q", status)
+	}
+	// Calls without an interface are transformed as well.
+	if err := object.Call("Get_Status", 0).Store(&status); err != nil {
+		t.Fatalf("Unexpected error calling Get_Status: %s", err)
+	}
+	if err := object.Call("org.guelfey.DBus.Test.reset", 0).Err; err != nil {
+		t.Fatalf("Unexpected error calling reset: %s", err)
+	}
+	if err := object.Call("org.guelfey.DBus.Test.get_status", 0).Store(&status); err != nil || status != "running in  mode" {
+		t.Errorf("got status %q and error %v after reset", status, err)
+	}
+	err = object.Call("org.guelfey.DBus.Test.get_state", 0).Err
+	if dbusErr, ok := err.(Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Errorf("got %v for an unknown method, want UnknownMethod", err)
+	}
+}
+
 // Test typical ExportSubtree usage.
 func TestExportSubtree(t *testing.T) {
 	connection, err := ConnectSessionBus()
@@ -867,3 +942,1016 @@ func TestExportSubPathIntrospection(t *testing.T) {
 		t.Errorf("Unexpected introspection response for %s: %s", invalSubpath, response)
 	}
 }
+
+type autoIntroProps struct{}
+
+func (autoIntroProps) IntrospectProperties(iface string) []PropertyInfo {
+	if iface != "org.guelfey.DBus.Test1" {
+		return nil
+	}
+	return []PropertyInfo{{Name: "Count", Type: "u", Access: "read"}}
+}
+
+type autoIntroOverride struct{}
+
+func (autoIntroOverride) Introspect() (string, *Error) {
+	return "<node/>", nil
+}
+
+// Test that WithAutoIntrospection answers Introspect on objects that don't
+// export org.freedesktop.DBus.Introspectable from the export table.
+func TestAutoIntrospection(t *testing.T) {
+	const (
+		introIntf = "org.freedesktop.DBus.Introspectable"
+		pathstr   = "/org/guelfey/DBus/Test"
+		intfstr   = "org.guelfey.DBus.Test1"
+	)
+	server, err := ConnectSessionBus(WithAutoIntrospection())
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer server.Close()
+	client, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer client.Close()
+
+	if err := server.Export(&fooExport{}, pathstr, intfstr); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Export(autoIntroProps{}, pathstr, "org.freedesktop.DBus.Properties"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Export(barExport{}, pathstr+"/Bar", intfstr); err != nil {
+		t.Fatal(err)
+	}
+
+	var response string
+	obj := client.Object(server.Names()[0], pathstr)
+	if err := obj.Call(introIntf+".Introspect", 0).Store(&response); err != nil {
+		t.Fatalf("Unexpected error calling Introspect: %s", err)
+	}
+	for _, s := range []string{
+		`<interface name="` + intfstr + `">`,
+		`<method name="Foo">`,
+		`<arg type="s" direction="in"/>`,
+		`<arg type="s" direction="out"/>`,
+		`<property name="Count" type="u" access="read"/>`,
+		`<interface name="` + introIntf + `">`,
+		`<interface name="org.freedesktop.DBus.Peer">`,
+		`<node name="Bar"/>`,
+	} {
+		if !strings.Contains(response, s) {
+			t.Errorf("Introspection data lacks %s: %s", s, response)
+		}
+	}
+	if strings.Contains(response, `type="e"`) || strings.Contains(response, "Message") {
+		t.Errorf("Introspection data describes special arguments: %s", response)
+	}
+
+	// Objects that export Introspectable themselves take precedence.
+	if err := server.Export(autoIntroOverride{}, pathstr+"/Bar", introIntf); err != nil {
+		t.Fatal(err)
+	}
+	obj = client.Object(server.Names()[0], pathstr+"/Bar")
+	if err := obj.Call(introIntf+".Introspect", 0).Store(&response); err != nil {
+		t.Fatalf("Unexpected error calling Introspect: %s", err)
+	}
+	if response != "<node/>" {
+		t.Errorf("Introspect ignored the exported interface: %s", response)
+	}
+}
+
+// Test that WithAutoIntrospection only applies to the connection it is passed
+// to when the handler is shared.
+func TestAutoIntrospectionSharedHandler(t *testing.T) {
+	const pathstr = "/org/guelfey/DBus/Test"
+	h := NewDefaultHandler()
+	auto, err := ConnectSessionBus(WithHandler(h), WithAutoIntrospection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auto.Close()
+	plain, err := ConnectSessionBus(WithHandler(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if err := auto.Export(&fooExport{}, pathstr, "org.guelfey.DBus.Test1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var response string
+	err = plain.Object(auto.Names()[0], pathstr).Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&response)
+	if err != nil || !strings.Contains(response, `<method name="Foo">`) {
+		t.Errorf("got %q, %v from the connection with auto introspection", response, err)
+	}
+	err = auto.Object(plain.Names()[0], pathstr).Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&response)
+	if dbusErr, ok := err.(Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownInterface" {
+		t.Errorf("got %v from the connection without auto introspection, want UnknownInterface", err)
+	}
+}
+
+// Test that method calls with missing or mistyped header fields are answered
+// with an error instead of crashing the connection.
+func TestHandleCall_malformedHeaders(t *testing.T) {
+	var sent []*Message
+	conn, err := NewConn(rwc{Reader: strings.NewReader(""), Writer: io.Discard},
+		WithOutgoingInterceptor(func(msg *Message) {
+			sent = append(sent, msg)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Export(barExport{}, "/org/guelfey/DBus/Test", "org.guelfey.DBus.Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		headers map[HeaderField]Variant
+		want    string
+	}{
+		{"missing member", map[HeaderField]Variant{
+			FieldPath: MakeVariant(ObjectPath("/org/guelfey/DBus/Test")),
+		}, "org.freedesktop.DBus.Error.UnknownMethod"},
+		{"mistyped member", map[HeaderField]Variant{
+			FieldPath:   MakeVariant(ObjectPath("/org/guelfey/DBus/Test")),
+			FieldMember: MakeVariant(uint32(1)),
+		}, "org.freedesktop.DBus.Error.UnknownMethod"},
+		{"missing path", map[HeaderField]Variant{
+			FieldMember: MakeVariant("Foo"),
+		}, "org.freedesktop.DBus.Error.UnknownObject"},
+		{"mistyped path", map[HeaderField]Variant{
+			FieldPath:   MakeVariant("/org/guelfey/DBus/Test"),
+			FieldMember: MakeVariant("Foo"),
+		}, "org.freedesktop.DBus.Error.UnknownObject"},
+		{"mistyped interface", map[HeaderField]Variant{
+			FieldPath:      MakeVariant(ObjectPath("/org/guelfey/DBus/Test")),
+			FieldInterface: MakeVariant(int32(1)),
+			FieldMember:    MakeVariant("Foo"),
+		}, "org.freedesktop.DBus.Error.UnknownInterface"},
+		{"mistyped sender", map[HeaderField]Variant{
+			FieldPath:   MakeVariant(ObjectPath("/org/guelfey/DBus/Test")),
+			FieldMember: MakeVariant("Foo"),
+			FieldSender: MakeVariant(int32(1)),
+		}, "org.freedesktop.DBus.Error.InvalidArgs"},
+		{"missing interface", map[HeaderField]Variant{
+			FieldPath:   MakeVariant(ObjectPath("/org/guelfey/DBus/Test")),
+			FieldMember: MakeVariant("Bar"),
+		}, "org.freedesktop.DBus.Error.UnknownMethod"},
+	} {
+		sent = nil
+		conn.handleCall(&Message{Type: TypeMethodCall, Headers: tc.headers, serial: 1})
+		if len(sent) != 1 {
+			t.Errorf("%s: expected one reply, got %d", tc.name, len(sent))
+			continue
+		}
+		if sent[0].Type != TypeError {
+			t.Errorf("%s: expected an error reply, got %v", tc.name, sent[0].Type)
+			continue
+		}
+		if name := sent[0].Headers[FieldErrorName].value; name != tc.want {
+			t.Errorf("%s: expected error %s, got %v", tc.name, tc.want, name)
+		}
+	}
+}
+
+func hasName(conn *Conn, name string) bool {
+	for _, n := range conn.Names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNamesQueuedAcquisition(t *testing.T) {
+	const name = "org.godbus.DBus.TestQueuedName"
+	owner, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Close()
+	waiter, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiter.Close()
+
+	if r, err := owner.RequestName(name, NameFlagDoNotQueue); err != nil || r != RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName = %v, %v; want primary owner", r, err)
+	}
+	if !hasName(owner, name) {
+		t.Errorf("expected %s in %v", name, owner.Names())
+	}
+	if r, err := waiter.RequestName(name, 0); err != nil || r != RequestNameReplyInQueue {
+		t.Fatalf("RequestName = %v, %v; want in queue", r, err)
+	}
+	if hasName(waiter, name) {
+		t.Errorf("queued name should not be in %v", waiter.Names())
+	}
+
+	if r, err := owner.ReleaseName(name); err != nil || r != ReleaseNameReplyReleased {
+		t.Fatalf("ReleaseName = %v, %v; want released", r, err)
+	}
+	if hasName(owner, name) {
+		t.Errorf("released name should not be in %v", owner.Names())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasName(waiter, name) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s in %v after the owner released it", name, waiter.Names())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	names := waiter.Names()
+	for _, n := range names[1:] {
+		if n == names[0] {
+			t.Errorf("unique name listed twice in %v", names)
+		}
+	}
+}
+
+func TestNameChanges(t *testing.T) {
+	const name = "org.godbus.DBus.TestNameChanges"
+	conn, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ch := make(chan NameChange, 8)
+	conn.NameChanges(ch)
+
+	expect := func(want NameChange) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %+v", want)
+		}
+	}
+	if r, err := conn.RequestName(name, NameFlagDoNotQueue); err != nil || r != RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName = %v, %v; want primary owner", r, err)
+	}
+	expect(NameChange{Name: name, Acquired: true})
+	if r, err := conn.ReleaseName(name); err != nil || r != ReleaseNameReplyReleased {
+		t.Fatalf("ReleaseName = %v, %v; want released", r, err)
+	}
+	expect(NameChange{Name: name})
+
+	conn.Close()
+	for c := range ch {
+		t.Errorf("unexpected notification %+v", c)
+	}
+}
+
+func TestExportedPathsAndInterfaces(t *testing.T) {
+	conn, err := NewConn(rwc{strings.NewReader(""), io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if paths := conn.ExportedPaths(); len(paths) != 0 {
+		t.Errorf("expected no exported paths, got %v", paths)
+	}
+	for _, e := range []struct {
+		path  ObjectPath
+		iface string
+	}{
+		{"/org/godbus/b", "org.godbus.Foo"},
+		{"/org/godbus/a", "org.godbus.Foo"},
+		{"/org/godbus/a", "org.godbus.Bar"},
+		{"/", "org.godbus.Root"},
+	} {
+		if err := conn.Export(server{}, e.path, e.iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.ExportSubtree(server{}, "/org/godbus/tree", "org.godbus.Tree"); err != nil {
+		t.Fatal(err)
+	}
+
+	wantPaths := []ObjectPath{"/", "/org/godbus/a", "/org/godbus/b", "/org/godbus/tree"}
+	if got := conn.ExportedPaths(); !reflect.DeepEqual(got, wantPaths) {
+		t.Errorf("got paths %v, want %v", got, wantPaths)
+	}
+	if got, want := conn.ExportedInterfaces("/org/godbus/a"), []string{"org.godbus.Bar", "org.godbus.Foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got interfaces %v, want %v", got, want)
+	}
+	if got := conn.ExportedInterfaces("/org/godbus/tree/child"); got != nil {
+		t.Errorf("expected no interfaces below a subtree export, got %v", got)
+	}
+
+	if err := conn.Export(nil, "/org/godbus/a", "org.godbus.Bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Export(nil, "/org/godbus/b", "org.godbus.Foo"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conn.ExportedInterfaces("/org/godbus/a"), []string{"org.godbus.Foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got interfaces %v after unexporting, want %v", got, want)
+	}
+	wantPaths = []ObjectPath{"/", "/org/godbus/a", "/org/godbus/tree"}
+	if got := conn.ExportedPaths(); !reflect.DeepEqual(got, wantPaths) {
+		t.Errorf("got paths %v after unexporting, want %v", got, wantPaths)
+	}
+}
+
+func TestHandleCall_unknownErrors(t *testing.T) {
+	var sent []*Message
+	conn, err := NewConn(rwc{Reader: strings.NewReader(""), Writer: io.Discard},
+		WithOutgoingInterceptor(func(msg *Message) {
+			sent = append(sent, msg)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Export(barExport{}, "/org/guelfey/DBus/Test", "org.guelfey.DBus.Test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ExportSubtree(barExport{}, "/org/guelfey/DBus/Tree", "org.guelfey.DBus.Tree"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		path  ObjectPath
+		iface string
+		want  string
+	}{
+		{"unknown path", "/org/guelfey/Other", "org.guelfey.DBus.Test", "org.freedesktop.DBus.Error.UnknownObject"},
+		{"unknown path without interface", "/org/guelfey/Other", "", "org.freedesktop.DBus.Error.UnknownObject"},
+		{"below a plain export", "/org/guelfey/DBus/Test/Child", "org.guelfey.DBus.Test", "org.freedesktop.DBus.Error.UnknownObject"},
+		{"unknown interface", "/org/guelfey/DBus/Test", "org.guelfey.DBus.Other", "org.freedesktop.DBus.Error.UnknownInterface"},
+		{"parent node", "/org/guelfey/DBus", "org.guelfey.DBus.Test", "org.freedesktop.DBus.Error.UnknownInterface"},
+		{"below a subtree export", "/org/guelfey/DBus/Tree/Child", "org.guelfey.DBus.Other", "org.freedesktop.DBus.Error.UnknownInterface"},
+		{"unknown method", "/org/guelfey/DBus/Test", "org.guelfey.DBus.Test", "org.freedesktop.DBus.Error.UnknownMethod"},
+		{"unknown method without interface", "/org/guelfey/DBus/Test", "", "org.freedesktop.DBus.Error.UnknownMethod"},
+		{"unknown method below a subtree export", "/org/guelfey/DBus/Tree/Child", "org.guelfey.DBus.Tree", "org.freedesktop.DBus.Error.UnknownMethod"},
+	} {
+		headers := map[HeaderField]Variant{
+			FieldPath:   MakeVariant(tc.path),
+			FieldMember: MakeVariant("Missing"),
+		}
+		if tc.iface != "" {
+			headers[FieldInterface] = MakeVariant(tc.iface)
+		}
+		sent = nil
+		conn.handleCall(&Message{Type: TypeMethodCall, Headers: headers, serial: 1})
+		if len(sent) != 1 || sent[0].Type != TypeError {
+			t.Errorf("%s: expected one error reply, got %v", tc.name, sent)
+			continue
+		}
+		if name := sent[0].Headers[FieldErrorName].value; name != tc.want {
+			t.Errorf("%s: expected error %s, got %v", tc.name, tc.want, name)
+		}
+	}
+
+	// Introspection keeps working on every path.
+	sent = nil
+	conn.handleCall(&Message{Type: TypeMethodCall, Headers: map[HeaderField]Variant{
+		FieldPath:      MakeVariant(ObjectPath("/org/guelfey/Other")),
+		FieldInterface: MakeVariant("org.freedesktop.DBus.Introspectable"),
+		FieldMember:    MakeVariant("Introspect"),
+	}, serial: 1})
+	if len(sent) != 1 || sent[0].Type != TypeMethodReply {
+		t.Errorf("expected Introspect on an unknown path to succeed, got %v", sent)
+	}
+}
+
+func TestHandleCall_rootSubtree(t *testing.T) {
+	var sent []*Message
+	conn, err := NewConn(rwc{Reader: strings.NewReader(""), Writer: io.Discard},
+		WithOutgoingInterceptor(func(msg *Message) {
+			sent = append(sent, msg)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.ExportSubtree(barExport{}, "/", "org.guelfey.DBus.Tree"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   ObjectPath
+		iface  string
+		member string
+		want   string
+	}{
+		{"method", "/a/b", "org.guelfey.DBus.Tree", "Foo", ""},
+		{"method on a child", "/a", "org.guelfey.DBus.Tree", "Foo", ""},
+		{"unknown interface", "/a/b", "org.guelfey.DBus.Other", "Foo", "org.freedesktop.DBus.Error.UnknownInterface"},
+		{"unknown method", "/a/b", "org.guelfey.DBus.Tree", "Missing", "org.freedesktop.DBus.Error.UnknownMethod"},
+	} {
+		sent = nil
+		conn.handleCall(&Message{Type: TypeMethodCall, Headers: map[HeaderField]Variant{
+			FieldPath:      MakeVariant(tc.path),
+			FieldInterface: MakeVariant(tc.iface),
+			FieldMember:    MakeVariant(tc.member),
+			FieldSignature: MakeVariant(Signature{"s"}),
+		}, Body: []interface{}{"foo"}, serial: 1})
+		if len(sent) != 1 {
+			t.Errorf("%s: expected one reply, got %v", tc.name, sent)
+			continue
+		}
+		if tc.want == "" {
+			if sent[0].Type != TypeMethodReply {
+				t.Errorf("%s: expected a method reply, got %v", tc.name, sent[0])
+			}
+			continue
+		}
+		if name := sent[0].Headers[FieldErrorName].value; sent[0].Type != TypeError || name != tc.want {
+			t.Errorf("%s: expected error %s, got %v", tc.name, tc.want, sent[0])
+		}
+	}
+}
+
+func TestEmitWithOptions(t *testing.T) {
+	var out bytes.Buffer
+	conn, err := NewConn(rwc{Reader: strings.NewReader(""), Writer: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	order := binary.ByteOrder(binary.BigEndian)
+	if nativeEndian == binary.BigEndian {
+		order = binary.LittleEndian
+	}
+	err = conn.EmitWithOptions("/org/godbus/DBus/Emit", "org.godbus.DBus.Emit.Ping",
+		[]EmitOption{
+			WithEmitDestination(":1.42"),
+			WithEmitFlags(FlagNoAutoStart),
+			WithEmitByteOrder(order),
+		}, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := out.Bytes()
+	if want := map[binary.ByteOrder]byte{binary.BigEndian: 'B', binary.LittleEndian: 'l'}[order]; len(b) == 0 || b[0] != want {
+		t.Fatalf("expected the signal to be sent in byte order %v", order)
+	}
+	msg, err := DecodeMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != TypeSignal || msg.Flags != FlagNoAutoStart {
+		t.Errorf("got %v with flags %v", msg.Type, msg.Flags)
+	}
+	if dest := msg.Headers[FieldDestination].value; dest != ":1.42" {
+		t.Errorf("got destination %v, want :1.42", dest)
+	}
+	if len(msg.Body) != 1 || msg.Body[0] != "foo" {
+		t.Errorf("got body %v", msg.Body)
+	}
+}
+
+func TestEmitWithDestination(t *testing.T) {
+	emitter, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+	receiver, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	other, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// Neither connection adds a match rule: directed signals are delivered
+	// to their destination only.
+	received := make(chan *Signal, 10)
+	receiver.Signal(received)
+	overheard := make(chan *Signal, 10)
+	other.Signal(overheard)
+
+	err = emitter.EmitWithOptions("/org/godbus/DBus/Emit", "org.godbus.DBus.Emit.Directed",
+		[]EmitOption{WithEmitDestination(receiver.Names()[0])}, uint32(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case sig := <-received:
+			if sig.Name != "org.godbus.DBus.Emit.Directed" {
+				continue
+			}
+			if len(sig.Body) != 1 || sig.Body[0] != uint32(7) {
+				t.Errorf("got body %v", sig.Body)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the directed signal")
+		}
+		break
+	}
+	// the other connection gets its own signals only, e.g. NameAcquired
+	for len(overheard) > 0 {
+		if sig := <-overheard; sig.Name == "org.godbus.DBus.Emit.Directed" {
+			t.Error("directed signal was delivered to another connection")
+		}
+	}
+}
+
+func TestExportRawHandler(t *testing.T) {
+	export, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	caller, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.Close()
+
+	handler := func(msg *Message) *Message {
+		reply := new(Message)
+		reply.Headers = make(map[HeaderField]Variant)
+		switch msg.Headers[FieldMember].value {
+		case "Echo":
+			reply.Type = TypeMethodReply
+			reply.Body = msg.Body
+			reply.Headers[FieldSignature] = msg.Headers[FieldSignature]
+		case "Path":
+			reply.Type = TypeMethodReply
+			reply.Body = []interface{}{msg.Headers[FieldPath].value}
+			reply.Headers[FieldSignature] = MakeVariant(SignatureOf(ObjectPath("")))
+		default:
+			reply.Type = TypeError
+			reply.Headers[FieldErrorName] = MakeVariant("org.godbus.DBus.Raw.Error.Unknown")
+		}
+		return reply
+	}
+	if err := export.ExportRawHandler(handler, "/org/godbus/DBus/Raw", "org.godbus.DBus.Raw"); err != nil {
+		t.Fatal(err)
+	}
+	obj := caller.Object(export.Names()[0], "/org/godbus/DBus/Raw")
+
+	var s string
+	var n uint32
+	if err := obj.Call("org.godbus.DBus.Raw.Echo", 0, "foo", uint32(42)).Store(&s, &n); err != nil {
+		t.Fatal(err)
+	}
+	if s != "foo" || n != 42 {
+		t.Errorf("got %q, %d; want \"foo\", 42", s, n)
+	}
+	var path ObjectPath
+	if err := obj.Call("org.godbus.DBus.Raw.Path", 0).Store(&path); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/org/godbus/DBus/Raw" {
+		t.Errorf("got path %v", path)
+	}
+	err = obj.Call("org.godbus.DBus.Raw.Other", 0).Err
+	if dbusErr, ok := err.(Error); !ok || dbusErr.Name != "org.godbus.DBus.Raw.Error.Unknown" {
+		t.Errorf("expected the error returned by the handler, got %v", err)
+	}
+
+	if err := export.ExportRawHandler(nil, "/org/godbus/DBus/Raw", "org.godbus.DBus.Raw"); err != nil {
+		t.Fatal(err)
+	}
+	err = obj.Call("org.godbus.DBus.Raw.Echo", 0, "foo").Err
+	if dbusErr, ok := err.(Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownObject" {
+		t.Errorf("expected UnknownObject after removing the handler, got %v", err)
+	}
+}
+
+func TestListNames(t *testing.T) {
+	conn, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const name = "org.godbus.DBus.ListNames"
+	if _, err := conn.RequestName(name, NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	}
+	names, err := conn.ListNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"org.freedesktop.DBus", conn.Names()[0], name} {
+		if !containsString(names, want) {
+			t.Errorf("expected ListNames to include %s, got %v", want, names)
+		}
+	}
+
+	activatable, err := conn.ListActivatableNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsString(activatable, "org.freedesktop.DBus") {
+		t.Errorf("expected the bus to be activatable, got %v", activatable)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStartServiceByName(t *testing.T) {
+	conn, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.StartServiceByName("org.godbus.DBus.StartService.Missing", 0)
+	if dbusErr, ok := err.(Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.ServiceUnknown" {
+		t.Errorf("expected ServiceUnknown for a name without a service file, got %v", err)
+	}
+
+	activatable, err := conn.ListActivatableNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	for _, n := range activatable {
+		if n != "org.freedesktop.DBus" {
+			name = n
+			break
+		}
+	}
+	if name == "" {
+		t.Skip("no activatable service available on the session bus")
+	}
+	r, err := conn.StartServiceByName(name, 0)
+	if dbusErr, ok := err.(Error); ok && strings.HasPrefix(dbusErr.Name, "org.freedesktop.DBus.Error.Spawn.") {
+		t.Skipf("%s can't be started here: %v", name, err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != StartServiceReplySuccess && r != StartServiceReplyAlreadyRunning {
+		t.Errorf("got unexpected reply %v when starting %s", r, name)
+	}
+}
+
+func TestGetConnectionCredentials(t *testing.T) {
+	conn, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	creds, err := conn.GetConnectionCredentials(conn.Names()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.UnixUserID == nil || *creds.UnixUserID != uint32(os.Getuid()) {
+		t.Errorf("got UnixUserID %v, want %d", creds.UnixUserID, os.Getuid())
+	}
+	if creds.ProcessID == nil || *creds.ProcessID != uint32(os.Getpid()) {
+		t.Errorf("got ProcessID %v, want %d", creds.ProcessID, os.Getpid())
+	}
+	if _, ok := creds.Raw["UnixUserID"]; !ok {
+		t.Errorf("expected UnixUserID in the raw credentials, got %v", creds.Raw)
+	}
+
+	_, err = conn.GetConnectionCredentials("org.godbus.DBus.Credentials.Missing")
+	if dbusErr, ok := err.(Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.NameHasNoOwner" {
+		t.Errorf("expected NameHasNoOwner for a name without owner, got %v", err)
+	}
+}
+
+func TestHandleCallSameMethodOnTwoInterfaces(t *testing.T) {
+	export, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	caller, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.Close()
+
+	const path = "/org/godbus/DBus/Same"
+	if err := export.Export(&fooExport{}, path, "org.godbus.DBus.Foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := export.Export(barExport{}, path, "org.godbus.DBus.Bar"); err != nil {
+		t.Fatal(err)
+	}
+	only := map[string]interface{}{
+		"Only": func() (string, *Error) { return "only", nil },
+	}
+	if err := export.ExportMethodTable(only, path, "org.godbus.DBus.Only"); err != nil {
+		t.Fatal(err)
+	}
+	obj := caller.Object(export.Names()[0], path)
+
+	for i := 0; i < 10; i++ {
+		for method, want := range map[string]string{
+			"org.godbus.DBus.Foo.Foo": "foo",
+			"org.godbus.DBus.Bar.Foo": "bar",
+			// without an interface, the first one by name is used
+			"Foo": "bar",
+		} {
+			var got string
+			if err := obj.Call(method, 0, "").Store(&got); err != nil {
+				t.Fatalf("%s: %v", method, err)
+			}
+			if got != want {
+				t.Fatalf("%s returned %q, want %q", method, got, want)
+			}
+		}
+	}
+
+	err = obj.Call("org.godbus.DBus.Foo.Only", 0).Err
+	if dbusErr, ok := err.(Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Errorf("expected UnknownMethod for a method of another interface, got %v", err)
+	}
+}
+
+func TestEmitInterfacesAddedAndRemoved(t *testing.T) {
+	emitter, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+	receiver, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	if err := receiver.AddMatchSignal(
+		WithMatchSender(emitter.Names()[0]),
+		WithMatchInterface("org.freedesktop.DBus.ObjectManager"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *Signal, 10)
+	receiver.Signal(signals)
+	next := func() *Signal {
+		select {
+		case sig := <-signals:
+			return sig
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a signal")
+			return nil
+		}
+	}
+
+	const manager, path = ObjectPath("/org/godbus/DBus/Manager"), ObjectPath("/org/godbus/DBus/Manager/Child")
+	added := map[string]map[string]Variant{
+		"org.godbus.DBus.Child": {"Name": MakeVariant("child"), "Size": MakeVariant(uint32(3))},
+		"org.godbus.DBus.Empty": {},
+	}
+	if err := emitter.EmitInterfacesAdded(manager, path, added); err != nil {
+		t.Fatal(err)
+	}
+	sig := next()
+	if sig.Path != manager || sig.Name != "org.freedesktop.DBus.ObjectManager.InterfacesAdded" {
+		t.Fatalf("got signal %s from %s", sig.Name, sig.Path)
+	}
+	var gotPath ObjectPath
+	var gotAdded map[string]map[string]Variant
+	if err := Store(sig.Body, &gotPath, &gotAdded); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != path || !reflect.DeepEqual(gotAdded, added) {
+		t.Errorf("got %v %v, want %v %v", gotPath, gotAdded, path, added)
+	}
+
+	if err := emitter.EmitInterfacesRemoved(manager, path, []string{"org.godbus.DBus.Child", "org.godbus.DBus.Empty"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := emitter.EmitInterfacesRemoved(manager, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range [][]string{{"org.godbus.DBus.Child", "org.godbus.DBus.Empty"}, {}} {
+		sig = next()
+		if sig.Name != "org.freedesktop.DBus.ObjectManager.InterfacesRemoved" {
+			t.Fatalf("got signal %s", sig.Name)
+		}
+		if s := sig.Headers[FieldSignature].value.(Signature).String(); s != "oas" {
+			t.Errorf("got signature %s, want oas", s)
+		}
+		var gotRemoved []string
+		if err := Store(sig.Body, &gotPath, &gotRemoved); err != nil {
+			t.Fatal(err)
+		}
+		if gotPath != path || len(gotRemoved) != len(want) || (len(want) > 0 && !reflect.DeepEqual(gotRemoved, want)) {
+			t.Errorf("got %v %v, want %v %v", gotPath, gotRemoved, path, want)
+		}
+	}
+}
+
+func TestMakeFailedAndNamedError(t *testing.T) {
+	export, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	caller, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.Close()
+
+	methods := map[string]interface{}{
+		"Failed": func() (string, *Error) {
+			return "", MakeFailedError(errors.New("something failed"))
+		},
+		"Named": func() (string, *Error) {
+			return "", MakeNamedError("org.godbus.DBus.Errors.Error.Busy", fmt.Errorf("busy with %d calls", 3))
+		},
+		"InvalidName": func() (string, *Error) {
+			return "", MakeNamedError("Busy", errors.New("busy"))
+		},
+	}
+	if err := export.ExportMethodTable(methods, "/org/godbus/DBus/Errors", "org.godbus.DBus.Errors"); err != nil {
+		t.Fatal(err)
+	}
+	obj := caller.Object(export.Names()[0], "/org/godbus/DBus/Errors")
+	for method, want := range map[string]Error{
+		"Failed":      {ErrNameFailed, []interface{}{"something failed"}},
+		"Named":       {"org.godbus.DBus.Errors.Error.Busy", []interface{}{"busy with 3 calls"}},
+		"InvalidName": {ErrNameFailed, []interface{}{"busy"}},
+	} {
+		err := obj.Call("org.godbus.DBus.Errors."+method, 0).Err
+		dbusErr, ok := err.(Error)
+		if !ok {
+			t.Fatalf("%s: expected an Error, got %T: %v", method, err, err)
+		}
+		if dbusErr.Name != want.Name || dbusErr.Error() != want.Error() {
+			t.Errorf("%s: got %s: %s, want %s: %s", method, dbusErr.Name, dbusErr.Error(), want.Name, want.Error())
+		}
+	}
+}
+
+type propsExport struct {
+	Name   string `dbus:",property"`
+	Volume int32  `dbus:"Level,property,readwrite"`
+	Other  int32
+}
+
+func (p *propsExport) Ping() *Error {
+	return nil
+}
+
+func TestExportStructProperties(t *testing.T) {
+	export, err := ConnectSessionBus(WithAutoIntrospection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	cli, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	const path, iface = ObjectPath("/org/godbus/DBus/Props"), "org.godbus.DBus.Props"
+	v := &propsExport{Name: "speaker", Volume: 3}
+	if err := export.Export(v, path, iface); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.AddMatchSignal(WithMatchSender(export.Names()[0]), WithMatchInterface("org.freedesktop.DBus.Properties")); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *Signal, 10)
+	cli.Signal(signals)
+	nextChange := func() map[string]Variant {
+		select {
+		case sig := <-signals:
+			var gotIface string
+			var changed map[string]Variant
+			var invalidated []string
+			if err := Store(sig.Body, &gotIface, &changed, &invalidated); err != nil {
+				t.Fatal(err)
+			}
+			if gotIface != iface {
+				t.Errorf("got PropertiesChanged for %s, want %s", gotIface, iface)
+			}
+			return changed
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for PropertiesChanged")
+			return nil
+		}
+	}
+
+	obj := cli.Object(export.Names()[0], path).(*Object)
+	name, err := obj.GetProperty(iface + ".Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name.Value() != "speaker" {
+		t.Errorf("got Name %v, want speaker", name)
+	}
+	// the tags that export the fields also name them for StorePropertiesInto
+	var all propsExport
+	if err := obj.StorePropertiesInto(iface, &all); err != nil {
+		t.Fatal(err)
+	}
+	if all.Name != "speaker" || all.Volume != 3 {
+		t.Errorf("got properties %+v", all)
+	}
+	if _, err := obj.GetProperty(iface + ".Other"); !errors.Is(err, ErrPropNotFound) {
+		t.Errorf("got %v getting an untagged field, want %v", err, ErrPropNotFound)
+	}
+	if _, err := obj.GetProperty("org.godbus.DBus.Missing.Name"); !errors.Is(err, ErrIfaceNotFound) {
+		t.Errorf("got %v getting a property of another interface, want %v", err, ErrIfaceNotFound)
+	}
+	if err := obj.SetProperty(iface+".Name", "other"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("got %v setting a read-only property, want %v", err, ErrReadOnly)
+	}
+
+	if err := obj.SetProperty(iface+".Level", int32(7)); err != nil {
+		t.Fatal(err)
+	}
+	if changed := nextChange(); len(changed) != 1 || changed["Level"].Value() != int32(7) {
+		t.Errorf("got changes %v, want Level 7", changed)
+	}
+	if err := obj.StorePropertiesInto(iface, &all); err != nil {
+		t.Fatal(err)
+	}
+	if all.Volume != 7 {
+		t.Errorf("got Volume %d after setting it, want 7", all.Volume)
+	}
+
+	if err := export.UpdateProperties(path, iface, func() { v.Name = "headphones" }, "Name"); err != nil {
+		t.Fatal(err)
+	}
+	if changed := nextChange(); len(changed) != 1 || changed["Name"].Value() != "headphones" {
+		t.Errorf("got changes %v, want Name headphones", changed)
+	}
+	if err := export.EmitPropertiesChanged(path, iface, "Level"); err != nil {
+		t.Fatal(err)
+	}
+	if changed := nextChange(); len(changed) != 1 || changed["Level"].Value() != int32(7) {
+		t.Errorf("got changes %v, want Level 7", changed)
+	}
+
+	var xml string
+	if err := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<property name="Name" type="s" access="read"/>`,
+		`<property name="Level" type="i" access="readwrite"/>`,
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("introspection data lacks %s:\n%s", want, xml)
+		}
+	}
+
+	if err := export.Export(nil, path, iface); err != nil {
+		t.Fatal(err)
+	}
+	if paths := export.ExportedPaths(); len(paths) != 0 {
+		t.Errorf("got exported paths %v after removing the struct", paths)
+	}
+}
+
+func TestExportStructPropertiesInvalid(t *testing.T) {
+	export, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	for _, v := range []interface{}{
+		&struct {
+			Fn func() `dbus:",property"`
+		}{},
+		&struct {
+			A int32 `dbus:",readwrite"`
+		}{},
+		&struct {
+			A int32 `dbus:",property,writable"`
+		}{},
+		&struct {
+			A int32 `dbus:"X,property"`
+			B int32 `dbus:"X,property"`
+		}{},
+	} {
+		if err := export.Export(v, "/org/godbus/DBus/Props", "org.godbus.DBus.Props"); err == nil {
+			t.Errorf("expected an error exporting %T", v)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package dbus
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// NewGUID returns a new globally unique ID as described in the D-Bus
+// specification: 128 bits encoded as 32 lowercase hex characters, of which the
+// first 96 bits are random and the last 32 bits are the current Unix time
+// (big endian). It is suitable as a server GUID in the auth OK line.
+func NewGUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:12]); err != nil {
+		panic("dbus: failed to read random bytes: " + err.Error())
+	}
+	binary.BigEndian.PutUint32(b[12:], uint32(time.Now().Unix()))
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,23 @@
+package dbus
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewGUID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		guid := NewGUID()
+		if len(guid) != 32 {
+			t.Fatalf("expected 32 characters, got %q", guid)
+		}
+		if _, err := hex.DecodeString(guid); err != nil {
+			t.Fatalf("expected a hex string, got %q: %v", guid, err)
+		}
+		if seen[guid] {
+			t.Fatalf("got duplicate GUID %q", guid)
+		}
+		seen[guid] = true
+	}
+}
@@ -2,6 +2,8 @@ package introspect
 
 import (
 	"encoding/xml"
+	"io"
+	"os"
 	"reflect"
 	"strings"
 
@@ -83,11 +85,21 @@ func Methods(v interface{}) []Method {
 			}
 		}
 		for j := 0; j < mt.NumOut()-1; j++ {
-			arg := Arg{"", dbus.SignatureOfType(mt.Out(j)).String(), "out"}
-			m.Args = append(m.Args, arg)
+			sig := "h" // files and readers are sent as file descriptors
+			if !isStreamType(mt.Out(j)) {
+				sig = dbus.SignatureOfType(mt.Out(j)).String()
+			}
+			m.Args = append(m.Args, Arg{"", sig, "out"})
 		}
 		m.Annotations = make([]Annotation, 0)
 		ms = append(ms, m)
 	}
 	return ms
 }
+
+// isStreamType returns whether values of type t are sent as a file descriptor
+// when they are returned by an exported method.
+func isStreamType(t reflect.Type) bool {
+	return t == reflect.TypeOf((*os.File)(nil)) ||
+		t.Kind() == reflect.Interface && t.Implements(reflect.TypeOf((*io.Reader)(nil)).Elem())
+}
@@ -0,0 +1,134 @@
+package dbus
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+)
+
+// introspectedObj adds the Introspectable interface of the default handler to
+// an exported object that doesn't export it itself.
+type introspectedObj struct {
+	*exportedObj
+	introspect *exportedIntf
+}
+
+func (obj *introspectedObj) LookupInterface(name string) (Interface, bool) {
+	switch name {
+	case "":
+		return obj, true
+	case "org.freedesktop.DBus.Introspectable":
+		return obj.introspect, true
+	}
+	return obj.exportedObj.LookupInterface(name)
+}
+
+func (obj *introspectedObj) LookupMethod(name string) (Method, bool) {
+	if m, ok := obj.exportedObj.LookupMethod(name); ok {
+		return m, ok
+	}
+	return obj.introspect.LookupMethod(name)
+}
+
+// introspectInterfaces writes the introspection data of the interfaces
+// exported at path to xml. h must be locked.
+func (h *defaultHandler) introspectInterfaces(xml *bytes.Buffer, path ObjectPath) {
+	obj, ok := h.objects[path]
+	if !ok {
+		return
+	}
+	obj.mu.RLock()
+	defer obj.mu.RUnlock()
+	names := make([]string, 0, len(obj.interfaces)+2)
+	for name := range obj.interfaces {
+		names = append(names, name)
+	}
+	for _, name := range []string{"org.freedesktop.DBus.Introspectable", "org.freedesktop.DBus.Peer"} {
+		if _, ok := obj.interfaces[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	props := obj.interfaces["org.freedesktop.DBus.Properties"]
+	for _, name := range names {
+		xml.WriteString("\n\t<interface name=\"" + name + "\">")
+		switch intf := obj.interfaces[name]; {
+		case name == "org.freedesktop.DBus.Introspectable" && intf == nil:
+			xml.WriteString("\n\t\t<method name=\"Introspect\">\n\t\t\t<arg name=\"out\" type=\"s\" direction=\"out\"/>\n\t\t</method>")
+		case name == "org.freedesktop.DBus.Peer" && intf == nil:
+			xml.WriteString("\n\t\t<method name=\"Ping\"/>")
+			xml.WriteString("\n\t\t<method name=\"GetMachineId\">\n\t\t\t<arg name=\"machine_uuid\" type=\"s\" direction=\"out\"/>\n\t\t</method>")
+		default:
+			introspectMethods(xml, intf)
+			if props != nil {
+				introspectProperties(xml, props, name)
+			}
+		}
+		xml.WriteString("\n\t</interface>")
+	}
+}
+
+// introspectMethods writes the introspection data of the methods of intf to
+// xml. Methods whose arguments have no D-Bus representation are left out.
+func introspectMethods(xml *bytes.Buffer, intf *exportedIntf) {
+	names := make([]string, 0, len(intf.methods))
+	for name := range intf.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := intf.methods[name]
+		var args []string
+		ok := true
+		for i := 0; i < m.NumArguments() && ok; i++ {
+			v := m.ArgumentValue(i)
+			switch reflect.TypeOf(v) {
+			case reflect.TypeOf(Sender("")), reflect.TypeOf(Message{}):
+				continue
+			}
+			var sig string
+			sig, ok = signatureOfValue(v)
+			args = append(args, "<arg type=\""+sig+"\" direction=\"in\"/>")
+		}
+		for i := 0; i < m.NumReturns() && ok; i++ {
+			if em, isExported := m.(exportedMethod); isExported && isStreamType(em.Type().Out(i)) {
+				args = append(args, "<arg type=\"h\" direction=\"out\"/>")
+				continue
+			}
+			v := m.ReturnValue(i)
+			if t := reflect.TypeOf(v); t == nil || t.Implements(errType) || i == m.NumReturns()-1 && reflect.TypeOf((*Error)(nil)) == t {
+				continue
+			}
+			var sig string
+			sig, ok = signatureOfValue(v)
+			args = append(args, "<arg type=\""+sig+"\" direction=\"out\"/>")
+		}
+		if !ok {
+			continue
+		}
+		if len(args) == 0 {
+			xml.WriteString("\n\t\t<method name=\"" + name + "\"/>")
+			continue
+		}
+		xml.WriteString("\n\t\t<method name=\"" + name + "\">")
+		for _, arg := range args {
+			xml.WriteString("\n\t\t\t" + arg)
+		}
+		xml.WriteString("\n\t\t</method>")
+	}
+}
+
+// introspectProperties writes the properties of the interface iface to xml,
+// if props, the exported org.freedesktop.DBus.Properties interface, implements
+// PropertyIntrospector.
+func introspectProperties(xml *bytes.Buffer, props *exportedIntf, iface string) {
+	pi, ok := props.value.(PropertyIntrospector)
+	if !ok {
+		return
+	}
+	ps := pi.IntrospectProperties(iface)
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name < ps[j].Name })
+	for _, p := range ps {
+		xml.WriteString("\n\t\t<property name=\"" + p.Name + "\" type=\"" + p.Type + "\" access=\"" + p.Access + "\"/>")
+	}
+}
@@ -1,6 +1,7 @@
 package dbus
 
 import (
+	"context"
 	"strconv"
 	"strings"
 )
@@ -21,6 +22,29 @@ func formatMatchOptions(options []MatchOption) string {
 	return strings.Join(items, ",")
 }
 
+// MatchRule is a match rule registered with AddMatchSignalRule.
+type MatchRule struct {
+	conn *Conn
+	rule string
+}
+
+// String returns the rule as sent to the bus, e.g.
+// "type='signal',interface='org.freedesktop.DBus'".
+func (r *MatchRule) String() string {
+	return r.rule
+}
+
+// Remove removes the rule from the bus, like RemoveMatchSignal called with the
+// options the rule was added with.
+func (r *MatchRule) Remove() error {
+	return r.conn.closedError(r.RemoveContext(r.conn.ctx))
+}
+
+// RemoveContext acts like Remove but takes a context.
+func (r *MatchRule) RemoveContext(ctx context.Context) error {
+	return r.conn.removeMatchRule(ctx, r.rule)
+}
+
 // WithMatchOption creates match option with given key and value
 func WithMatchOption(key, value string) MatchOption {
 	return MatchOption{key, value}
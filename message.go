@@ -1,12 +1,17 @@
 package dbus
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"os"
 	"reflect"
 	"strconv"
+	"sync"
 )
 
 const protoVersion byte = 1
@@ -111,6 +116,26 @@ type Message struct {
 	Body    []interface{}
 
 	serial uint32
+
+	// order is the byte order to send the message in; nil means the native
+	// byte order.
+	order binary.ByteOrder
+
+	// The undecoded body of a received message whose decoding has not
+	// happened yet (see decodeBody). rawOrder is nil once the body is decoded.
+	rawBody  []byte
+	rawOrder binary.ByteOrder
+	rawFDs   []int
+
+	// wireSize is the size a received message had on the wire, or 0.
+	wireSize int
+
+	// If bodySized is set, bodyLen, bodyFDs and bodyErr hold the result of
+	// bodySize, which is cached while a connection sends the message.
+	bodySized bool
+	bodyLen   int
+	bodyFDs   int
+	bodyErr   error
 }
 
 type header struct {
@@ -119,6 +144,27 @@ type header struct {
 }
 
 func DecodeMessageWithFDs(rd io.Reader, fds []int) (msg *Message, err error) {
+	msg, err = readMessage(rd, fds)
+	if err != nil {
+		return nil, err
+	}
+	if err = msg.decodeReadBody(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// decodeReadBody decodes the body of a message that was read with its body
+// left undecoded, using a pooled decoder with the default limits.
+func (msg *Message) decodeReadBody() error {
+	dec := getDecoder(nil, nativeEndian, nil)
+	defer putDecoder(dec)
+	return msg.decodeBody(dec)
+}
+
+// readMessage reads a single message from rd and validates its header, but
+// leaves the body undecoded.
+func readMessage(rd io.Reader, fds []int) (msg *Message, err error) {
 	var order binary.ByteOrder
 	var hlength, length uint32
 	var typ, flags, proto byte
@@ -138,7 +184,8 @@ func DecodeMessageWithFDs(rd io.Reader, fds []int) (msg *Message, err error) {
 		return nil, InvalidMessageError("invalid byte order")
 	}
 
-	dec := newDecoder(rd, order, fds)
+	dec := getDecoder(rd, order, fds)
+	defer putDecoder(dec)
 	dec.pos = 1
 
 	msg = new(Message)
@@ -164,7 +211,14 @@ func DecodeMessageWithFDs(rd io.Reader, fds []int) (msg *Message, err error) {
 	if hlength+length+16 > 1<<27 {
 		return nil, InvalidMessageError("message is too long")
 	}
-	dec = newDecoder(io.MultiReader(bytes.NewBuffer(b), rd), order, fds)
+	if err := checkProtoVersion(proto); err != nil {
+		// skip the rest of the message, so that the next one can be read
+		if _, err := io.CopyN(io.Discard, rd, int64(align8(hlength)+length)); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	dec.Reset(io.MultiReader(bytes.NewBuffer(b), rd), order, fds)
 	dec.pos = 12
 	vs, err = dec.Decode(Signature{"a(yv)"})
 	if err != nil {
@@ -191,18 +245,406 @@ func DecodeMessageWithFDs(rd io.Reader, fds []int) (msg *Message, err error) {
 	if err = msg.validateHeader(); err != nil {
 		return nil, err
 	}
+	msg.wireSize = 16 + int(align8(hlength)+length)
+	msg.rawBody, msg.rawOrder, msg.rawFDs = body, order, fds
+	return msg, nil
+}
+
+// checkProtoVersion returns an error if messages of the protocol version v,
+// as given in their header, can't be decoded. Only version 1 has been
+// specified so far; support for others is to be added here.
+func checkProtoVersion(v byte) error {
+	if v != protoVersion {
+		return InvalidMessageError("unsupported protocol version")
+	}
+	return nil
+}
+
+// align8 rounds n up to a multiple of 8.
+func align8(n uint32) uint32 {
+	return (n + 7) &^ 7
+}
+
+// decodeBodyInto is a fast path for decodeBody: if the undecoded body is a
+// single array of structs with only fixed-size fields and retvalues is a single
+// pointer to a slice of matching Go structs, the body is decoded directly into
+// that slice and msg.Body is set to the slice. It reports whether it did so.
+func (msg *Message) decodeBodyInto(dec *decoder, retvalues []interface{}) (bool, error) {
+	if msg.rawOrder == nil || len(retvalues) != 1 {
+		return false, nil
+	}
 	sig, _ := msg.Headers[FieldSignature].value.(Signature)
-	if sig.str != "" {
-		buf := bytes.NewBuffer(body)
-		dec = newDecoder(buf, order, fds)
-		vs, err := dec.Decode(sig)
+	if len(sig.str) < 4 || sig.str[0] != 'a' {
+		return false, nil
+	}
+	if err, rem := validSingle(sig.str, &depthCounter{}); err != nil || rem != "" {
+		return false, nil
+	}
+	dst := reflect.ValueOf(retvalues[0])
+	if dst.Kind() != reflect.Ptr || dst.IsNil() || dst.Elem().Kind() != reflect.Slice {
+		return false, nil
+	}
+	dst = dst.Elem()
+	fields := fixedStructFields(sig.str[1:], dst.Type().Elem())
+	if fields == nil {
+		return false, nil
+	}
+	body, order := msg.rawBody, msg.rawOrder
+	msg.rawBody, msg.rawOrder, msg.rawFDs = nil, nil, nil
+
+	dec.Reset(bytes.NewReader(body), order, nil)
+	dec.elements = 0
+	if err := dec.DecodeFixedStructs(sig.str[1:], fields, dst); err != nil {
+		return true, bodyError(sig, err)
+	}
+	msg.Body = []interface{}{dst.Interface()}
+	return true, nil
+}
+
+// decodeBodyToChan is the streaming counterpart of decodeBodyInto: if the
+// undecoded body is a single array that is not a dict and retvalues is a single
+// channel, the elements are sent to the channel as they are decoded, as
+// described for Store, and msg.Body stays nil. It reports whether it did so.
+func (msg *Message) decodeBodyToChan(dec *decoder, retvalues []interface{}) (bool, error) {
+	if msg.rawOrder == nil || len(retvalues) != 1 {
+		return false, nil
+	}
+	sig, _ := msg.Headers[FieldSignature].value.(Signature)
+	if len(sig.str) < 2 || sig.str[0] != 'a' || sig.str[1] == '{' {
+		return false, nil
+	}
+	if err, rem := validSingle(sig.str, &depthCounter{}); err != nil || rem != "" {
+		return false, nil
+	}
+	dst := reflect.ValueOf(retvalues[0])
+	if dst.Kind() != reflect.Chan {
+		return false, nil
+	}
+	if dst.IsNil() || dst.Type().ChanDir()&reflect.SendDir == 0 {
+		return true, fmt.Errorf("dbus.Store: cannot send to %s", dst.Type())
+	}
+	defer dst.Close()
+	body, order, fds := msg.rawBody, msg.rawOrder, msg.rawFDs
+	msg.rawBody, msg.rawOrder, msg.rawFDs = nil, nil, nil
+
+	dec.Reset(bytes.NewReader(body), order, fds)
+	dec.elements = 0
+	// The fds of the elements sent so far belong to the receiver; on error,
+	// only the others are closed.
+	delivered := make(map[int]bool)
+	err := dec.DecodeElements(sig.str, func(v interface{}) error {
+		if err := sendElem(dst, reflect.ValueOf(v)); err != nil {
+			return err
+		}
+		for _, fd := range dec.usedFDs {
+			delivered[fd] = true
+		}
+		dec.usedFDs = dec.usedFDs[:0]
+		return nil
+	})
+	if err != nil {
+		for _, fd := range fds {
+			if !delivered[fd] {
+				closeFDs([]int{fd})
+			}
+		}
+		return true, bodyError(sig, err)
+	}
+	return true, nil
+}
+
+// sendOrder returns the byte order the message is to be sent in. An undecoded
+// body is forwarded in its original byte order unless another one was set.
+func (msg *Message) sendOrder() binary.ByteOrder {
+	if msg.order != nil {
+		return msg.order
+	}
+	if msg.rawOrder != nil {
+		return msg.rawOrder
+	}
+	return nativeEndian
+}
+
+// jsonTypeNames and jsonFieldNames are the names used for message types and
+// header fields by MarshalJSON, following the specification.
+var (
+	jsonTypeNames = map[Type]string{
+		TypeMethodCall:  "method_call",
+		TypeMethodReply: "method_return",
+		TypeError:       "error",
+		TypeSignal:      "signal",
+	}
+	jsonFieldNames = map[HeaderField]string{
+		FieldPath:        "path",
+		FieldInterface:   "interface",
+		FieldMember:      "member",
+		FieldErrorName:   "error_name",
+		FieldReplySerial: "reply_serial",
+		FieldDestination: "destination",
+		FieldSender:      "sender",
+		FieldSignature:   "signature",
+		FieldUnixFDs:     "unix_fds",
+	}
+)
+
+// MarshalJSON encodes msg as a JSON object for structured logging. It holds
+// the message type (e.g. "method_call"), flags, serial, the header fields keyed
+// by their lower-case names from the specification and the body, all values of
+// which are encoded like Variant.MarshalJSON.
+func (msg *Message) MarshalJSON() ([]byte, error) {
+	typ, ok := jsonTypeNames[msg.Type]
+	if !ok {
+		typ = "invalid"
+	}
+	headers := make(map[string]Variant, len(msg.Headers))
+	for field, v := range msg.Headers {
+		name, ok := jsonFieldNames[field]
+		if !ok {
+			name = strconv.Itoa(int(field))
+		}
+		headers[name] = v
+	}
+	body, err := msg.bodyVariants()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Type    string             `json:"type"`
+		Flags   Flags              `json:"flags"`
+		Serial  uint32             `json:"serial"`
+		Headers map[string]Variant `json:"headers"`
+		Body    []Variant          `json:"body"`
+	}{typ, msg.Flags, msg.serial, headers, body})
+}
+
+// bodyVariants returns the values of the body as variants, using the types
+// from the signature header field if there is one. Decoded structs are
+// []interface{} values, so their signature can't be derived from the value.
+func (msg *Message) bodyVariants() (vs []Variant, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("dbus: cannot encode message body: %v", v)
+		}
+	}()
+	sig, _ := msg.Headers[FieldSignature].value.(Signature)
+	s := sig.str
+	vs = make([]Variant, len(msg.Body))
+	for i, v := range msg.Body {
+		var t string
+		if s != "" {
+			var rem string
+			if err, rem = validSingle(s, &depthCounter{}); err != nil {
+				return nil, err
+			}
+			t, s = s[:len(s)-len(rem)], rem
+		}
+		if t != "" {
+			vs[i] = Variant{Signature{t}, v}
+		} else {
+			vs[i] = MakeVariant(v)
+		}
+	}
+	return vs, nil
+}
+
+// bodyError converts an error from decoding a message body with the signature
+// sig. As the body has been read completely, running out of input means that
+// the body doesn't match the signature, which makes the message invalid, and
+// so does a body exceeding the decode limits; neither affects the messages
+// after it.
+func bodyError(sig Signature, err error) error {
+	switch err {
+	case errUnexpectedEOF:
+		return InvalidMessageError(fmt.Sprintf("body is too short for signature %q", sig.str))
+	case errDepthLimit, errElementLimit:
+		return InvalidMessageError(string(err.(FormatError)))
+	}
+	return err
+}
+
+// closeFDs closes the given received file descriptors.
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		os.NewFile(uintptr(fd), "").Close()
+	}
+}
+
+// decodeBody decodes the raw body of a received message using dec. It is a
+// no-op if the body has already been decoded.
+func (msg *Message) decodeBody(dec *decoder) error {
+	if msg.rawOrder == nil {
+		return nil
+	}
+	body, order, fds := msg.rawBody, msg.rawOrder, msg.rawFDs
+	msg.rawBody, msg.rawOrder, msg.rawFDs = nil, nil, nil
+
+	sig, _ := msg.Headers[FieldSignature].value.(Signature)
+	if sig.str == "" {
+		return nil
+	}
+	dec.Reset(bytes.NewReader(body), order, fds)
+	vs, err := dec.Decode(sig)
+	if err != nil {
+		// nobody gets to see the received fds, so don't leak them
+		closeFDs(fds)
+		return bodyError(sig, err)
+	}
+	msg.Body = vs
+	if len(fds) == 0 {
+		return nil
+	}
+	// substitute the values in the message body (which are indices for the
+	// array receiver via OOB) with the actual values
+	if err := ResolveFDs(msg, fds); err != nil {
+		closeFDs(fds)
+		return err
+	}
+	return nil
+}
+
+// ResolveFDs replaces the UnixFDIndex values in the body of msg, at any depth,
+// by the corresponding file descriptors of fds as UnixFD values, like the
+// unix transport does for the descriptors received with a message. This is
+// useful for messages decoded with DecodeMessage, which leaves the indices
+// unresolved, e.g. []UnixFDIndex becomes []UnixFD. It returns an
+// InvalidMessageError if an index exceeds fds or the number of descriptors
+// announced in the header of msg, in which case the body may have been
+// resolved partially.
+func ResolveFDs(msg *Message, fds []int) error {
+	n := len(fds)
+	if unixfds, ok := msg.Headers[FieldUnixFDs].value.(uint32); !ok {
+		n = 0
+	} else if int(unixfds) < n {
+		n = int(unixfds)
+	}
+	r := fdResolver{fds[:n]}
+	for i, v := range msg.Body {
+		if v == nil {
+			continue
+		}
+		rv, err := r.resolve(reflect.ValueOf(v))
 		if err != nil {
-			return nil, err
+			return err
+		}
+		msg.Body[i] = rv.Interface()
+	}
+	return nil
+}
+
+// fdResolver implements ResolveFDs.
+type fdResolver struct {
+	fds []int
+}
+
+// resolve returns v with the UnixFDIndex values it contains replaced. Slices
+// are modified in place unless their type changes, while maps are copied.
+func (r fdResolver) resolve(v reflect.Value) (reflect.Value, error) {
+	switch v.Type() {
+	case unixFDIndexType:
+		idx := v.Uint()
+		if idx >= uint64(len(r.fds)) {
+			return v, InvalidMessageError("invalid index for unix fd")
+		}
+		return reflect.ValueOf(UnixFD(r.fds[idx])), nil
+	case variantType:
+		variant := v.Interface().(Variant)
+		if variant.value == nil {
+			return v, nil
+		}
+		value, err := r.resolve(reflect.ValueOf(variant.value))
+		if err != nil {
+			return v, err
 		}
-		msg.Body = vs
+		return reflect.ValueOf(Variant{sig: variant.sig, value: value.Interface()}), nil
+	case orderedMapType:
+		entries := v.Interface().(OrderedMap).Entries
+		for i := range entries {
+			for _, x := range []*interface{}{&entries[i].Key, &entries[i].Value} {
+				if *x == nil {
+					continue
+				}
+				e, err := r.resolve(reflect.ValueOf(*x))
+				if err != nil {
+					return v, err
+				}
+				*x = e.Interface()
+			}
+		}
+		return v, nil
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, nil
+		}
+		return r.resolve(v.Elem())
+	case reflect.Slice:
+		if !mayHoldFDs(v.Type().Elem()) {
+			return v, nil
+		}
+		out := v
+		if t := resolvedFDType(v.Type()); t != v.Type() {
+			out = reflect.MakeSlice(t, v.Len(), v.Len())
+		}
+		for i := 0; i < v.Len(); i++ {
+			e, err := r.resolve(v.Index(i))
+			if err != nil {
+				return v, err
+			}
+			out.Index(i).Set(e)
+		}
+		return out, nil
+	case reflect.Map:
+		if !mayHoldFDs(v.Type().Key()) && !mayHoldFDs(v.Type().Elem()) {
+			return v, nil
+		}
+		t := resolvedFDType(v.Type())
+		out := reflect.MakeMapWithSize(t, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k, err := r.resolve(iter.Key())
+			if err != nil {
+				return v, err
+			}
+			e, err := r.resolve(iter.Value())
+			if err != nil {
+				return v, err
+			}
+			out.SetMapIndex(k, e)
+		}
+		return out, nil
 	}
+	return v, nil
+}
+
+// mayHoldFDs returns whether values of type t can contain UnixFDIndex values.
+func mayHoldFDs(t reflect.Type) bool {
+	switch t {
+	case unixFDIndexType, variantType, orderedMapType:
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Slice:
+		return mayHoldFDs(t.Elem())
+	case reflect.Map:
+		return mayHoldFDs(t.Key()) || mayHoldFDs(t.Elem())
+	}
+	return false
+}
 
-	return
+// resolvedFDType returns t with UnixFDIndex replaced by UnixFD.
+func resolvedFDType(t reflect.Type) reflect.Type {
+	switch {
+	case t == unixFDIndexType:
+		return unixFDType
+	case t.Kind() == reflect.Slice:
+		return reflect.SliceOf(resolvedFDType(t.Elem()))
+	case t.Kind() == reflect.Map:
+		return reflect.MapOf(resolvedFDType(t.Key()), resolvedFDType(t.Elem()))
+	}
+	return t
 }
 
 // DecodeMessage tries to decode a single message in the D-Bus wire format
@@ -213,25 +655,132 @@ func DecodeMessage(rd io.Reader) (msg *Message, err error) {
 	return DecodeMessageWithFDs(rd, make([]int, 0))
 }
 
-type nullwriter struct{}
+// DecodeMessageRaw acts like DecodeMessage, but leaves the body of the message
+// undecoded: Body is nil and RawBody returns the body as it was read. Encoding
+// the message in the same byte order writes the body verbatim, which allows
+// forwarding messages without decoding and re-encoding their contents.
+func DecodeMessageRaw(rd io.Reader) (msg *Message, err error) {
+	return readMessage(rd, make([]int, 0))
+}
 
-func (nullwriter) Write(p []byte) (cnt int, err error) {
-	return len(p), nil
+// RawBody returns the undecoded body of a message returned by DecodeMessageRaw
+// and the byte order it is encoded in. It returns a nil order if the message
+// has no undecoded body.
+func (msg *Message) RawBody() ([]byte, binary.ByteOrder) {
+	return msg.rawBody, msg.rawOrder
 }
 
 func (msg *Message) CountFds() (int, error) {
-	if len(msg.Body) == 0 {
-		return 0, nil
-	}
-	enc := newEncoder(nullwriter{}, nativeEndian, make([]int, 0))
-	err := enc.Encode(msg.Body...)
-	return len(enc.fds), err
+	_, fds, err := msg.bodySize()
+	return fds, err
+}
+
+// encodeBufferPool holds the buffers that EncodeToWithFDs encodes messages
+// into before writing them out.
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
 }
 
 func (msg *Message) EncodeToWithFDs(out io.Writer, order binary.ByteOrder) (fds []int, err error) {
-	if err := msg.validateHeader(); err != nil {
+	buf := encodeBufferPool.Get().(*[]byte)
+	defer func() {
+		if cap(*buf) <= maxPooledBufferSize {
+			encodeBufferPool.Put(buf)
+		}
+	}()
+	*buf, fds, err = msg.AppendTo((*buf)[:0], order)
+	if err != nil {
 		return nil, err
 	}
+	if _, err := out.Write(*buf); err != nil {
+		return nil, err
+	}
+	return fds, nil
+}
+
+// appendWriter is an io.Writer that appends to a byte slice.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+// AppendTo appends the encoded message to b and returns the extended buffer
+// and the unix file descriptors referenced by the message. The byte order must
+// be either binary.LittleEndian or binary.BigEndian. Reusing the returned
+// buffer (e.g. as b[:0]) for subsequent messages avoids allocating a new one
+// for every message. If the message is not valid, an error is returned and b
+// is returned unchanged.
+//
+// An undecoded body (see DecodeMessageRaw) is appended verbatim if order is
+// the byte order it is encoded in. Otherwise, it is decoded first.
+func (msg *Message) AppendTo(b []byte, order binary.ByteOrder) ([]byte, []int, error) {
+	if msg.rawOrder != nil && msg.rawOrder != order {
+		dec := getDecoder(nil, order, nil)
+		err := msg.decodeBody(dec)
+		putDecoder(dec)
+		if err != nil {
+			return b, nil, err
+		}
+	}
+	if err := msg.validateHeader(); err != nil {
+		return b, nil, err
+	}
+	start := len(b)
+	hb, err := msg.appendHeader(b, order)
+	if err != nil {
+		return b, nil, err
+	}
+	bodyStart := len(hb)
+	w := &appendWriter{hb}
+	fds := make([]int, 0)
+	if msg.rawOrder != nil {
+		w.b = append(w.b, msg.rawBody...)
+		fds = msg.rawFDs
+	} else if len(msg.Body) != 0 {
+		enc := newEncoder(w, order, fds)
+		if err := enc.Encode(msg.Body...); err != nil {
+			return b, nil, err
+		}
+		fds = enc.fds
+	}
+	if len(w.b)-start > 1<<27 {
+		return b, nil, InvalidMessageError("message is too long")
+	}
+	order.PutUint32(w.b[start+4:], uint32(len(w.b)-bodyStart))
+	return w.b, fds, nil
+}
+
+// EncodedSize returns the number of bytes that msg occupies when encoded in
+// the given byte order, e.g. to enforce a quota before sending it. It returns
+// the error that encoding msg would return.
+func (msg *Message) EncodedSize(order binary.ByteOrder) (int, error) {
+	if err := msg.validateHeader(); err != nil {
+		return 0, err
+	}
+	header, err := msg.appendHeader(nil, order)
+	if err != nil {
+		return 0, err
+	}
+	size, _, err := msg.bodySize()
+	if err != nil {
+		return 0, err
+	}
+	if len(header)+size > 1<<27 {
+		return 0, InvalidMessageError("message is too long")
+	}
+	return len(header) + size, nil
+}
+
+// appendHeader appends the header of msg, padded for the body to start at a
+// multiple of 8 bytes, to b. The body length is left at 0; it is at offset 4
+// of the header.
+func (msg *Message) appendHeader(b []byte, order binary.ByteOrder) ([]byte, error) {
 	var vs [7]interface{}
 	switch order {
 	case binary.LittleEndian:
@@ -239,41 +788,107 @@ func (msg *Message) EncodeToWithFDs(out io.Writer, order binary.ByteOrder) (fds
 	case binary.BigEndian:
 		vs[0] = byte('B')
 	default:
-		return nil, errors.New("dbus: invalid byte order")
-	}
-	body := new(bytes.Buffer)
-	fds = make([]int, 0)
-	enc := newEncoder(body, order, fds)
-	if len(msg.Body) != 0 {
-		err = enc.Encode(msg.Body...)
-		if err != nil {
-			return
-		}
+		return b, errors.New("dbus: invalid byte order")
 	}
 	vs[1] = msg.Type
 	vs[2] = msg.Flags
 	vs[3] = protoVersion
-	vs[4] = uint32(len(body.Bytes()))
+	vs[4] = uint32(0)
 	vs[5] = msg.serial
 	headers := make([]header, 0, len(msg.Headers))
 	for k, v := range msg.Headers {
 		headers = append(headers, header{byte(k), v})
 	}
 	vs[6] = headers
-	var buf bytes.Buffer
-	enc = newEncoder(&buf, order, enc.fds)
-	err = enc.Encode(vs[:]...)
-	if err != nil {
-		return
+
+	w := &appendWriter{b}
+	enc := newEncoder(w, order, nil)
+	if err := enc.Encode(vs[:]...); err != nil {
+		return b, err
 	}
 	enc.align(8)
-	if _, err := body.WriteTo(&buf); err != nil {
+	return w.b, nil
+}
+
+// streamBodySize is the body size from which transports stream messages with
+// StreamTo instead of encoding them into a buffer first.
+const streamBodySize = 1 << 16
+
+// bodySize returns the encoded size of the body of msg and the number of unix
+// fds it references.
+func (msg *Message) bodySize() (size, fds int, err error) {
+	if msg.bodySized {
+		return msg.bodyLen, msg.bodyFDs, msg.bodyErr
+	}
+	if msg.rawOrder != nil {
+		return len(msg.rawBody), len(msg.rawFDs), nil
+	}
+	if len(msg.Body) == 0 {
+		return 0, 0, nil
+	}
+	var cw countingWriter
+	enc := newEncoder(&cw, nativeEndian, make([]int, 0))
+	enc.sizeOnly = true
+	err = enc.Encode(msg.Body...)
+	return cw.n, len(enc.fds), err
+}
+
+// cacheBodySize makes bodySize return its current result until
+// uncacheBodySize is called, so that it is only computed once while msg is
+// sent.
+func (msg *Message) cacheBodySize() {
+	msg.bodyLen, msg.bodyFDs, msg.bodyErr = msg.bodySize()
+	msg.bodySized = true
+}
+
+func (msg *Message) uncacheBodySize() {
+	msg.bodySized = false
+	msg.bodyErr = nil
+}
+
+// StreamTo acts like EncodeToWithFDs, but writes the body directly to out
+// instead of encoding the whole message into a buffer first, which keeps the
+// memory needed for sending large bodies (e.g. byte arrays) low. In exchange,
+// the body is encoded twice: once to determine its length, which precedes it,
+// and once for writing; values in nested arrays are sized once more per
+// enclosing array. As encoding errors are found in the first pass, nothing is
+// written for invalid messages; out may have received part of the message if
+// writing fails.
+func (msg *Message) StreamTo(out io.Writer, order binary.ByteOrder) ([]int, error) {
+	size, _, err := msg.bodySize()
+	if err != nil {
+		return nil, err
+	}
+	return msg.streamTo(out, order, size)
+}
+
+// streamTo implements StreamTo for a body of the given encoded size.
+func (msg *Message) streamTo(out io.Writer, order binary.ByteOrder, size int) ([]int, error) {
+	if msg.rawOrder != nil {
+		// the body is in memory anyway
+		return msg.EncodeToWithFDs(out, order)
+	}
+	if err := msg.validateHeader(); err != nil {
+		return nil, err
+	}
+	header, err := msg.appendHeader(nil, order)
+	if err != nil {
 		return nil, err
 	}
-	if buf.Len() > 1<<27 {
+	if len(header)+size > 1<<27 {
 		return nil, InvalidMessageError("message is too long")
 	}
-	if _, err := buf.WriteTo(out); err != nil {
+	order.PutUint32(header[4:], uint32(size))
+	bw := bufio.NewWriter(out)
+	if _, err := bw.Write(header); err != nil {
+		return nil, err
+	}
+	enc := newEncoder(bw, order, make([]int, 0))
+	enc.direct = true
+	if err := enc.Encode(msg.Body...); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
 		return nil, err
 	}
 	return enc.fds, nil
@@ -343,7 +958,8 @@ func (msg *Message) validateHeader() error {
 }
 
 // Serial returns the message's serial number. The returned value is only valid
-// for messages received by eavesdropping.
+// for received messages, such as those passed to a RawMethodHandler or
+// received by eavesdropping.
 func (msg *Message) Serial() uint32 {
 	return msg.serial
 }
@@ -380,14 +996,25 @@ func (msg *Message) String() string {
 	if v, ok := msg.Headers[FieldMember]; ok {
 		s += " member " + v.value.(string)
 	}
-	if len(msg.Body) != 0 {
-		s += "\n"
-	}
-	for i, v := range msg.Body {
-		s += "  " + MakeVariant(v).String()
-		if i != len(msg.Body)-1 {
-			s += "\n"
-		}
+	return s + formatBody(msg.Body)
+}
+
+// formatBody formats the values of a message body for the String methods, one
+// indented value per line. Values that can't be represented as a D-Bus type
+// are printed with %v.
+func formatBody(body []interface{}) string {
+	var s string
+	for _, v := range body {
+		s += "\n  " + formatValue(v)
 	}
 	return s
 }
+
+func formatValue(v interface{}) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = fmt.Sprintf("%v", v)
+		}
+	}()
+	return MakeVariant(v).String()
+}
@@ -1,6 +1,15 @@
 package dbus
 
-import "testing"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
 
 func TestMessage_validateHeader(t *testing.T) {
 	tcs := []struct {
@@ -108,3 +117,432 @@ func TestMessage_validateHeader(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeMessageConcurrent(t *testing.T) {
+	msg := &Message{
+		Type: TypeMethodCall,
+		Headers: map[HeaderField]Variant{
+			FieldPath:      MakeVariant(ObjectPath("/org/freedesktop/DBus")),
+			FieldMember:    MakeVariant("Hello"),
+			FieldSignature: MakeVariant(SignatureOf("", map[string]Variant{})),
+		},
+		Body:   []interface{}{"some string", map[string]Variant{"key": MakeVariant(uint32(42))}},
+		serial: 1,
+	}
+	buf := new(bytes.Buffer)
+	if err := msg.EncodeTo(buf, nativeEndian); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				decoded, err := DecodeMessage(bytes.NewReader(data))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if s, _ := decoded.Body[0].(string); s != "some string" {
+					t.Errorf("got %v, want %q", decoded.Body[0], "some string")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDecodeMessageRaw(t *testing.T) {
+	msg := &Message{
+		Type: TypeSignal,
+		Headers: map[HeaderField]Variant{
+			FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/Raw")),
+			FieldInterface: MakeVariant("org.godbus.DBus.Raw"),
+			FieldMember:    MakeVariant("Forwarded"),
+			FieldSignature: MakeVariant(SignatureOf("", uint64(0), map[string]Variant{})),
+		},
+		Body:   []interface{}{"raw", uint64(1) << 40, map[string]Variant{"key": MakeVariant(int16(-2))}},
+		serial: 3,
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		buf := new(bytes.Buffer)
+		if err := msg.EncodeTo(buf, order); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		bodyLen := int(order.Uint32(data[4:]))
+		body := data[len(data)-bodyLen:]
+
+		raw, err := DecodeMessageRaw(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw.Body != nil {
+			t.Errorf("expected the body to be left undecoded, got %v", raw.Body)
+		}
+		rawBody, rawOrder := raw.RawBody()
+		if rawOrder != order || !bytes.Equal(rawBody, body) {
+			t.Fatalf("got raw body %x in %v, want %x in %v", rawBody, rawOrder, body, order)
+		}
+
+		buf.Reset()
+		if err := raw.EncodeTo(buf, rawOrder); err != nil {
+			t.Fatal(err)
+		}
+		if out := buf.Bytes(); !bytes.Equal(out[len(out)-bodyLen:], body) {
+			t.Errorf("expected the body to be written verbatim, got %x", out[len(out)-bodyLen:])
+		}
+		decoded, err := DecodeMessage(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded.Body, msg.Body) || decoded.serial != msg.serial {
+			t.Errorf("got %v, want %v", decoded, msg)
+		}
+
+		// encoding in the other byte order has to decode the body first
+		other := binary.ByteOrder(binary.BigEndian)
+		if order == binary.BigEndian {
+			other = binary.LittleEndian
+		}
+		buf.Reset()
+		if err := raw.EncodeTo(buf, other); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err = DecodeMessage(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded.Body, msg.Body) {
+			t.Errorf("got body %v, want %v", decoded.Body, msg.Body)
+		}
+	}
+}
+
+// mismatchedReply returns an encoded method reply to serial whose
+// FieldSignature declares more than its body contains.
+// versionedMessages returns two encoded signals, the first of which has the
+// protocol version v.
+func versionedMessages(t *testing.T, v byte) []byte {
+	var buf bytes.Buffer
+	for i, member := range []string{"First", "Second"} {
+		msg := &Message{
+			Type: TypeSignal,
+			Headers: map[HeaderField]Variant{
+				FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/Version")),
+				FieldInterface: MakeVariant("org.godbus.DBus.Version"),
+				FieldMember:    MakeVariant(member),
+				FieldSignature: MakeVariant(SignatureOf("")),
+			},
+			Body:   []interface{}{"body"},
+			serial: uint32(i + 1),
+		}
+		start := buf.Len()
+		if err := msg.EncodeTo(&buf, binary.LittleEndian); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			buf.Bytes()[start+3] = v
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeMessageProtoVersion(t *testing.T) {
+	rd := bytes.NewReader(versionedMessages(t, 2))
+	if _, err := DecodeMessage(rd); err != InvalidMessageError("unsupported protocol version") {
+		t.Fatalf("got %v decoding a message of version 2, want unsupported protocol version", err)
+	}
+	msg, err := DecodeMessage(rd)
+	if err != nil {
+		t.Fatalf("decoding the message after it: %v", err)
+	}
+	if member := msg.Headers[FieldMember].Value(); member != "Second" {
+		t.Errorf("got member %v after the skipped message, want Second", member)
+	}
+}
+
+func TestDecodeMessageUnixFD(t *testing.T) {
+	if typ := typeFor("h"); typ != unixFDIndexType {
+		t.Errorf("got type %v for h, want %v", typ, unixFDIndexType)
+	}
+	sig, err := ParseSignature("saha{sh}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{
+		Type: TypeSignal,
+		Headers: map[HeaderField]Variant{
+			FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/FD")),
+			FieldInterface: MakeVariant("org.godbus.DBus.FD"),
+			FieldMember:    MakeVariant("FDs"),
+			FieldSignature: MakeVariant(sig),
+			FieldUnixFDs:   MakeVariant(uint32(3)),
+		},
+		Body: []interface{}{"fds", []UnixFD{5, 7}, map[string]UnixFD{"x": 9}},
+	}
+	var buf bytes.Buffer
+	fds, err := msg.EncodeToWithFDs(&buf, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fds, []int{5, 7, 9}) {
+		t.Fatalf("got fds %v, want [5 7 9]", fds)
+	}
+
+	decoded, err := DecodeMessageWithFDs(bytes.NewReader(buf.Bytes()), []int{15, 17, 19})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := decoded.Body[1].([]UnixFD); !ok || !reflect.DeepEqual(got, []UnixFD{15, 17}) {
+		t.Errorf("got %#v with the received fds, want []UnixFD{15, 17}", decoded.Body[1])
+	}
+	if got, ok := decoded.Body[2].(map[string]UnixFD); !ok || got["x"] != 19 {
+		t.Errorf("got %#v with the received fds, want map[x:19]", decoded.Body[2])
+	}
+	decoded, err = DecodeMessage(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := decoded.Body[1].([]UnixFDIndex); !ok || !reflect.DeepEqual(got, []UnixFDIndex{0, 1}) {
+		t.Errorf("got %#v without fds, want []UnixFDIndex{0, 1}", decoded.Body[1])
+	}
+}
+
+func TestResolveFDs(t *testing.T) {
+	type named struct {
+		Name string
+		FD   UnixFD
+	}
+	msg := &Message{
+		Type: TypeSignal,
+		Headers: map[HeaderField]Variant{
+			FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/FD")),
+			FieldInterface: MakeVariant("org.godbus.DBus.FD"),
+			FieldMember:    MakeVariant("FDs"),
+			FieldUnixFDs:   MakeVariant(uint32(5)),
+		},
+		Body: []interface{}{
+			UnixFD(1),
+			[]UnixFD{2, 3},
+			[]named{{"four", 4}},
+			MakeVariant(UnixFD(5)),
+			"no fd",
+		},
+	}
+	msg.Headers[FieldSignature] = MakeVariant(SignatureOf(msg.Body...))
+	var buf bytes.Buffer
+	if _, err := msg.EncodeToWithFDs(&buf, binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.Body[0].(UnixFDIndex); !ok {
+		t.Fatalf("got %T before resolving, want UnixFDIndex", decoded.Body[0])
+	}
+	if err := ResolveFDs(decoded, []int{10, 20, 30}); err != InvalidMessageError("invalid index for unix fd") {
+		t.Errorf("got %v with too few fds, want invalid index", err)
+	}
+
+	decoded, err = DecodeMessage(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ResolveFDs(decoded, []int{10, 20, 30, 40, 50}); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		UnixFD(10),
+		[]UnixFD{20, 30},
+		[][]interface{}{{"four", UnixFD(40)}},
+		MakeVariant(UnixFD(50)),
+		"no fd",
+	}
+	if !reflect.DeepEqual(decoded.Body, want) {
+		t.Errorf("got body %#v, want %#v", decoded.Body, want)
+	}
+	var got []named
+	if err := Store(decoded.Body[2:3], &got); err != nil || len(got) != 1 || got[0].FD != 40 {
+		t.Errorf("got %v (%v) storing the resolved structs", got, err)
+	}
+}
+
+func mismatchedReply(t *testing.T, serial uint32) []byte {
+	msg := &Message{
+		Type: TypeMethodReply,
+		Headers: map[HeaderField]Variant{
+			FieldReplySerial: MakeVariant(serial),
+			FieldSignature:   MakeVariant(Signature{"st"}),
+		},
+		Body:   []interface{}{"foo"},
+		serial: 1,
+	}
+	var buf bytes.Buffer
+	if err := msg.EncodeTo(&buf, nativeEndian); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeMessageSignatureMismatch(t *testing.T) {
+	_, err := DecodeMessage(bytes.NewReader(mismatchedReply(t, 1)))
+	if _, ok := err.(InvalidMessageError); !ok {
+		t.Fatalf("expected InvalidMessageError, got %T: %v", err, err)
+	}
+	if !strings.Contains(err.Error(), `"st"`) {
+		t.Errorf("expected the error to name the signature, got %v", err)
+	}
+}
+
+func TestCallSignatureMismatch(t *testing.T) {
+	reader, pipewriter := io.Pipe()
+	defer pipewriter.Close()
+	serials := make(chan uint32, 2)
+	bus, err := NewConn(rwc{Reader: reader, Writer: io.Discard}, WithOutgoingInterceptor(func(msg *Message) {
+		serials <- msg.serial
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	bus.Start()
+
+	// A malformed reply fails the call but leaves the connection usable.
+	call := bus.BusObject().Go("org.freedesktop.DBus.Ping", 0, nil)
+	if _, err := pipewriter.Write(mismatchedReply(t, <-serials)); err != nil {
+		t.Fatal(err)
+	}
+	<-call.Done
+	if _, ok := call.Err.(InvalidMessageError); !ok {
+		t.Fatalf("expected InvalidMessageError, got %T: %v", call.Err, call.Err)
+	}
+	if !bus.Connected() {
+		t.Fatal("connection closed after an invalid reply")
+	}
+
+	call = bus.BusObject().Go("org.freedesktop.DBus.Ping", 0, nil)
+	reply := &Message{
+		Type:    TypeMethodReply,
+		Headers: map[HeaderField]Variant{FieldReplySerial: MakeVariant(<-serials)},
+		serial:  2,
+	}
+	if err := reply.EncodeTo(pipewriter, nativeEndian); err != nil {
+		t.Fatal(err)
+	}
+	<-call.Done
+	if call.Err != nil {
+		t.Errorf("unexpected error after an invalid reply: %v", call.Err)
+	}
+}
+
+func TestSignalString(t *testing.T) {
+	sig := &Signal{
+		Sender: ":1.42",
+		Path:   "/org/godbus/Test",
+		Name:   "org.godbus.Test.Changed",
+		Body:   []interface{}{"foo", uint32(7), map[string]Variant{"a": MakeVariant(int32(1))}},
+	}
+	want := "signal from :1.42 path /org/godbus/Test name org.godbus.Test.Changed\n" +
+		"  \"foo\"\n" +
+		"  @u 7\n" +
+		"  {\"a\": <1>}"
+	if got := sig.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCallString(t *testing.T) {
+	call := &Call{
+		Destination: "org.godbus.Test",
+		Path:        "/org/godbus/Test",
+		Method:      "org.godbus.Test.Get",
+		Body:        []interface{}{[]string{"a", "b"}},
+	}
+	want := "call to org.godbus.Test path /org/godbus/Test method org.godbus.Test.Get\n  [\"a\", \"b\"]"
+	if got := call.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	call.Err = NewError("org.godbus.Test.Error.Failed", []interface{}{"boom"})
+	got := call.String()
+	for _, s := range []string{"org.godbus.Test.Get", "/org/godbus/Test", "boom"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expected %q in %q", s, got)
+		}
+	}
+
+	// values that aren't D-Bus types don't make String panic
+	call = &Call{Path: "/", Method: "org.godbus.Test.Get", Body: []interface{}{nil}}
+	if got := call.String(); !strings.Contains(got, "<nil>") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMessageMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(bigMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Type    string
+		Flags   int
+		Serial  uint32
+		Headers map[string]struct {
+			Signature string
+			Value     interface{}
+		}
+		Body []struct {
+			Signature string
+			Value     interface{}
+		}
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("%v in %s", err, b)
+	}
+	if got.Type != "method_call" || got.Serial != 2 {
+		t.Errorf("unexpected type or serial in %s", b)
+	}
+	if h := got.Headers["member"]; h.Signature != "s" || h.Value != "Notify" {
+		t.Errorf("unexpected member header %+v", h)
+	}
+	if h := got.Headers["path"]; h.Signature != "o" || h.Value != "/org/freedesktop/Notifications" {
+		t.Errorf("unexpected path header %+v", h)
+	}
+	if h := got.Headers["signature"]; h.Signature != "g" || h.Value != "susssasa{sv}i" {
+		t.Errorf("unexpected signature header %+v", h)
+	}
+	if len(got.Body) != len(bigMessage.Body) {
+		t.Fatalf("got %d body values, want %d", len(got.Body), len(bigMessage.Body))
+	}
+	if v := got.Body[0]; v.Signature != "s" || v.Value != "app_name" {
+		t.Errorf("unexpected first body value %+v", v)
+	}
+	if v := got.Body[7]; v.Signature != "i" || v.Value != float64(-1) {
+		t.Errorf("unexpected last body value %+v", v)
+	}
+	hints, _ := got.Body[6].Value.(map[string]interface{})
+	sound, _ := hints["sound-name"].(map[string]interface{})
+	if got.Body[6].Signature != "a{sv}" || sound["signature"] != "s" || sound["value"] != "dialog-information" {
+		t.Errorf("unexpected hints %+v", got.Body[6])
+	}
+
+	// decoded structs keep the signature from the header
+	msg := &Message{
+		Type:    TypeSignal,
+		Headers: map[HeaderField]Variant{FieldSignature: MakeVariant(Signature{"(su)"})},
+		Body:    []interface{}{[]interface{}{"foo", uint32(1)}},
+	}
+	b, err = json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"body":[{"signature":"(su)","value":["foo",1]}]`) {
+		t.Errorf("unexpected encoding of a struct body: %s", b)
+	}
+}
@@ -0,0 +1,137 @@
+package dbus
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OwnedName is a well-known name requested with OwnName. It reports changes of
+// ownership until it is released or the connection is closed.
+type OwnedName struct {
+	conn    *Conn
+	name    string
+	reply   RequestNameReply
+	isOwned atomic.Bool
+	signals chan *Signal
+	owned   chan bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+// OwnName requests the given name like RequestName and returns an OwnedName
+// that reports whenever the connection acquires or loses it. The returned
+// value must be released with Release when it is no longer needed.
+//
+// Check Reply to learn whether the name was acquired right away; a name that
+// was queued is reported on the Owned channel once it is acquired.
+func (conn *Conn) OwnName(name string, flags RequestNameFlags) (*OwnedName, error) {
+	n := &OwnedName{
+		conn:    conn,
+		name:    name,
+		signals: make(chan *Signal, 8),
+		owned:   make(chan bool, 1),
+		done:    make(chan struct{}),
+	}
+	// NameAcquired and NameLost are sent to the connection directly, so no
+	// match rule is needed to receive them.
+	conn.Signal(n.signals)
+	reply, err := conn.RequestName(name, flags)
+	if err != nil {
+		n.once.Do(n.stop)
+		return nil, err
+	}
+	n.reply = reply
+	n.isOwned.Store(reply == RequestNameReplyPrimaryOwner || reply == RequestNameReplyAlreadyOwner)
+	go n.watch()
+	return n, nil
+}
+
+// Name returns the requested name.
+func (n *OwnedName) Name() string {
+	return n.name
+}
+
+// Reply returns the reply to the initial RequestName call.
+func (n *OwnedName) Reply() RequestNameReply {
+	return n.reply
+}
+
+// Owned returns a channel that receives true when the name is acquired
+// (including the initial acquisition) and false when it is lost, e.g. because
+// another connection replaced the owner. Changes are not queued: if the
+// previous state was not received yet, it is replaced by the current one, so a
+// slow receiver always gets the latest state. The channel is closed when n is
+// released or the connection is closed.
+func (n *OwnedName) Owned() <-chan bool {
+	return n.owned
+}
+
+// IsOwned returns whether the connection currently owns the name, as far as it
+// has been reported by the bus. Method handlers of exported objects can use it
+// to refuse work after the name was taken over by another connection that
+// requested it with NameFlagReplaceExisting.
+func (n *OwnedName) IsOwned() bool {
+	return n.isOwned.Load()
+}
+
+// Release stops watching the name and releases it on the bus. It may be
+// called more than once; only the first call has an effect.
+func (n *OwnedName) Release() error {
+	var err error
+	n.once.Do(func() {
+		n.stop()
+		_, err = n.conn.ReleaseName(n.name)
+	})
+	return err
+}
+
+func (n *OwnedName) stop() {
+	n.conn.RemoveSignal(n.signals)
+	close(n.done)
+}
+
+// watch forwards NameAcquired and NameLost signals for n's name to the Owned
+// channel, coalescing the states that have not been received.
+func (n *OwnedName) watch() {
+	defer close(n.owned)
+	for {
+		var sig *Signal
+		var ok bool
+		select {
+		case sig, ok = <-n.signals:
+			if !ok {
+				return
+			}
+		case <-n.done:
+			return
+		}
+		if sig.Sender != "org.freedesktop.DBus" || len(sig.Body) == 0 {
+			continue
+		}
+		if name, _ := sig.Body[0].(string); name != n.name {
+			continue
+		}
+		var owned bool
+		switch sig.Name {
+		case "org.freedesktop.DBus.NameAcquired":
+			owned = true
+		case "org.freedesktop.DBus.NameLost":
+			owned = false
+		default:
+			continue
+		}
+		n.isOwned.Store(owned)
+		// Coalesce with a state that was not received yet rather than
+		// blocking, so that signals keep being read in order. watch is the
+		// only sender, so the second send cannot block.
+		select {
+		case n.owned <- owned:
+		default:
+			select {
+			case <-n.owned:
+			default:
+			}
+			n.owned <- owned
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package dbus
+
+import (
+	"testing"
+	"time"
+)
+
+func receiveOwned(t *testing.T, n *OwnedName) bool {
+	t.Helper()
+	select {
+	case owned, ok := <-n.Owned():
+		if !ok {
+			t.Fatal("Owned channel closed unexpectedly")
+		}
+		return owned
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change of ownership")
+	}
+	return false
+}
+
+func TestOwnNameReplaced(t *testing.T) {
+	const name = "org.godbus.DBus.TestOwnName"
+	first, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	n, err := first.OwnName(name, NameFlagAllowReplacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Release()
+	if n.Reply() != RequestNameReplyPrimaryOwner {
+		t.Fatalf("got reply %v, want primary owner", n.Reply())
+	}
+	if !receiveOwned(t, n) {
+		t.Error("expected the name to be reported as acquired")
+	}
+
+	if r, err := second.RequestName(name, NameFlagReplaceExisting); err != nil || r != RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName = %v, %v; want primary owner", r, err)
+	}
+	if receiveOwned(t, n) {
+		t.Error("expected the name to be reported as lost")
+	}
+
+	if err := n.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-n.Owned(); ok {
+		t.Error("expected Owned to be closed after Release")
+	}
+	if err := n.Release(); err != nil {
+		t.Errorf("second Release: %v", err)
+	}
+}
+
+func TestOwnNameContended(t *testing.T) {
+	const name = "org.godbus.DBus.TestOwnNameContended"
+	first, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	n1, err := first.OwnName(name, NameFlagAllowReplacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Release()
+	if !receiveOwned(t, n1) || !n1.IsOwned() {
+		t.Fatal("expected the first connection to own the name")
+	}
+
+	n2, err := second.OwnName(name, NameFlagAllowReplacement|NameFlagReplaceExisting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n2.Release()
+	if n2.Reply() != RequestNameReplyPrimaryOwner {
+		t.Fatalf("got reply %v, want primary owner", n2.Reply())
+	}
+	if receiveOwned(t, n1) || n1.IsOwned() {
+		t.Error("expected the first connection to be notified of the replacement")
+	}
+	if !receiveOwned(t, n2) || !n2.IsOwned() {
+		t.Error("expected the second connection to own the name")
+	}
+
+	// The first connection was queued when it was replaced, so it gets the
+	// name back once the second one releases it.
+	if err := n2.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if !receiveOwned(t, n1) || !n1.IsOwned() {
+		t.Error("expected the first connection to reacquire the name")
+	}
+}
+
+func TestOwnNameCoalesced(t *testing.T) {
+	const name = "org.godbus.DBus.TestOwnNameCoalesced"
+	first, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	n, err := first.OwnName(name, NameFlagAllowReplacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Release()
+
+	// Change the owner back and forth without receiving from Owned; the
+	// first connection is queued each time and ends up owning the name.
+	const cycles = 10
+	for i := 0; i < cycles; i++ {
+		if _, err := second.RequestName(name, NameFlagReplaceExisting); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := second.ReleaseName(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := first.BusObject().Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+
+	// Receive until no more states arrive; the last one must be the current.
+	var last bool
+	received := 0
+	for quiet := false; !quiet; {
+		select {
+		case owned, ok := <-n.Owned():
+			if !ok {
+				t.Fatal("Owned channel closed unexpectedly")
+			}
+			last = owned
+			received++
+		case <-time.After(200 * time.Millisecond):
+			quiet = true
+		}
+	}
+	if received == 0 || !last {
+		t.Fatalf("received %d states ending with %v, want the name to be reacquired", received, last)
+	}
+	if received > 2*cycles {
+		t.Errorf("received %d states, want them to be coalesced", received)
+	}
+	if !n.IsOwned() {
+		t.Error("expected the name to be owned")
+	}
+}
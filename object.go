@@ -3,11 +3,15 @@ package dbus
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
 	"strings"
 )
 
 // BusObject is the interface of a remote object on which methods can be
-// invoked.
+// invoked. The values returned by Conn.Object and Conn.BusObject are of type
+// *Object, which has more methods, e.g. CallDeferred; use a type assertion to
+// call them.
 type BusObject interface {
 	Call(method string, flags Flags, args ...interface{}) *Call
 	CallWithContext(ctx context.Context, method string, flags Flags, args ...interface{}) *Call
@@ -27,6 +31,9 @@ type Object struct {
 	conn *Conn
 	dest string
 	path ObjectPath
+
+	// noDefaultFlags keeps the connection's default flags off the calls.
+	noDefaultFlags bool
 }
 
 // Call calls a method with (*Object).Go and waits for its reply.
@@ -34,11 +41,47 @@ func (o *Object) Call(method string, flags Flags, args ...interface{}) *Call {
 	return <-o.createCall(context.Background(), method, flags, make(chan *Call, 1), args...).Done
 }
 
-// CallWithContext acts like Call but takes a context
+// CallWithFlags acts like Call; its name makes calls that need a specific
+// combination of flags clearer. See Go for the flags that are honored. With
+// FlagNoReplyExpected, it returns as soon as the call was sent.
+func (o *Object) CallWithFlags(method string, flags Flags, args ...interface{}) *Call {
+	return o.Call(method, flags, args...)
+}
+
+// CallWithContext acts like Call but takes a context. If ctx is done before
+// the reply arrives, the call returns ctx.Err() and is forgotten right away; a
+// reply received later is ignored. D-Bus has no way to tell the peer to stop
+// processing the call, though.
 func (o *Object) CallWithContext(ctx context.Context, method string, flags Flags, args ...interface{}) *Call {
 	return <-o.createCall(ctx, method, flags, make(chan *Call, 1), args...).Done
 }
 
+// CallDeferred acts like CallWithContext, but the body of the reply is not
+// decoded by the connection's reader. Instead, it is decoded by the first
+// call to Store on the returned Call, and Body stays nil until then. This
+// keeps large replies from holding up the delivery of other messages, and
+// RawBody gives access to the reply without decoding it at all.
+func (o *Object) CallDeferred(ctx context.Context, method string, flags Flags, args ...interface{}) *Call {
+	msg := o.newCallMessage(method, flags, args...)
+	return <-o.conn.send(ctx, msg, make(chan *Call, 1), true, false).Done
+}
+
+// CallNoReply calls a method with FlagNoReplyExpected set and returns as soon
+// as the message has been sent. Unlike Go with that flag, it doesn't allocate
+// a Call; the returned error only reports failures to send the message, such
+// as ErrClosed or an encoding error, as the peer doesn't reply.
+func (o *Object) CallNoReply(method string, args ...interface{}) error {
+	msg := o.newCallMessage(method, FlagNoReplyExpected, args...)
+	var closed bool
+	err := o.conn.sendMessageAndIfClosed(msg, func() {
+		closed = true
+	})
+	if closed {
+		return ErrClosed
+	}
+	return err
+}
+
 // AddMatchSignal subscribes BusObject to signals from specified interface,
 // method (member). Additional filter rules can be added via WithMatch* option constructors.
 // Note: To filter events by object path you have to specify this path via an option.
@@ -89,6 +132,11 @@ func (o *Object) RemoveMatchSignal(iface, member string, options ...MatchOption)
 //
 // If the method parameter contains a dot ('.'), the part before the last dot
 // specifies the interface on which the method is called.
+//
+// The flags that are honored for method calls are FlagNoReplyExpected,
+// FlagNoAutoStart and FlagAllowInteractiveAuthorization, in any combination;
+// they are added to the connection's default flags (see WithDefaultFlags)
+// unless o was returned by WithoutDefaultFlags, and other bits are ignored.
 func (o *Object) Go(method string, flags Flags, ch chan *Call, args ...interface{}) *Call {
 	return o.createCall(context.Background(), method, flags, ch, args...)
 }
@@ -102,6 +150,11 @@ func (o *Object) createCall(ctx context.Context, method string, flags Flags, ch
 	if ctx == nil {
 		panic("nil context")
 	}
+	msg := o.newCallMessage(method, flags, args...)
+	return o.conn.SendWithContext(ctx, msg, ch)
+}
+
+func (o *Object) newCallMessage(method string, flags Flags, args ...interface{}) *Message {
 	iface := ""
 	i := strings.LastIndex(method, ".")
 	if i != -1 {
@@ -110,10 +163,15 @@ func (o *Object) createCall(ctx context.Context, method string, flags Flags, ch
 	method = method[i+1:]
 	msg := new(Message)
 	msg.Type = TypeMethodCall
-	msg.Flags = flags & (FlagNoAutoStart | FlagNoReplyExpected)
+	if !o.noDefaultFlags {
+		flags |= o.conn.defaultFlags
+	}
+	msg.Flags = flags & (FlagNoAutoStart | FlagNoReplyExpected | FlagAllowInteractiveAuthorization)
 	msg.Headers = make(map[HeaderField]Variant)
 	msg.Headers[FieldPath] = MakeVariant(o.path)
-	msg.Headers[FieldDestination] = MakeVariant(o.dest)
+	if !o.conn.peer {
+		msg.Headers[FieldDestination] = MakeVariant(o.dest)
+	}
 	msg.Headers[FieldMember] = MakeVariant(method)
 	if iface != "" {
 		msg.Headers[FieldInterface] = MakeVariant(iface)
@@ -122,14 +180,21 @@ func (o *Object) createCall(ctx context.Context, method string, flags Flags, ch
 	if len(args) > 0 {
 		msg.Headers[FieldSignature] = MakeVariant(SignatureOf(args...))
 	}
-	return o.conn.SendWithContext(ctx, msg, ch)
+	return msg
 }
 
 // GetProperty calls org.freedesktop.DBus.Properties.Get on the given
 // object. The property name must be given in interface.member notation.
+// Errors for missing properties or interfaces match ErrPropNotFound and
+// ErrIfaceNotFound with errors.Is.
 func (o *Object) GetProperty(p string) (Variant, error) {
+	return o.GetPropertyContext(context.Background(), p)
+}
+
+// GetPropertyContext acts like GetProperty but takes a context.
+func (o *Object) GetPropertyContext(ctx context.Context, p string) (Variant, error) {
 	var result Variant
-	err := o.StoreProperty(p, &result)
+	err := o.StorePropertyContext(ctx, p, &result)
 	return result, err
 }
 
@@ -137,6 +202,11 @@ func (o *Object) GetProperty(p string) (Variant, error) {
 // object. The property name must be given in interface.member notation.
 // It stores the returned property into the provided value.
 func (o *Object) StoreProperty(p string, value interface{}) error {
+	return o.StorePropertyContext(context.Background(), p, value)
+}
+
+// StorePropertyContext acts like StoreProperty but takes a context.
+func (o *Object) StorePropertyContext(ctx context.Context, p string, value interface{}) error {
 	idx := strings.LastIndex(p, ".")
 	if idx == -1 || idx+1 == len(p) {
 		return errors.New("dbus: invalid property " + p)
@@ -145,14 +215,70 @@ func (o *Object) StoreProperty(p string, value interface{}) error {
 	iface := p[:idx]
 	prop := p[idx+1:]
 
-	return o.Call("org.freedesktop.DBus.Properties.Get", 0, iface, prop).
+	return o.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Get", 0, iface, prop).
 		Store(value)
 }
 
+// Ping calls org.freedesktop.DBus.Peer.Ping on the object and returns nil if
+// the peer answered. This is a cheap way to check whether a service is alive.
+func (o *Object) Ping(ctx context.Context) error {
+	return o.CallWithContext(ctx, "org.freedesktop.DBus.Peer.Ping", 0).Err
+}
+
+// StorePropertiesInto calls org.freedesktop.DBus.Properties.GetAll for the
+// given interface on the object and stores the returned properties into the
+// struct pointed to by dest. Each exported field receives the property of the
+// same name, or of the name given in its `dbus:"Name"` tag, converted like
+// Store does. This is the tag Export reads, so options after the name, as in
+// `dbus:"Name,property"`, are allowed. Fields tagged with `dbus:"-"` and
+// properties without a corresponding field are ignored.
+func (o *Object) StorePropertiesInto(iface string, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("dbus: StorePropertiesInto needs a pointer to a struct")
+	}
+	var props map[string]Variant
+	err := o.Call("org.freedesktop.DBus.Properties.GetAll", 0, iface).Store(&props)
+	if err != nil {
+		return err
+	}
+	return storeProperties(props, v.Elem())
+}
+
+// storeProperties stores props into the fields of the struct value v as
+// described for StorePropertiesInto.
+func storeProperties(props map[string]Variant, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, _, ok := propertyTag(field)
+		if !ok {
+			continue
+		}
+		prop, ok := props[name]
+		if !ok {
+			continue
+		}
+		if err := store(v.Field(i), reflect.ValueOf(prop)); err != nil {
+			return fmt.Errorf("dbus: property %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 // SetProperty calls org.freedesktop.DBus.Properties.Set on the given
 // object. The property name must be given in interface.member notation.
+// Errors for read-only properties match ErrReadOnly with errors.Is.
 // Panics if v is not a valid Variant type.
 func (o *Object) SetProperty(p string, v interface{}) error {
+	return o.SetPropertyContext(context.Background(), p, v)
+}
+
+// SetPropertyContext acts like SetProperty but takes a context.
+func (o *Object) SetPropertyContext(ctx context.Context, p string, v interface{}) error {
 	// v might already be a variant...
 	variant, ok := v.(Variant)
 	if !ok {
@@ -167,7 +293,17 @@ func (o *Object) SetProperty(p string, v interface{}) error {
 	iface := p[:idx]
 	prop := p[idx+1:]
 
-	return o.Call("org.freedesktop.DBus.Properties.Set", 0, iface, prop, variant).Err
+	return o.CallWithContext(ctx, "org.freedesktop.DBus.Properties.Set", 0, iface, prop, variant).Err
+}
+
+// WithoutDefaultFlags returns a copy of o whose method calls only carry the
+// flags passed to them, without the connection's default flags (see
+// WithDefaultFlags), e.g. to let a single call activate a service on a
+// connection that sets FlagNoAutoStart by default.
+func (o *Object) WithoutDefaultFlags() *Object {
+	c := *o
+	c.noDefaultFlags = true
+	return &c
 }
 
 // Destination returns the destination that calls on (o *Object) are sent to.
@@ -1,7 +1,11 @@
 package dbus
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -172,3 +176,531 @@ func TestObjectSignalHandling(t *testing.T) {
 	case <-time.After(200 * time.Millisecond):
 	}
 }
+
+type bulkServer struct {
+	data []byte
+}
+
+func (s bulkServer) Data() ([]byte, *Error) {
+	return s.data, nil
+}
+
+func TestObjectCallDeferred(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	name := bus.Names()[0]
+	data := []byte("deferred reply")
+	err = bus.Export(bulkServer{data}, "/org/godbus/DBus/Bulk", "org.godbus.DBus.Bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := bus.Object(name, "/org/godbus/DBus/Bulk").(*Object).CallDeferred(context.Background(), "org.godbus.DBus.Bulk.Data", 0)
+	if call.Err != nil {
+		t.Fatal(call.Err)
+	}
+	if call.Body != nil {
+		t.Fatalf("expected undecoded body, got %v", call.Body)
+	}
+	var got []byte
+	if err := call.Store(&got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+	if len(call.Body) != 1 {
+		t.Errorf("expected Body to be set after Store, got %v", call.Body)
+	}
+}
+
+func (s bulkServer) Points(n uint32) ([]fixedPoint, *Error) {
+	points := make([]fixedPoint, n)
+	for i := range points {
+		points[i] = fixedPoint{X: int32(i), Y: int32(n) - int32(i), Z: 0.5, On: true}
+	}
+	return points, nil
+}
+
+func TestObjectCallDeferredFixedStructs(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	name := bus.Names()[0]
+	err = bus.Export(bulkServer{}, "/org/godbus/DBus/Bulk", "org.godbus.DBus.Bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := bus.Object(name, "/org/godbus/DBus/Bulk").(*Object)
+	var want []fixedPoint
+	if err := obj.Call("org.godbus.DBus.Bulk.Points", 0, uint32(100)).Store(&want); err != nil {
+		t.Fatal(err)
+	}
+	call := obj.CallDeferred(context.Background(), "org.godbus.DBus.Bulk.Points", 0, uint32(100))
+	var got []fixedPoint
+	if err := call.Store(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 100 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if body, ok := call.Body[0].([]fixedPoint); !ok || len(body) != 100 {
+		t.Errorf("expected Body to hold the decoded slice, got %T", call.Body[0])
+	}
+}
+
+func TestObjectCallDeferredRawBody(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	name := bus.Names()[0]
+	err = bus.Export(bulkServer{}, "/org/godbus/DBus/Bulk", "org.godbus.DBus.Bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := bus.Object(name, "/org/godbus/DBus/Bulk").(*Object)
+	if body, order, _ := obj.Call("org.godbus.DBus.Bulk.Points", 0, uint32(3)).RawBody(); body != nil || order != nil {
+		t.Errorf("expected no raw body for a decoded reply, got %v", body)
+	}
+
+	call := obj.CallDeferred(context.Background(), "org.godbus.DBus.Bulk.Points", 0, uint32(3))
+	body, order, sig := call.RawBody()
+	if order == nil {
+		t.Fatal("expected a raw body")
+	}
+	if sig.String() != "a(iiydb)" {
+		t.Errorf("got signature %s, want a(iiydb)", sig)
+	}
+	vs, err := newDecoder(bytes.NewReader(body), order, nil).Decode(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []fixedPoint
+	if err := Store(vs, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[2].X != 2 || got[2].Y != 1 {
+		t.Errorf("unexpected points %v", got)
+	}
+
+	// Store still works after looking at the raw body.
+	got = nil
+	if err := call.Store(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("unexpected points %v", got)
+	}
+	if _, order, _ := call.RawBody(); order != nil {
+		t.Error("expected no raw body after Store")
+	}
+}
+
+func benchmarkLargeReply(b *testing.B, deferred bool) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bus.Close()
+
+	name := bus.Names()[0]
+	err = bus.Export(bulkServer{make([]byte, 1<<16)}, "/org/godbus/DBus/Bulk", "org.godbus.DBus.Bulk")
+	if err != nil {
+		b.Fatal(err)
+	}
+	obj := bus.Object(name, "/org/godbus/DBus/Bulk").(*Object)
+	buf := make([]byte, 1<<16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var call *Call
+		if deferred {
+			call = obj.CallDeferred(context.Background(), "org.godbus.DBus.Bulk.Data", 0)
+		} else {
+			call = obj.Call("org.godbus.DBus.Bulk.Data", 0)
+		}
+		if err := call.Store(&buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCallLargeReply(b *testing.B) {
+	benchmarkLargeReply(b, false)
+}
+
+func BenchmarkCallDeferredLargeReply(b *testing.B) {
+	benchmarkLargeReply(b, true)
+}
+
+func benchmarkLargeStructReply(b *testing.B, raw bool) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bus.Close()
+
+	name := bus.Names()[0]
+	err = bus.Export(bulkServer{}, "/org/godbus/DBus/Bulk", "org.godbus.DBus.Bulk")
+	if err != nil {
+		b.Fatal(err)
+	}
+	obj := bus.Object(name, "/org/godbus/DBus/Bulk").(*Object)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if raw {
+			call := obj.CallDeferred(context.Background(), "org.godbus.DBus.Bulk.Points", 0, uint32(4096))
+			if call.Err != nil {
+				b.Fatal(call.Err)
+			}
+			if _, order, _ := call.RawBody(); order == nil {
+				b.Fatal("no raw body")
+			}
+		} else {
+			call := obj.Call("org.godbus.DBus.Bulk.Points", 0, uint32(4096))
+			if call.Err != nil {
+				b.Fatal(call.Err)
+			}
+		}
+	}
+}
+
+func BenchmarkCallLargeStructReply(b *testing.B) {
+	benchmarkLargeStructReply(b, false)
+}
+
+func BenchmarkCallRawLargeStructReply(b *testing.B) {
+	benchmarkLargeStructReply(b, true)
+}
+
+func TestObjectCallStoreChan(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	name := bus.Names()[0]
+	err = bus.Export(bulkServer{}, "/org/godbus/DBus/Bulk", "org.godbus.DBus.Bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := bus.Object(name, "/org/godbus/DBus/Bulk").(*Object)
+	const n = 1000
+	for _, deferred := range []bool{false, true} {
+		var call *Call
+		if deferred {
+			call = obj.CallDeferred(context.Background(), "org.godbus.DBus.Bulk.Points", 0, uint32(n))
+		} else {
+			call = obj.Call("org.godbus.DBus.Bulk.Points", 0, uint32(n))
+		}
+		points := make(chan fixedPoint)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- call.Store((chan<- fixedPoint)(points))
+		}()
+		count := 0
+		for p := range points {
+			if p.X != int32(count) || p.Y != n-int32(count) {
+				t.Errorf("deferred=%v: unexpected point %d: %v", deferred, count, p)
+			}
+			count++
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("deferred=%v: %v", deferred, err)
+		}
+		if count != n {
+			t.Errorf("deferred=%v: received %d points, want %d", deferred, count, n)
+		}
+		if deferred && call.Body != nil {
+			t.Errorf("expected the streamed body not to be kept, got %d values", len(call.Body))
+		}
+	}
+
+	var xs chan int32
+	if err := obj.CallDeferred(context.Background(), "org.godbus.DBus.Bulk.Points", 0, uint32(1)).Store(xs); err == nil {
+		t.Error("expected an error storing into a nil channel")
+	}
+}
+
+func TestObjectCallAllowInteractiveAuthorization(t *testing.T) {
+	flags := make(chan Flags, 1)
+	bus, err := ConnectSessionBus(WithOutgoingInterceptor(func(msg *Message) {
+		if msg.Type == TypeMethodCall && msg.Headers[FieldMember].value == "Ping" {
+			flags <- msg.Flags
+		}
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	err = bus.BusObject().Call("org.freedesktop.DBus.Peer.Ping", FlagAllowInteractiveAuthorization).Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := <-flags; f&FlagAllowInteractiveAuthorization == 0 {
+		t.Errorf("expected FlagAllowInteractiveAuthorization to be sent, got flags %#x", f)
+	}
+}
+
+type notifyServer struct {
+	ch chan string
+}
+
+func (s notifyServer) Notify(msg string) *Error {
+	s.ch <- msg
+	return nil
+}
+
+func TestObjectCallNoReply(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+
+	name := bus.Names()[0]
+	server := notifyServer{make(chan string, 1)}
+	err = bus.Export(server, "/org/godbus/DBus/Notify", "org.godbus.DBus.Notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := bus.Object(name, "/org/godbus/DBus/Notify").(*Object)
+	if err := obj.CallNoReply("org.godbus.DBus.Notify.Notify", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-server.ch:
+		if msg != "hello" {
+			t.Errorf("got %q, want %q", msg, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("method was not called")
+	}
+
+	bus.Close()
+	if err := obj.CallNoReply("org.godbus.DBus.Notify.Notify", "hello"); err != ErrClosed {
+		t.Errorf("expected ErrClosed on a closed connection, got %v", err)
+	}
+}
+
+func BenchmarkCallNoReply(b *testing.B) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bus.Close()
+	obj := bus.BusObject().(*Object)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := obj.CallNoReply("org.freedesktop.DBus.Peer.Ping"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGoNoReply(b *testing.B) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bus.Close()
+	obj := bus.BusObject()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if call := obj.Go("org.freedesktop.DBus.Peer.Ping", FlagNoReplyExpected, nil); call.Err != nil {
+			b.Fatal(call.Err)
+		}
+	}
+}
+
+type propsServer struct {
+	props map[string]Variant
+}
+
+func (s propsServer) GetAll(iface string) (map[string]Variant, *Error) {
+	if iface != "org.godbus.DBus.Props" {
+		return nil, MakeFailedError(errors.New("unknown interface"))
+	}
+	return s.props, nil
+}
+
+func TestObjectStorePropertiesInto(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	name := bus.Names()[0]
+	srv := propsServer{map[string]Variant{
+		"Name":      MakeVariant("foo"),
+		"Count":     MakeVariant(uint32(42)),
+		"Enabled":   MakeVariant(true),
+		"Tags":      MakeVariant([]string{"a", "b"}),
+		"Extra":     MakeVariant(int32(7)),
+		"Ignored":   MakeVariant("x"),
+		"Untouched": MakeVariant("y"),
+	}}
+	err = bus.Export(srv, "/org/godbus/DBus/Props", "org.freedesktop.DBus.Properties")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Name    string
+		Count   uint32
+		Active  bool `dbus:"Enabled"`
+		Tags    []string
+		Extra   Variant
+		Ignored string `dbus:"-"`
+		Missing int32
+	}
+	got.Missing = -1
+	obj := bus.Object(name, "/org/godbus/DBus/Props").(*Object)
+	if err := obj.StorePropertiesInto("org.godbus.DBus.Props", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "foo" || got.Count != 42 || !got.Active {
+		t.Errorf("unexpected basic fields: %+v", got)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"a", "b"}) {
+		t.Errorf("got Tags %v, want [a b]", got.Tags)
+	}
+	if got.Extra.Value() != int32(7) {
+		t.Errorf("got Extra %v, want 7", got.Extra)
+	}
+	if got.Ignored != "" || got.Missing != -1 {
+		t.Errorf("expected skipped fields to be left alone: %+v", got)
+	}
+
+	var wrong struct {
+		Name uint32
+	}
+	if err := obj.StorePropertiesInto("org.godbus.DBus.Props", &wrong); err == nil {
+		t.Error("expected an error storing a string into a uint32 field")
+	}
+	if err := obj.StorePropertiesInto("org.godbus.DBus.Props", got); err == nil {
+		t.Error("expected an error for a non-pointer destination")
+	}
+	if err := obj.StorePropertiesInto("org.godbus.DBus.Unknown", &got); err == nil {
+		t.Error("expected an error for an unknown interface")
+	}
+}
+
+func TestObjectPing(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bus.BusObject().(*Object).Ping(ctx); err != nil {
+		t.Errorf("pinging the bus: %v", err)
+	}
+
+	if err := bus.Export(nopServer{}, "/org/godbus/DBus/Ping", "org.godbus.DBus.Ping"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Object(bus.Names()[0], "/org/godbus/DBus/Ping").(*Object).Ping(ctx); err != nil {
+		t.Errorf("pinging an exported object: %v", err)
+	}
+
+	if err := bus.Object("org.godbus.DBus.NoSuchService", "/").(*Object).Ping(ctx); err == nil {
+		t.Error("expected an error pinging a service that doesn't exist")
+	}
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err := bus.BusObject().(*Object).Ping(cancelled); err != context.Canceled {
+		t.Errorf("got %v with a cancelled context, want %v", err, context.Canceled)
+	}
+}
+
+// slowPropsServer implements org.freedesktop.DBus.Properties but takes a
+// second to answer.
+type slowPropsServer struct{}
+
+func (slowPropsServer) Get(iface, prop string) (Variant, *Error) {
+	time.Sleep(time.Second)
+	return MakeVariant(int32(1)), nil
+}
+
+func (slowPropsServer) Set(iface, prop string, value Variant) *Error {
+	time.Sleep(time.Second)
+	return nil
+}
+
+func TestObjectPropertyContext(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	if err := bus.Export(slowPropsServer{}, "/org/godbus/DBus/SlowProps", "org.freedesktop.DBus.Properties"); err != nil {
+		t.Fatal(err)
+	}
+	obj := bus.Object(bus.Names()[0], "/org/godbus/DBus/SlowProps").(*Object)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := obj.GetPropertyContext(ctx, "org.godbus.DBus.Test.Prop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value() != int32(1) {
+		t.Errorf("got %v, want 1", v)
+	}
+	if err := obj.SetPropertyContext(ctx, "org.godbus.DBus.Test.Prop", int32(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	start := time.Now()
+	if _, err := obj.GetPropertyContext(cancelled, "org.godbus.DBus.Test.Prop"); err != context.Canceled {
+		t.Errorf("GetPropertyContext: got %v with a cancelled context, want %v", err, context.Canceled)
+	}
+	if err := obj.SetPropertyContext(cancelled, "org.godbus.DBus.Test.Prop", int32(2)); err != context.Canceled {
+		t.Errorf("SetPropertyContext: got %v with a cancelled context, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("calls with a cancelled context took %v", d)
+	}
+}
+
+func TestObjectCallWithFlags(t *testing.T) {
+	var out bytes.Buffer
+	conn, err := NewConn(rwc{Reader: strings.NewReader(""), Writer: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	flags := FlagNoReplyExpected | FlagNoAutoStart | FlagAllowInteractiveAuthorization
+	obj := conn.Object("org.godbus.DBus.Flags", "/org/godbus/DBus/Flags").(*Object)
+	// bits that aren't flags of method calls are dropped
+	if err := obj.CallWithFlags("org.godbus.DBus.Flags.Do", flags|0x80).Err; err != nil {
+		t.Fatal(err)
+	}
+	msg, err := DecodeMessage(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Flags != flags {
+		t.Errorf("got flags %#x on the wire, want %#x", msg.Flags, flags)
+	}
+}
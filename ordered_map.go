@@ -0,0 +1,107 @@
+package dbus
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// OrderedMap is a D-Bus dictionary that keeps its entries in the order in
+// which they appear on the wire. Connections created with WithOrderedDicts
+// decode dictionaries to OrderedMap values instead of Go maps, so that proxies
+// can forward them unchanged and clients can see order-sensitive data.
+//
+// An OrderedMap can be sent as an argument or in a Variant, in which case its
+// entries are encoded in order; the types of the keys and values must match
+// its signature. As its signature isn't part of its type, it can only be sent
+// as an element of a Go slice or array, such as the []OrderedMap a dictionary
+// array like aa{sv} decodes to, if the slice holds at least one OrderedMap
+// and all of them have the same signature; use MakeVariantWithSignature to
+// send an empty one. It can't be an element of a Go map. Store accepts an OrderedMap for a map, for
+// a slice of structs with two fields (the key and the value), which preserves
+// the order, and for an OrderedMap.
+type OrderedMap struct {
+	sig     Signature
+	Entries []DictEntry
+}
+
+// DictEntry is an entry of an OrderedMap.
+type DictEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+var orderedMapType = reflect.TypeOf(OrderedMap{})
+
+// NewOrderedMap returns an empty OrderedMap for the dictionary type sig, e.g.
+// "a{sv}". It panics if sig isn't a dictionary type.
+func NewOrderedMap(sig Signature) OrderedMap {
+	if !sig.Single() || len(sig.str) < 4 || sig.str[:2] != "a{" {
+		panic(SignatureError{Sig: sig.str, Reason: "not a dictionary type"})
+	}
+	return OrderedMap{sig: sig}
+}
+
+// Signature returns the signature of m, e.g. "a{sv}".
+func (m OrderedMap) Signature() Signature {
+	return m.sig
+}
+
+// Add appends an entry to m and returns the result, like append.
+func (m OrderedMap) Add(key, value interface{}) OrderedMap {
+	m.Entries = append(m.Entries, DictEntry{key, value})
+	return m
+}
+
+// storeOrderedMap stores the OrderedMap src into dest as described for
+// OrderedMap.
+func storeOrderedMap(dest, src reflect.Value) error {
+	m := src.Interface().(OrderedMap)
+	switch {
+	case dest.Type() == orderedMapType,
+		dest.Kind() == reflect.Interface && dest.NumMethod() == 0:
+		dest.Set(src)
+		return nil
+	case isVariant(dest.Type()):
+		dest.Set(reflect.ValueOf(MakeVariant(m)))
+		return nil
+	case dest.Kind() == reflect.Map:
+		t := dest.Type()
+		out := reflect.MakeMapWithSize(t, len(m.Entries))
+		for _, e := range m.Entries {
+			k := reflect.New(t.Key()).Elem()
+			if err := store(k, reflect.ValueOf(e.Key)); err != nil {
+				return err
+			}
+			v := reflect.New(t.Elem()).Elem()
+			if err := store(v, reflect.ValueOf(e.Value)); err != nil {
+				return err
+			}
+			out.SetMapIndex(k, v)
+		}
+		dest.Set(out)
+		return nil
+	case dest.Kind() == reflect.Slice && dest.Type().Elem().Kind() == reflect.Struct:
+		var fields []int
+		et := dest.Type().Elem()
+		for i := 0; i < et.NumField(); i++ {
+			if f := et.Field(i); f.PkgPath == "" && f.Tag.Get("dbus") != "-" {
+				fields = append(fields, i)
+			}
+		}
+		if len(fields) != 2 {
+			break
+		}
+		out := reflect.MakeSlice(dest.Type(), len(m.Entries), len(m.Entries))
+		for i, e := range m.Entries {
+			if err := store(out.Index(i).Field(fields[0]), reflect.ValueOf(e.Key)); err != nil {
+				return err
+			}
+			if err := store(out.Index(i).Field(fields[1]), reflect.ValueOf(e.Value)); err != nil {
+				return err
+			}
+		}
+		dest.Set(out)
+		return nil
+	}
+	return fmt.Errorf("dbus.Store: type mismatch: cannot convert OrderedMap to %s", dest.Type())
+}
@@ -0,0 +1,151 @@
+package dbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeOrderedDicts(t *testing.T) {
+	m := NewOrderedMap(ParseSignatureMust("a{sv}")).
+		Add("c", MakeVariant(int32(1))).
+		Add("a", MakeVariant(int32(2))).
+		Add("b", MakeVariant(NewOrderedMap(ParseSignatureMust("a{ub}")).Add(uint32(9), true).Add(uint32(1), false)))
+	buf := new(bytes.Buffer)
+	if err := newEncoder(buf, nativeEndian, nil).Encode(m, []OrderedMap{m}); err != nil {
+		t.Fatal(err)
+	}
+	if sig := SignatureOf(m, []map[string]Variant{}); sig.String() != "a{sv}aa{sv}" {
+		t.Fatalf("got signature %s", sig)
+	}
+
+	dec := newDecoder(bytes.NewReader(buf.Bytes()), nativeEndian, nil)
+	dec.orderedDicts = true
+	vs, err := dec.Decode(ParseSignatureMust("a{sv}aa{sv}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := vs[0].(OrderedMap)
+	if !ok {
+		t.Fatalf("got %T, want OrderedMap", vs[0])
+	}
+	var keys []string
+	for _, e := range got.Entries {
+		keys = append(keys, e.Key.(string))
+	}
+	if !reflect.DeepEqual(keys, []string{"c", "a", "b"}) {
+		t.Errorf("got keys %v in this order", keys)
+	}
+	nested := got.Entries[2].Value.(Variant).Value().(OrderedMap)
+	if nested.Signature().String() != "a{ub}" || len(nested.Entries) != 2 || nested.Entries[0].Key != uint32(9) {
+		t.Errorf("got nested dict %+v", nested)
+	}
+	if arr, ok := vs[1].([]OrderedMap); !ok || len(arr) != 1 || len(arr[0].Entries) != 3 {
+		t.Errorf("got %#v for the array of dicts", vs[1])
+	}
+
+	if s := MakeVariant(got).String(); s != `{"c": <1>, "a": <2>, "b": <@a{ub} {9: true, 1: false}>}` {
+		t.Errorf("got %s", s)
+	}
+
+	var pairs []struct {
+		K string
+		V Variant
+	}
+	if err := Store(vs[:1], &pairs); err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 3 || pairs[0].K != "c" || pairs[1].K != "a" || pairs[2].K != "b" {
+		t.Errorf("got %+v", pairs)
+	}
+	var plain []map[string]Variant
+	if err := Store(vs[1:], &plain); err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != 1 || len(plain[0]) != 3 || plain[0]["a"].Value() != int32(2) {
+		t.Errorf("got %v", plain)
+	}
+}
+
+func TestOrderedMapsRoundTrip(t *testing.T) {
+	m := NewOrderedMap(ParseSignatureMust("a{sv}")).
+		Add("b", MakeVariant(int32(1))).
+		Add("a", MakeVariant("x"))
+	body := []interface{}{[]OrderedMap{m, NewOrderedMap(m.Signature())}, [][]OrderedMap{{}, {m}}}
+	buf := new(bytes.Buffer)
+	if err := newEncoder(buf, nativeEndian, nil).Encode(body...); err != nil {
+		t.Fatal(err)
+	}
+	sig := SignatureOf(body...)
+	if sig.String() != "aa{sv}aaa{sv}" {
+		t.Fatalf("got signature %s", sig)
+	}
+
+	dec := newDecoder(bytes.NewReader(buf.Bytes()), nativeEndian, nil)
+	dec.orderedDicts = true
+	vs, err := dec.Decode(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SignatureOf(vs...); got != sig {
+		t.Errorf("got signature %s for the decoded values, want %s", got, sig)
+	}
+	again := new(bytes.Buffer)
+	if err := newEncoder(again, nativeEndian, nil).Encode(vs...); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Errorf("got %x after a round trip, want %x", again.Bytes(), buf.Bytes())
+	}
+	if v := MakeVariant(vs[0]); v.Signature().String() != "aa{sv}" {
+		t.Errorf("got variant signature %s", v.Signature())
+	}
+
+	for _, v := range []interface{}{
+		[]OrderedMap{},
+		[]OrderedMap{m, NewOrderedMap(ParseSignatureMust("a{ss}"))},
+	} {
+		if _, ok := signatureOfValue(v); ok {
+			t.Errorf("expected no signature for %#v", v)
+		}
+	}
+}
+
+func TestOrderedDictsSignal(t *testing.T) {
+	sender, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	receiver, err := ConnectSessionBus(WithOrderedDicts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	if err := receiver.AddMatchSignal(WithMatchInterface("org.godbus.DBus.Ordered")); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *Signal, 1)
+	receiver.Signal(ch)
+
+	m := NewOrderedMap(ParseSignatureMust("a{si}"))
+	for i, k := range []string{"zeta", "alpha", "mu", "beta"} {
+		m = m.Add(k, int32(i))
+	}
+	if err := sender.Emit("/org/godbus/DBus/Ordered", "org.godbus.DBus.Ordered.Changed", m); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sig := <-ch:
+		got, ok := sig.Body[0].(OrderedMap)
+		if !ok {
+			t.Fatalf("got %T, want OrderedMap", sig.Body[0])
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("got %+v, want %+v", got, m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+}
@@ -163,12 +163,16 @@ func (p *Prop) Introspection(name string) introspect.Property {
 	return result
 }
 
-// Change represents a change of a property by a call to Set.
+// Change represents a change of a property by a call to Set. Value is the new
+// value as received in the call, and OldValue is the current value of the
+// property, with the type it was given in the Map. Comparing both allows a
+// Callback to react to specific transitions only.
 type Change struct {
-	Props *Properties
-	Iface string
-	Name  string
-	Value interface{}
+	Props    *Properties
+	Iface    string
+	Name     string
+	Value    interface{}
+	OldValue interface{}
 }
 
 // Properties is a set of values that can be made available to the message bus
@@ -276,14 +280,33 @@ func (p *Properties) Introspection(iface string) []introspect.Property {
 	return s
 }
 
-// set sets the given property and emits PropertyChanged if appropriate. p.mut
-// must already be locked.
+// IntrospectProperties implements dbus.PropertyIntrospector, so that
+// connections created with dbus.WithAutoIntrospection describe the properties
+// of iface.
+func (p *Properties) IntrospectProperties(iface string) []dbus.PropertyInfo {
+	props := p.Introspection(iface)
+	s := make([]dbus.PropertyInfo, len(props))
+	for i, prop := range props {
+		s[i] = dbus.PropertyInfo{Name: prop.Name, Type: prop.Type, Access: prop.Access}
+	}
+	return s
+}
+
+// set sets the given property and emits PropertyChanged if appropriate, i.e.
+// unless the value is unchanged. p.mut must already be locked.
 func (p *Properties) set(iface, property string, v interface{}) error {
 	prop := p.m[iface][property]
-	err := dbus.Store([]interface{}{v}, prop.Value)
+	newv := reflect.New(reflect.TypeOf(prop.Value).Elem())
+	err := dbus.Store([]interface{}{v}, newv.Interface())
 	if err != nil {
 		return err
 	}
+	oldv := reflect.ValueOf(prop.Value).Elem()
+	unchanged := dbus.MakeVariant(oldv.Interface()).Equal(dbus.MakeVariant(newv.Elem().Interface()))
+	oldv.Set(newv.Elem())
+	if unchanged {
+		return nil
+	}
 	return p.emitChange(iface, property)
 }
 
@@ -325,7 +348,8 @@ func (p *Properties) Set(iface, property string, newv dbus.Variant) *dbus.Error
 		return ErrInvalidArg
 	}
 	if prop.Callback != nil {
-		err := prop.Callback(&Change{p, iface, property, newv.Value()})
+		oldv := reflect.ValueOf(prop.Value).Elem().Interface()
+		err := prop.Callback(&Change{p, iface, property, newv.Value(), oldv})
 		if err != nil {
 			return err
 		}
@@ -1,8 +1,11 @@
 package prop
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/godbus/dbus/v5"
 )
@@ -159,3 +162,227 @@ func TestInt32(t *testing.T) {
 		t.Errorf("expected r to be int32(101), but was %#v", r)
 	}
 }
+
+func TestSetUnchangedDoesNotEmit(t *testing.T) {
+	srv, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	cli, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	const iface = "org.guelfey.DBus.Test"
+	propsSpec := map[string]map[string]*Prop{
+		iface: {
+			"strings":     {[]string{"a", "b"}, true, EmitTrue, nil},
+			"invalidates": {uint32(1), true, EmitInvalidates, nil},
+		},
+	}
+	props, err := Export(srv, "/org/guelfey/DBus/Test", propsSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cli.AddMatchSignal(
+		dbus.WithMatchSender(srv.Names()[0]),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	cli.Signal(signals)
+
+	// None of these change a value, so no signal must be emitted for them.
+	if err := props.Set(iface, "strings", dbus.MakeVariant([]string{"a", "b"})); err != nil {
+		t.Fatal(err)
+	}
+	props.SetMust(iface, "strings", []string{"a", "b"})
+	if err := props.Set(iface, "invalidates", dbus.MakeVariant(uint32(1))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := props.Set(iface, "strings", dbus.MakeVariant([]string{"b", "a"})); err != nil {
+		t.Fatal(err)
+	}
+	props.SetMust(iface, "invalidates", uint32(2))
+
+	for _, want := range []string{"strings", "invalidates"} {
+		var sig *dbus.Signal
+		for sig == nil || sig.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" {
+			select {
+			case sig = <-signals:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for PropertiesChanged")
+			}
+		}
+		var gotIface string
+		var changed map[string]dbus.Variant
+		var invalidated []string
+		if err := dbus.Store(sig.Body, &gotIface, &changed, &invalidated); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := changed[want]; !ok && (len(invalidated) != 1 || invalidated[0] != want) {
+			t.Fatalf("expected a change of %s, got %v %v", want, changed, invalidated)
+		}
+	}
+	if got := props.GetMust(iface, "strings"); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("got %v, want [b a]", got)
+	}
+}
+
+func TestCallbackOldValue(t *testing.T) {
+	srv, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	cli, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	const iface = "org.guelfey.DBus.Test"
+	var changes []Change
+	propsSpec := map[string]map[string]*Prop{
+		iface: {
+			"state": {"idle", true, EmitTrue, func(c *Change) *dbus.Error {
+				changes = append(changes, *c)
+				if c.OldValue == "stopped" {
+					return dbus.MakeFailedError(errors.New("already stopped"))
+				}
+				return nil
+			}},
+		},
+	}
+	if _, err := Export(srv, "/org/guelfey/DBus/Test", propsSpec); err != nil {
+		t.Fatal(err)
+	}
+	// properties are described for the interfaces exported on the object
+	if err := srv.Export(pinger{}, "/org/guelfey/DBus/Test", "org.guelfey.DBus.Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := cli.Object(srv.Names()[0], "/org/guelfey/DBus/Test")
+	for _, v := range []string{"running", "stopped"} {
+		if err := obj.SetProperty(iface+".state", dbus.MakeVariant(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := obj.SetProperty(iface+".state", dbus.MakeVariant("idle")); err == nil {
+		t.Error("expected the callback to reject leaving the stopped state")
+	}
+	comparePropValue(obj, "state", "stopped", t)
+
+	want := [][2]string{{"idle", "running"}, {"running", "stopped"}, {"stopped", "idle"}}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for i, c := range changes {
+		if c.OldValue != want[i][0] || c.Value != want[i][1] {
+			t.Errorf("change %d: got %v -> %v, want %s -> %s", i, c.OldValue, c.Value, want[i][0], want[i][1])
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	cli, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	const iface = "org.guelfey.DBus.Test"
+	propsSpec := map[string]map[string]*Prop{
+		iface: {
+			"ReadOnly": {int32(1), false, EmitTrue, nil},
+		},
+	}
+	if _, err := Export(srv, "/org/guelfey/DBus/Test", propsSpec); err != nil {
+		t.Fatal(err)
+	}
+	// properties are described for the interfaces exported on the object
+	if err := srv.Export(pinger{}, "/org/guelfey/DBus/Test", "org.guelfey.DBus.Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := cli.Object(srv.Names()[0], "/org/guelfey/DBus/Test")
+	_, err = obj.GetProperty(iface + ".Missing")
+	if !errors.Is(err, dbus.ErrPropNotFound) {
+		t.Errorf("getting a missing property: got %v, want %v", err, dbus.ErrPropNotFound)
+	}
+	if !errors.Is(err, ErrPropNotFound) {
+		t.Errorf("getting a missing property: got %v, want %v", err, ErrPropNotFound)
+	}
+	_, err = obj.GetProperty("org.guelfey.DBus.Missing.Prop")
+	if !errors.Is(err, dbus.ErrIfaceNotFound) {
+		t.Errorf("getting a property of a missing interface: got %v, want %v", err, dbus.ErrIfaceNotFound)
+	}
+	err = obj.SetProperty(iface+".ReadOnly", int32(2))
+	if !errors.Is(err, dbus.ErrReadOnly) {
+		t.Errorf("setting a read-only property: got %v, want %v", err, dbus.ErrReadOnly)
+	}
+	if errors.Is(err, dbus.ErrPropNotFound) {
+		t.Errorf("setting a read-only property: %v matches %v", err, dbus.ErrPropNotFound)
+	}
+}
+
+type pinger struct{}
+
+func (pinger) Ping() *dbus.Error {
+	return nil
+}
+
+func TestAutoIntrospection(t *testing.T) {
+	srv, err := dbus.ConnectSessionBus(dbus.WithAutoIntrospection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	cli, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	propsSpec := map[string]map[string]*Prop{
+		"org.guelfey.DBus.Test": {
+			"int32": {Value: int32(100), Writable: true, Emit: EmitTrue},
+			"name":  {Value: "foo", Emit: EmitFalse},
+		},
+	}
+	if _, err := Export(srv, "/org/guelfey/DBus/Test", propsSpec); err != nil {
+		t.Fatal(err)
+	}
+	// properties are described for the interfaces exported on the object
+	if err := srv.Export(pinger{}, "/org/guelfey/DBus/Test", "org.guelfey.DBus.Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var xml string
+	obj := cli.Object(srv.Names()[0], "/org/guelfey/DBus/Test")
+	if err := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<property name="int32" type="i" access="readwrite"/>`,
+		`<property name="name" type="s" access="read"/>`,
+	} {
+		if !strings.Contains(xml, s) {
+			t.Errorf("introspection data lacks %s: %s", s, xml)
+		}
+	}
+}
@@ -163,6 +163,38 @@ func TestProtoMap(t *testing.T) {
 	}
 }
 
+func TestProtoBoolFalse(t *testing.T) {
+	type Flags struct {
+		A bool
+		B bool
+	}
+	in := struct {
+		S Flags
+		M map[string]bool
+	}{Flags{true, false}, map[string]bool{"on": true, "off": false}}
+	var out struct {
+		S Flags
+		M map[string]bool
+	}
+	buf := new(bytes.Buffer)
+	fds := make([]int, 0)
+	enc := newEncoder(buf, binary.LittleEndian, fds)
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	dec := newDecoder(buf, binary.LittleEndian, enc.fds)
+	vs, err := dec.Decode(Signature{"((bb)a{sb})"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Store(vs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %v, want %v", out, in)
+	}
+}
+
 func TestProtoVariantStruct(t *testing.T) {
 	var variant Variant
 	v := MakeVariant(struct {
@@ -193,6 +225,52 @@ func TestProtoVariantStruct(t *testing.T) {
 	}
 }
 
+func TestStoreVariantStruct(t *testing.T) {
+	type inner struct {
+		A int32
+		B string
+	}
+	type outer struct {
+		X int32
+		I inner
+		V Variant
+	}
+	buf := new(bytes.Buffer)
+	enc := newEncoder(buf, binary.LittleEndian, nil)
+	if err := enc.Encode(MakeVariant(outer{1, inner{2, "two"}, MakeVariant(inner{3, "three"})})); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := newDecoder(buf, binary.LittleEndian, nil).Decode(Signature{"v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		X int32
+		I inner
+		V inner
+	}
+	if err := Store(vs, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.X != 1 || got.I != (inner{2, "two"}) || got.V != (inner{3, "three"}) {
+		t.Errorf("got %+v", got)
+	}
+
+	var in inner
+	if err := vs[0].(Variant).Value().([]interface{})[2].(Variant).Store(&in); err != nil {
+		t.Fatal(err)
+	}
+	if in != (inner{3, "three"}) {
+		t.Errorf("got %+v from Variant.Store", in)
+	}
+
+	var short struct{ X int32 }
+	if err := Store(vs, &short); err == nil {
+		t.Error("expected an error storing into a struct with too few fields")
+	}
+}
+
 func TestProtoStructTag(t *testing.T) {
 	type Bar struct {
 		A int32
@@ -327,6 +405,7 @@ var bigMessage = &Message{
 }
 
 func BenchmarkDecodeMessageSmall(b *testing.B) {
+	b.ReportAllocs()
 	var err error
 	var rd *bytes.Reader
 
@@ -348,6 +427,7 @@ func BenchmarkDecodeMessageSmall(b *testing.B) {
 }
 
 func BenchmarkDecodeMessageBig(b *testing.B) {
+	b.ReportAllocs()
 	var err error
 	var rd *bytes.Reader
 
@@ -370,6 +450,7 @@ func BenchmarkDecodeMessageBig(b *testing.B) {
 
 func BenchmarkEncodeMessageSmall(b *testing.B) {
 	var err error
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		err = smallMessage.EncodeTo(io.Discard, binary.LittleEndian)
 		if err != nil {
@@ -378,6 +459,174 @@ func BenchmarkEncodeMessageSmall(b *testing.B) {
 	}
 }
 
+func BenchmarkAppendMessageSmall(b *testing.B) {
+	var buf []byte
+	var err error
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _, err = smallMessage.AppendTo(buf[:0], binary.LittleEndian)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMessageAppendTo(t *testing.T) {
+	for _, msg := range []*Message{smallMessage, bigMessage} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			want := new(bytes.Buffer)
+			if err := msg.EncodeTo(want, order); err != nil {
+				t.Fatal(err)
+			}
+			prefix := []byte("prefix")
+			got, _, err := msg.AppendTo(prefix, order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// header fields are encoded in map order, so only compare lengths
+			if !bytes.Equal(got[:len(prefix)], []byte("prefix")) || len(got)-len(prefix) != want.Len() {
+				t.Errorf("AppendTo differs from EncodeTo for %v", msg)
+			}
+			decoded, err := DecodeMessage(bytes.NewReader(got[len(prefix):]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded.Body, msg.Body) {
+				t.Errorf("got body %v, want %v", decoded.Body, msg.Body)
+			}
+		}
+	}
+	if _, _, err := (&Message{}).AppendTo(nil, binary.LittleEndian); err == nil {
+		t.Error("expected an error for an invalid message")
+	}
+}
+
+// newLargeBodyMessage returns a signal whose body is a byte array of n bytes
+// followed by a nested array, to exercise both the byte and the generic path
+// of the encoder.
+func newLargeBodyMessage(n int) *Message {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	nested := []struct {
+		S string
+		A []int32
+	}{{"a", []int32{1, 2}}, {"b", nil}, {"c", []int32{3}}}
+	return &Message{
+		Type:   TypeSignal,
+		serial: 3,
+		Headers: map[HeaderField]Variant{
+			FieldPath:      MakeVariant(ObjectPath("/org/godbus/DBus/Test")),
+			FieldInterface: MakeVariant("org.godbus.DBus.Test"),
+			FieldMember:    MakeVariant("Data"),
+			FieldSignature: MakeVariant(SignatureOf(data, nested)),
+		},
+		Body: []interface{}{data, nested},
+	}
+}
+
+func TestMessageStreamTo(t *testing.T) {
+	deep := newLargeBodyMessage(1 << 10)
+	deep.Body = append(deep.Body, nestedArrays(21))
+	for _, msg := range []*Message{smallMessage, bigMessage, newLargeBodyMessage(1 << 20), deep} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			want := new(bytes.Buffer)
+			if err := msg.EncodeTo(want, order); err != nil {
+				t.Fatal(err)
+			}
+			got := new(bytes.Buffer)
+			if _, err := msg.StreamTo(got, order); err != nil {
+				t.Fatal(err)
+			}
+			// header fields are encoded in map order, so only compare lengths
+			if got.Len() != want.Len() {
+				t.Errorf("StreamTo wrote %d bytes, EncodeTo %d", got.Len(), want.Len())
+			}
+			decoded, err := DecodeMessage(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantDecoded, err := DecodeMessage(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded.Body, wantDecoded.Body) {
+				t.Errorf("StreamTo and EncodeTo differ in the body of %v", msg)
+			}
+		}
+	}
+
+	invalid := newLargeBodyMessage(1 << 10)
+	invalid.Body = append(invalid.Body, make(chan int))
+	out := new(bytes.Buffer)
+	if _, err := invalid.StreamTo(out, binary.LittleEndian); err == nil || out.Len() != 0 {
+		t.Errorf("got %v after writing %d bytes, want an error before writing", err, out.Len())
+	}
+}
+
+func TestMessageEncodedSize(t *testing.T) {
+	raw, err := DecodeMessageRaw(bytes.NewReader(mustEncode(t, bigMessage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(mustEncode(t, bigMessage)); raw.wireSize != n {
+		t.Errorf("got wire size %d for a message of %d bytes", raw.wireSize, n)
+	}
+	for _, msg := range []*Message{smallMessage, bigMessage, newLargeBodyMessage(1 << 16), raw} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			size, err := msg.EncodedSize(order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := new(bytes.Buffer)
+			if err := msg.EncodeTo(buf, order); err != nil {
+				t.Fatal(err)
+			}
+			if size != buf.Len() {
+				t.Errorf("got size %d for %v, encoded %d bytes", size, msg, buf.Len())
+			}
+		}
+	}
+	if _, err := (&Message{}).EncodedSize(binary.LittleEndian); err == nil {
+		t.Error("expected an error for an invalid message")
+	}
+}
+
+func mustEncode(t *testing.T, msg *Message) []byte {
+	buf := new(bytes.Buffer)
+	if err := msg.EncodeTo(buf, binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func benchmarkEncodeLargeBody(b *testing.B, stream bool) {
+	msg := newLargeBodyMessage(1 << 22)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if stream {
+			_, err = msg.StreamTo(io.Discard, binary.LittleEndian)
+		} else {
+			_, err = msg.EncodeToWithFDs(io.Discard, binary.LittleEndian)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncodeMessageLargeBody and BenchmarkStreamMessageLargeBody compare
+// the memory needed for encoding a message with a 4 MiB body.
+func BenchmarkEncodeMessageLargeBody(b *testing.B) {
+	benchmarkEncodeLargeBody(b, false)
+}
+
+func BenchmarkStreamMessageLargeBody(b *testing.B) {
+	benchmarkEncodeLargeBody(b, true)
+}
+
 func BenchmarkEncodeMessageBig(b *testing.B) {
 	var err error
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,53 @@
+package dbus
+
+import "context"
+
+// Proxy binds an object and one of its interfaces, so that methods and
+// properties of the interface can be accessed by their member names alone.
+type Proxy struct {
+	obj   BusObject
+	iface string
+}
+
+// Proxy returns a Proxy for the interface iface of the object identified by
+// the given destination name and path.
+func (conn *Conn) Proxy(dest string, path ObjectPath, iface string) *Proxy {
+	return &Proxy{conn.Object(dest, path), iface}
+}
+
+// Object returns the object the proxy refers to.
+func (p *Proxy) Object() BusObject {
+	return p.obj
+}
+
+// Interface returns the interface the proxy refers to.
+func (p *Proxy) Interface() string {
+	return p.iface
+}
+
+// Call calls the given method of the proxy's interface and waits for the
+// reply, like Object.Call without flags.
+func (p *Proxy) Call(member string, args ...interface{}) *Call {
+	return p.obj.Call(p.iface+"."+member, 0, args...)
+}
+
+// CallWithContext acts like Call but takes a context.
+func (p *Proxy) CallWithContext(ctx context.Context, member string, args ...interface{}) *Call {
+	return p.obj.CallWithContext(ctx, p.iface+"."+member, 0, args...)
+}
+
+// Get returns the value of the given property of the proxy's interface.
+func (p *Proxy) Get(name string) (Variant, error) {
+	return p.obj.GetProperty(p.iface + "." + name)
+}
+
+// Store stores the value of the given property of the proxy's interface into
+// the provided value, like Object.StoreProperty.
+func (p *Proxy) Store(name string, value interface{}) error {
+	return p.obj.StoreProperty(p.iface+"."+name, value)
+}
+
+// Set sets the given property of the proxy's interface to v.
+func (p *Proxy) Set(name string, v interface{}) error {
+	return p.obj.SetProperty(p.iface+"."+name, v)
+}
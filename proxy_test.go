@@ -0,0 +1,88 @@
+package dbus
+
+import (
+	"sync"
+	"testing"
+)
+
+type proxyServer struct {
+	mu    *sync.Mutex
+	props map[string]Variant
+}
+
+func (s proxyServer) Double(i int64) (int64, *Error) {
+	return 2 * i, nil
+}
+
+func (s proxyServer) Get(iface, name string) (Variant, *Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.props[iface+"."+name]
+	if !ok {
+		return Variant{}, NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{name})
+	}
+	return v, nil
+}
+
+func (s proxyServer) Set(iface, name string, v Variant) *Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.props[iface+"."+name] = v
+	return nil
+}
+
+func TestProxy(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to session bus: %s", err)
+	}
+	defer bus.Close()
+
+	const path = "/org/godbus/DBus/Proxy"
+	srv := proxyServer{&sync.Mutex{}, map[string]Variant{
+		"org.godbus.DBus.Proxy.Name": MakeVariant("foo"),
+	}}
+	if err := bus.Export(srv, path, "org.godbus.DBus.Proxy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Export(srv, path, "org.freedesktop.DBus.Properties"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := bus.Proxy(bus.Names()[0], path, "org.godbus.DBus.Proxy")
+	if p.Interface() != "org.godbus.DBus.Proxy" || p.Object().Path() != path {
+		t.Errorf("unexpected proxy target %s %s", p.Object().Path(), p.Interface())
+	}
+
+	var n int64
+	if err := p.Call("Double", int64(21)).Store(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 42 {
+		t.Errorf("got %d, want 42", n)
+	}
+	if err := p.Call("Missing").Err; err == nil {
+		t.Error("expected an error calling a missing method")
+	}
+
+	v, err := p.Get("Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value() != "foo" {
+		t.Errorf("got %v, want \"foo\"", v)
+	}
+	if err := p.Set("Name", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := p.Store("Name", &name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "bar" {
+		t.Errorf("got %q after Set, want \"bar\"", name)
+	}
+	if _, err := p.Get("Missing"); err == nil {
+		t.Error("expected an error getting a missing property")
+	}
+}
@@ -51,6 +51,24 @@ type Method interface {
 	ReturnValue(position int) interface{}
 }
 
+// PropertyIntrospector can be implemented by the value exported as
+// org.freedesktop.DBus.Properties to have its properties described in the
+// introspection data generated for WithAutoIntrospection. The Properties type
+// of the prop package implements it.
+type PropertyIntrospector interface {
+	// IntrospectProperties returns the properties of the interface iface.
+	IntrospectProperties(iface string) []PropertyInfo
+}
+
+// PropertyInfo describes a property for PropertyIntrospector.
+type PropertyInfo struct {
+	Name string
+	// Type is the signature of the property's value.
+	Type string
+	// Access is "read", "write" or "readwrite".
+	Access string
+}
+
 // An Argument Decoder can decode arguments using the non-standard mechanism
 //
 // If a method implements this interface then the non-standard
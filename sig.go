@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 var sigToType = map[byte]reflect.Type{
@@ -32,17 +33,85 @@ type Signature struct {
 // SignatureOf returns the concatenation of all the signatures of the given
 // values. It panics if one of them is not representable in D-Bus.
 func SignatureOf(vs ...interface{}) Signature {
+	if len(vs) == 1 {
+		return Signature{valueSignature(vs[0])}
+	}
 	var s string
 	for _, v := range vs {
-		s += getSignature(reflect.TypeOf(v), &depthCounter{})
+		s += valueSignature(v)
 	}
 	return Signature{s}
 }
 
+// valueSignature returns the signature of v. The signatures of OrderedMaps
+// and of (nested) slices and arrays of them are taken from the maps, as they
+// aren't part of their types.
+func valueSignature(v interface{}) string {
+	if m, ok := v.(OrderedMap); ok {
+		return m.sig.str
+	}
+	t := reflect.TypeOf(v)
+	if t != nil && holdsOrderedMaps(t) {
+		return orderedMapsSignature(reflect.ValueOf(v))
+	}
+	return signatureOfType(t)
+}
+
+// holdsOrderedMaps returns whether t is a slice or array of OrderedMaps,
+// possibly nested in further slices or arrays.
+func holdsOrderedMaps(t reflect.Type) bool {
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t == orderedMapType
+}
+
+// orderedMapsSignature returns the signature of v, for which holdsOrderedMaps
+// is true. All the maps must have the same signature, and there must be at
+// least one of them.
+func orderedMapsSignature(v reflect.Value) string {
+	var sig string
+	for i := 0; i < v.Len(); i++ {
+		var s string
+		if e := v.Index(i); e.Type() == orderedMapType {
+			s = e.Interface().(OrderedMap).sig.str
+		} else if e.Len() > 0 {
+			s = orderedMapsSignature(e)
+		}
+		if s == "" {
+			continue
+		}
+		if sig != "" && s != sig {
+			panic(SignatureError{Sig: s, Reason: "differs from the signature " + sig + " of another OrderedMap in the same array"})
+		}
+		sig = s
+	}
+	if sig == "" {
+		// the signature is only known from the maps
+		panic(InvalidTypeError{Type: v.Type()})
+	}
+	return "a" + sig
+}
+
 // SignatureOfType returns the signature of the given type. It panics if the
 // type is not representable in D-Bus.
 func SignatureOfType(t reflect.Type) Signature {
-	return Signature{getSignature(t, &depthCounter{})}
+	return Signature{signatureOfType(t)}
+}
+
+// signatureCache maps types to their signatures. As the signature of a type
+// never changes, entries are never removed.
+var signatureCache sync.Map
+
+// signatureOfType returns the signature of t like getSignature, but only walks
+// each type once.
+func signatureOfType(t reflect.Type) string {
+	if sig, ok := signatureCache.Load(t); ok {
+		return sig.(string)
+	}
+	sig := getSignature(t, &depthCounter{})
+	signatureCache.Store(t, sig)
+	return sig
 }
 
 // getSignature returns the signature of the given type and panics on unknown types.
@@ -93,6 +162,9 @@ func getSignature(t reflect.Type, depth *depthCounter) (sig string) {
 			return "v"
 		} else if t == signatureType {
 			return "g"
+		} else if t == orderedMapType {
+			// the signature is only known from the value
+			panic(InvalidTypeError{Type: t})
 		}
 		var s string
 		for i := 0; i < t.NumField(); i++ {
@@ -102,20 +174,20 @@ func getSignature(t reflect.Type, depth *depthCounter) (sig string) {
 			}
 		}
 		if len(s) == 0 {
-			panic(InvalidTypeError{t})
+			panic(InvalidTypeError{Type: t})
 		}
 		return "(" + s + ")"
 	case reflect.Array, reflect.Slice:
 		return "a" + getSignature(t.Elem(), depth.EnterArray())
 	case reflect.Map:
 		if !isKeyType(t.Key()) {
-			panic(InvalidTypeError{t})
+			panic(InvalidTypeError{Type: t})
 		}
 		return "a{" + getSignature(t.Key(), depth.EnterArray().EnterDictEntry()) + getSignature(t.Elem(), depth.EnterArray().EnterDictEntry()) + "}"
 	case reflect.Interface:
 		return "v"
 	}
-	panic(InvalidTypeError{t})
+	panic(InvalidTypeError{Type: t})
 }
 
 // ParseSignature returns the signature represented by this string, or a
@@ -148,6 +220,30 @@ func ParseSignatureMust(s string) Signature {
 	return sig
 }
 
+// ValidSignature returns whether s is a valid signature, i.e. a possibly empty
+// sequence of complete types. Unlike ParseSignature, it doesn't construct an
+// error for invalid input.
+func ValidSignature(s string) bool {
+	if len(s) > 255 {
+		return false
+	}
+	var err error
+	for err == nil && len(s) != 0 {
+		err, s = validSingle(s, &depthCounter{})
+	}
+	return err == nil
+}
+
+// ValidSingleSignature returns whether s is a valid signature consisting of
+// exactly one complete type, as required e.g. for the contents of a Variant.
+func ValidSingleSignature(s string) bool {
+	if len(s) > 255 {
+		return false
+	}
+	err, rem := validSingle(s, &depthCounter{})
+	return err == nil && rem == ""
+}
+
 // Empty returns whether the signature is the empty signature.
 func (s Signature) Empty() bool {
 	return s.str == ""
@@ -156,7 +252,7 @@ func (s Signature) Empty() bool {
 // Single returns whether the signature represents a single, complete type.
 func (s Signature) Single() bool {
 	err, r := validSingle(s.str, &depthCounter{})
-	return err != nil && r == ""
+	return err == nil && r == ""
 }
 
 // String returns the signature's string representation.
@@ -164,6 +260,12 @@ func (s Signature) String() string {
 	return s.str
 }
 
+// MarshalText returns the signature's string representation, so that
+// signatures are encoded as plain strings, e.g. in JSON.
+func (s Signature) MarshalText() ([]byte, error) {
+	return []byte(s.str), nil
+}
+
 // A SignatureError indicates that a signature passed to a function or received
 // on a connection is not a valid signature.
 type SignatureError struct {
@@ -1,6 +1,8 @@
 package dbus
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -36,6 +38,10 @@ var sigTests = []struct {
 		[]interface{}{new(Variant), new([]map[int32]string)},
 		Signature{"vaa{is}"},
 	},
+	{
+		[]interface{}{new(UnixFD), new([]UnixFDIndex)},
+		Signature{"hah"},
+	},
 }
 
 func TestSig(t *testing.T) {
@@ -47,6 +53,60 @@ func TestSig(t *testing.T) {
 	}
 }
 
+func TestSignatureSingle(t *testing.T) {
+	for sig, want := range map[string]bool{
+		"i":      true,
+		"h":      true,
+		"a{hv}":  true,
+		"a{sv}":  true,
+		"(isv)":  true,
+		"ii":     false,
+		"":       false,
+		"a":      false,
+		"a{sv}i": false,
+	} {
+		if got := (Signature{sig}).Single(); got != want {
+			t.Errorf("Signature{%q}.Single() = %v, want %v", sig, got, want)
+		}
+	}
+}
+
+func TestValidSignature(t *testing.T) {
+	for _, tc := range []struct {
+		sig    string
+		valid  bool
+		single bool
+	}{
+		{"", true, false},
+		{"i", true, true},
+		{"a{sv}", true, true},
+		{"(ia(ss)v)", true, true},
+		{"aai", true, true},
+		{"h", true, true},
+		{"a(sh)", true, true},
+		{"hh", true, false},
+		{"ii", true, false},
+		{"sa{sv}as", true, false},
+		{"a", false, false},
+		{"a{s}", false, false},
+		{"{sv}", false, false},
+		{"(i", false, false},
+		{"z", false, false},
+		{strings.Repeat("a", 33) + "i", false, false},
+		{strings.Repeat("i", 256), false, false},
+	} {
+		if got := ValidSignature(tc.sig); got != tc.valid {
+			t.Errorf("ValidSignature(%q) = %v, want %v", tc.sig, got, tc.valid)
+		}
+		if got := ValidSingleSignature(tc.sig); got != tc.single {
+			t.Errorf("ValidSingleSignature(%q) = %v, want %v", tc.sig, got, tc.single)
+		}
+		if _, err := ParseSignature(tc.sig); (err == nil) != tc.valid {
+			t.Errorf("ParseSignature(%q) returned %v, want valid = %v", tc.sig, err, tc.valid)
+		}
+	}
+}
+
 var getSigTest = []interface{}{
 	[]struct {
 		B byte
@@ -68,3 +128,35 @@ func BenchmarkGetSignatureLong(b *testing.B) {
 		SignatureOf(getSigTest...)
 	}
 }
+
+type sigManyFields struct {
+	A, B, C, D int32
+	E, F, G, H string
+	I, J       uint64
+	M          map[string]Variant
+	N          []struct{ X, Y float64 }
+	O, P       ObjectPath
+	T          []byte
+	U, V       bool
+	Z          map[uint32][]string
+	Nested     struct {
+		A int16
+		B []Variant
+	}
+}
+
+func BenchmarkGetSignatureManyFields(b *testing.B) {
+	v := sigManyFields{}
+	for i := 0; i < b.N; i++ {
+		SignatureOf(v)
+	}
+}
+
+// BenchmarkGetSignatureManyFieldsUncached is the baseline for
+// BenchmarkGetSignatureManyFields without the signature cache.
+func BenchmarkGetSignatureManyFieldsUncached(b *testing.B) {
+	t := reflect.TypeOf(sigManyFields{})
+	for i := 0; i < b.N; i++ {
+		getSignature(t, &depthCounter{})
+	}
+}
@@ -1,7 +1,10 @@
 package dbus
 
 import (
+	"bytes"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -113,3 +116,189 @@ func TestStoreSmallerSliceToLargerSlice(t *testing.T) {
 		t.Fatal("Wrong element saved in dest slice")
 	}
 }
+
+func TestStoreNumberConversion(t *testing.T) {
+	var i int
+	var i64 int64
+	var i16 int16
+	var u8 byte
+	var u64 uint64
+	var f float64
+	if err := Store([]interface{}{uint32(42), uint32(42), int64(-3), int32(7)}, &i, &i64, &i16, &u8); err != nil {
+		t.Fatal(err)
+	}
+	if i != 42 || i64 != 42 || i16 != -3 || u8 != 7 {
+		t.Errorf("got %d %d %d %d", i, i64, i16, u8)
+	}
+	if err := Store([]interface{}{MakeVariant(int32(5)), float64(8), uint16(9)}, &u64, &i, &f); err != nil {
+		t.Fatal(err)
+	}
+	if u64 != 5 || i != 8 || f != 9 {
+		t.Errorf("got %d %d %v", u64, i, f)
+	}
+
+	var ints []int
+	if err := Store([]interface{}{[]uint32{1, 2, 3}}, &ints); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ints, []int{1, 2, 3}) {
+		t.Errorf("got %v", ints)
+	}
+
+	for _, tc := range []struct {
+		src  interface{}
+		dest interface{}
+	}{
+		{uint32(1 << 31), new(int32)},
+		{int64(-1), new(uint32)},
+		{int32(-1), new(uint64)},
+		{uint64(1 << 63), new(int64)},
+		{int32(256), new(byte)},
+		{int64(1 << 40), new(int32)},
+		{float64(1.5), new(int)},
+		{float64(-1), new(uint)},
+		{float64(1e20), new(int64)},
+		{float64(1e300), new(float32)},
+		{[]int64{1, -1}, new([]uint32)},
+	} {
+		if err := Store([]interface{}{tc.src}, tc.dest); err == nil {
+			t.Errorf("storing %v (%T) into %T: expected an error", tc.src, tc.src, tc.dest)
+		}
+	}
+}
+
+type structToMapServer struct{}
+
+type structToMapReply struct {
+	Name  string
+	Flags uint32
+	Tags  []string
+}
+
+func (structToMapServer) Info() (structToMapReply, *Error) {
+	return structToMapReply{"foo", 3, []string{"a"}}, nil
+}
+
+func TestStructToMap(t *testing.T) {
+	bus, err := ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	if err := bus.Export(structToMapServer{}, "/org/godbus/DBus/StructToMap", "org.godbus.DBus.StructToMap"); err != nil {
+		t.Fatal(err)
+	}
+	call := bus.Object(bus.Names()[0], "/org/godbus/DBus/StructToMap").Call("org.godbus.DBus.StructToMap.Info", 0)
+	if call.Err != nil {
+		t.Fatal(call.Err)
+	}
+
+	m, err := StructToMap(call.Body[0], "name", "", "tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "foo", "1": uint32(3), "tags": []string{"a"}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+
+	m, err = StructToMap(structToMapReply{"bar", 1, nil}, "", "flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"Name": "bar", "flags": uint32(1), "Tags": []string(nil)}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+
+	if _, err := StructToMap("foo"); err == nil {
+		t.Error("expected an error for a non-struct value")
+	}
+	if _, err := StructToMap(nil); err == nil {
+		t.Error("expected an error for nil")
+	}
+	if _, err := StructToMap([]interface{}{1, 2}, "a", "a"); err == nil {
+		t.Error("expected an error for duplicate names")
+	}
+}
+
+type shape interface {
+	area() float64
+}
+
+type circle struct {
+	Radius float64
+}
+
+func (c circle) area() float64 { return 3 * c.Radius * c.Radius }
+
+type rect struct {
+	Width  float64 `dbus:"W"`
+	Height float64 `dbus:"H"`
+}
+
+func (r *rect) area() float64 { return r.Width * r.Height }
+
+func TestStoreTypeResolver(t *testing.T) {
+	shapeType := reflect.TypeOf((*shape)(nil)).Elem()
+	RegisterTypeResolver(shapeType, func(src interface{}) (reflect.Type, error) {
+		props, ok := src.(map[string]Variant)
+		if !ok {
+			return nil, fmt.Errorf("unexpected shape %T", src)
+		}
+		kind, _ := props["Kind"].Value().(string)
+		switch kind {
+		case "circle":
+			return reflect.TypeOf(circle{}), nil
+		case "rect":
+			return reflect.TypeOf(&rect{}), nil
+		}
+		return nil, fmt.Errorf("unknown kind of shape %q", kind)
+	})
+	defer RegisterTypeResolver(shapeType, nil)
+
+	msg := &Message{
+		Type: TypeMethodReply,
+		Headers: map[HeaderField]Variant{
+			FieldReplySerial: MakeVariant(uint32(1)),
+			FieldSignature:   MakeVariant(SignatureOf([]map[string]Variant{})),
+		},
+		Body: []interface{}{[]map[string]Variant{
+			{"Kind": MakeVariant("circle"), "Radius": MakeVariant(2.0)},
+			{"Kind": MakeVariant("rect"), "W": MakeVariant(2.0), "H": MakeVariant(3.0)},
+		}},
+		serial: 2,
+	}
+	buf := new(bytes.Buffer)
+	if err := msg.EncodeTo(buf, nativeEndian); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var shapes []shape
+	if err := Store(decoded.Body, &shapes); err != nil {
+		t.Fatal(err)
+	}
+	want := []shape{circle{2}, &rect{2, 3}}
+	if !reflect.DeepEqual(shapes, want) {
+		t.Errorf("got %#v, want %#v", shapes, want)
+	}
+
+	var single shape
+	err = Store([]interface{}{map[string]Variant{"Kind": MakeVariant("square")}}, &single)
+	if err == nil || !strings.Contains(err.Error(), "square") {
+		t.Errorf("expected the resolver's error, got %v", err)
+	}
+
+	// other interface destinations are not affected
+	var any interface{}
+	if err := Store([]interface{}{map[string]Variant{"Kind": MakeVariant("circle")}}, &any); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := any.(map[string]interface{}); !ok {
+		t.Errorf("got %T for an interface{} destination", any)
+	}
+}
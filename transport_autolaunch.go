@@ -0,0 +1,79 @@
+package dbus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	transports["autolaunch"] = newAutolaunchTransport
+}
+
+// newAutolaunchTransport connects to the session bus of the current user,
+// looking for a running one first and starting one with dbus-launch (or
+// asking launchd on macOS) if there is none.
+func newAutolaunchTransport(keys string) (transport, error) {
+	address, err := resolveAutolaunchAddress(keys)
+	if err != nil {
+		return nil, err
+	}
+	t, _, err := getTransport(address)
+	return t, err
+}
+
+// isAutolaunchAddress returns whether address is an autolaunch: address.
+func isAutolaunchAddress(address string) bool {
+	return strings.HasPrefix(address, "autolaunch:")
+}
+
+// parseAutolaunchKeys checks the keys of an autolaunch: address and returns
+// the value of its scope key. The scope selects between several session buses
+// in the Windows implementation of the reference library only, so it is
+// accepted but not used, as the reference library does on other systems.
+func parseAutolaunchKeys(keys string) (scope string, err error) {
+	if keys == "" {
+		return "", nil
+	}
+	for _, keyValue := range strings.Split(keys, ",") {
+		key, value, ok := strings.Cut(keyValue, "=")
+		if !ok || key == "" {
+			return "", fmt.Errorf("dbus: invalid autolaunch address (malformed key %q)", keyValue)
+		}
+		value, err = UnescapeBusAddressValue(value)
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case "scope":
+			scope = value
+		case "guid":
+		default:
+			return "", fmt.Errorf("dbus: invalid autolaunch address (unknown key %q)", key)
+		}
+	}
+	return scope, nil
+}
+
+// resolveAutolaunchAddress returns the address of the session bus for the
+// autolaunch: address with the given keys. If that fails, the error lists
+// what was attempted.
+func resolveAutolaunchAddress(keys string) (string, error) {
+	if _, err := parseAutolaunchKeys(keys); err != nil {
+		return "", err
+	}
+	if address := tryDiscoverDbusSessionBusAddress(); address != "" {
+		return address, nil
+	}
+	attempts := []error{errors.New("discovering a running session bus: none found")}
+	address, err := getSessionBusPlatformAddress()
+	switch {
+	case err != nil:
+		attempts = append(attempts, fmt.Errorf("launching a session bus: %w", err))
+	case isAutolaunchAddress(address):
+		attempts = append(attempts, fmt.Errorf("launching a session bus: got autolaunch address %q", address))
+	default:
+		return address, nil
+	}
+	return "", fmt.Errorf("dbus: autolaunch failed: %w", errors.Join(attempts...))
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"net"
 	"unsafe"
 )
 
@@ -25,6 +26,16 @@ type genericTransport struct {
 	io.ReadWriteCloser
 }
 
+func (t genericTransport) netConn() (net.Conn, bool) {
+	switch c := t.ReadWriteCloser.(type) {
+	case net.Conn:
+		return c, true
+	case genericTransport:
+		return c.netConn()
+	}
+	return nil, false
+}
+
 func (t genericTransport) SendNullByte() error {
 	_, err := t.Write([]byte{0})
 	return err
@@ -40,13 +51,21 @@ func (t genericTransport) ReadMessage() (*Message, error) {
 	return DecodeMessage(t)
 }
 
+func (t genericTransport) readRawMessage() (*Message, error) {
+	return readMessage(t, make([]int, 0))
+}
+
 func (t genericTransport) SendMessage(msg *Message) error {
-	fds, err := msg.CountFds()
+	size, fds, err := msg.bodySize()
 	if err != nil {
 		return err
 	}
 	if fds != 0 {
 		return errors.New("dbus: unix fd passing not enabled")
 	}
-	return msg.EncodeTo(t, nativeEndian)
+	if size >= streamBodySize {
+		_, err = msg.streamTo(t, msg.sendOrder(), size)
+		return err
+	}
+	return msg.EncodeTo(t, msg.sendOrder())
 }
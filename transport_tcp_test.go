@@ -24,3 +24,34 @@ func TestTcpConnection(t *testing.T) {
 		t.Error("Expected connection, got nil")
 	}
 }
+
+func TestAuthFallbackToAnonymous(t *testing.T) {
+	// EXTERNAL can't succeed on a TCP connection, so the bus rejects it even
+	// though it is enabled.
+	addr, process := startDaemon(t, `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-BUS Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+	<type>session</type>
+	<listen>tcp:host=127.0.0.1</listen>
+	<auth>EXTERNAL</auth>
+	<auth>ANONYMOUS</auth>
+	<allow_anonymous/>
+	<apparmor mode="disabled"/>
+	<policy context="default">
+		<allow send_destination="*" eavesdrop="true"/>
+		<allow eavesdrop="true"/>
+		<allow own="*"/>
+	</policy>
+</busconfig>
+`)
+	defer func() { _ = process.Kill() }()
+
+	conn, err := Connect(addr, WithAuth(AuthExternal(""), AuthAnonymous()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if m := conn.AuthMechanism(); m != "ANONYMOUS" {
+		t.Errorf("authenticated with %s, want ANONYMOUS", m)
+	}
+}
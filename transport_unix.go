@@ -62,6 +62,8 @@ func newUnixTransport(keys string) (transport, error) {
 	abstract := getKey(keys, "abstract")
 	path := getKey(keys, "path")
 	switch {
+	case abstract == "" && path == "" && (getKey(keys, "tmpdir") != "" || getKey(keys, "dir") != ""):
+		return nil, errors.New("dbus: invalid address (tmpdir and dir are only valid for listening)")
 	case abstract == "" && path == "":
 		return nil, errors.New("dbus: invalid address (neither path nor abstract set)")
 	case abstract != "" && path == "":
@@ -90,6 +92,17 @@ func (t *unixTransport) EnableUnixFDs() {
 }
 
 func (t *unixTransport) ReadMessage() (*Message, error) {
+	msg, err := t.readRawMessage()
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.decodeReadBody(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (t *unixTransport) readRawMessage() (_ *Message, err error) {
 	// To be sure that all bytes of out-of-band data are read, we use a special
 	// reader that uses ReadUnix on the underlying connection instead of Read
 	// and gathers the out-of-band data in a buffer.
@@ -107,13 +120,20 @@ func (t *unixTransport) ReadMessage() (*Message, error) {
 		t.rdr.oob = t.rdr.oob[:0]
 		t.rdr.headers = t.rdr.headers[:0]
 	}
+	// Any fds received along with a message that can't be returned would
+	// otherwise leak.
+	defer func() {
+		if err != nil {
+			closeUnixRights(t.rdr.oob)
+		}
+	}()
 	var (
 		r   = t.rdr.r
 		b   = t.rdr.b
 		dec = t.rdr.dec
 	)
 
-	_, err := io.ReadFull(t.rdr, t.rdr.csheader)
+	_, err = io.ReadFull(t.rdr, t.rdr.csheader)
 	if err != nil {
 		return nil, err
 	}
@@ -146,6 +166,15 @@ func (t *unixTransport) ReadMessage() (*Message, error) {
 	if hlen+t.rdr.msghead.BodyLen+16 > 1<<27 {
 		return nil, InvalidMessageError("message is too long")
 	}
+	if err := checkProtoVersion(t.rdr.msghead.Proto); err != nil {
+		// skip the rest of the message, so that the next one can be read
+		// the deferred call closes the fds sent along
+		_, cerr := io.CopyN(io.Discard, t.rdr, int64(hlen+t.rdr.msghead.BodyLen))
+		if cerr != nil {
+			return nil, cerr
+		}
+		return nil, err
+	}
 
 	// Decode headers and look for unix fds.
 	b.Reset()
@@ -181,9 +210,15 @@ func (t *unixTransport) ReadMessage() (*Message, error) {
 	if _, err = io.ReadFull(t.rdr, body); err != nil {
 		return nil, err
 	}
-	r.Reset(body)
+	if err = msg.validateHeader(); err != nil {
+		return nil, err
+	}
 
-	if unixfds != 0 {
+	var fds []int
+	if unixfds == 0 {
+		// fds that weren't announced in the header are of no use to anyone
+		closeUnixRights(t.rdr.oob)
+	} else {
 		if !t.hasUnixFDs {
 			return nil, errors.New("dbus: got unix fds on unsupported transport")
 		}
@@ -195,61 +230,37 @@ func (t *unixTransport) ReadMessage() (*Message, error) {
 		if len(scms) != 1 {
 			return nil, errors.New("dbus: received more than one socket control message")
 		}
-		fds, err := syscall.ParseUnixRights(&scms[0])
+		fds, err = syscall.ParseUnixRights(&scms[0])
 		if err != nil {
 			return nil, err
 		}
-		dec.Reset(r, order, fds)
-		if err = decodeMessageBody(msg, dec); err != nil {
-			return nil, err
-		}
-		// substitute the values in the message body (which are indices for the
-		// array receiver via OOB) with the actual values
-		for i, v := range msg.Body {
-			switch index := v.(type) {
-			case UnixFDIndex:
-				if uint32(index) >= unixfds {
-					return nil, InvalidMessageError("invalid index for unix fd")
-				}
-				msg.Body[i] = UnixFD(fds[index])
-			case []UnixFDIndex:
-				fdArray := make([]UnixFD, len(index))
-				for k, j := range index {
-					if uint32(j) >= unixfds {
-						return nil, InvalidMessageError("invalid index for unix fd")
-					}
-					fdArray[k] = UnixFD(fds[j])
-				}
-				msg.Body[i] = fdArray
-			}
-		}
-		return msg, nil
-	}
-
-	dec.Reset(r, order, nil)
-	if err = decodeMessageBody(msg, dec); err != nil {
-		return nil, err
 	}
+	msg.rawBody, msg.rawOrder, msg.rawFDs = body, order, fds
+	msg.wireSize = 16 + int(hlen+t.rdr.msghead.BodyLen)
 	return msg, nil
 }
 
-func decodeMessageBody(msg *Message, dec *decoder) error {
-	if err := msg.validateHeader(); err != nil {
-		return err
+// closeUnixRights closes all file descriptors contained in the out-of-band
+// data oob.
+func closeUnixRights(oob []byte) {
+	if len(oob) == 0 {
+		return
 	}
-
-	sig, _ := msg.Headers[FieldSignature].value.(Signature)
-	if sig.str == "" {
-		return nil
+	scms, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for i := range scms {
+		fds, err := syscall.ParseUnixRights(&scms[i])
+		if err != nil {
+			continue
+		}
+		closeFDs(fds)
 	}
-
-	var err error
-	msg.Body, err = dec.Decode(sig)
-	return err
 }
 
 func (t *unixTransport) SendMessage(msg *Message) error {
-	fdcnt, err := msg.CountFds()
+	size, fdcnt, err := msg.bodySize()
 	if err != nil {
 		return err
 	}
@@ -259,7 +270,7 @@ func (t *unixTransport) SendMessage(msg *Message) error {
 		}
 		msg.Headers[FieldUnixFDs] = MakeVariant(uint32(fdcnt))
 		buf := new(bytes.Buffer)
-		fds, err := msg.EncodeToWithFDs(buf, nativeEndian)
+		fds, err := msg.EncodeToWithFDs(buf, msg.sendOrder())
 		if err != nil {
 			return err
 		}
@@ -271,14 +282,36 @@ func (t *unixTransport) SendMessage(msg *Message) error {
 		if n != buf.Len() || oobn != len(oob) {
 			return io.ErrShortWrite
 		}
+	} else if size >= streamBodySize {
+		if _, err := msg.streamTo(t, msg.sendOrder(), size); err != nil {
+			return err
+		}
 	} else {
-		if err := msg.EncodeTo(t, nativeEndian); err != nil {
+		if err := msg.EncodeTo(t, msg.sendOrder()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (t *unixTransport) netConn() (net.Conn, bool) {
+	return t.UnixConn, true
+}
+
 func (t *unixTransport) SupportsUnixFDs() bool {
 	return true
 }
+
+// DupFD duplicates the received file descriptor fd and returns the new
+// descriptor, which has close-on-exec set. The caller owns the returned
+// descriptor and must close it, independently of fd.
+func DupFD(fd UnixFD) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	nfd, err := syscall.Dup(int(fd))
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(nfd)
+	return nfd, nil
+}
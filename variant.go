@@ -2,10 +2,12 @@ package dbus
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 // Variant represents the D-Bus variant type.
@@ -25,6 +27,98 @@ func MakeVariantWithSignature(v interface{}, s Signature) Variant {
 	return Variant{s, v}
 }
 
+// MakeValidVariant acts like MakeVariantWithSignature, but checks that v can
+// be represented as s, which may differ from the signature SignatureOf
+// returns for v:
+//
+//   - a string may be given for an object path ("o") or a signature ("g");
+//   - an empty slice or map may be given for an array of any element type
+//     that has a corresponding Go type;
+//   - any value may be given for "v", in which case it is wrapped in a Variant;
+//   - a []interface{} may be given for a struct, as returned by the decoder,
+//     if it has one value for each member that can be represented as the
+//     member's type by these rules.
+//
+// The value is converted to a Go type with the signature s where necessary;
+// structs given as []interface{} are converted to Go structs.
+// It returns a SignatureError if s is not a single complete type or if v
+// cannot be represented as s.
+func MakeValidVariant(v interface{}, s Signature) (Variant, error) {
+	if !s.Single() {
+		return Variant{}, SignatureError{Sig: s.str, Reason: "not a single complete type"}
+	}
+	value, ok := valueForSignature(v, s.str)
+	if !ok {
+		return Variant{}, SignatureError{Sig: s.str, Reason: fmt.Sprintf("incompatible value of type %T", v)}
+	}
+	return Variant{s, value}, nil
+}
+
+// valueForSignature converts v to a value whose signature is sig, as described
+// for MakeValidVariant. It reports whether this was possible.
+func valueForSignature(v interface{}, sig string) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil, false
+	}
+	if m, ok := v.(OrderedMap); ok && sig != "v" {
+		return v, m.sig.str == sig
+	}
+	switch {
+	case sig == "v":
+		if _, ok := v.(Variant); ok {
+			return v, true
+		}
+		return MakeVariant(v), true
+	case sig[0] == '(':
+		if vs, ok := v.([]interface{}); ok {
+			return structForSignature(vs, sig)
+		}
+	case sig == "o" && rv.Kind() == reflect.String:
+		p := ObjectPath(rv.String())
+		return p, p.IsValid()
+	case sig == "g" && rv.Kind() == reflect.String:
+		s, err := ParseSignature(rv.String())
+		return s, err == nil
+	case sig[0] == 'a' && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0:
+		t := typeFor(sig)
+		if signatureOfType(t) != sig {
+			return nil, false
+		}
+		if t.Kind() == reflect.Map {
+			return reflect.MakeMap(t).Interface(), true
+		}
+		return reflect.MakeSlice(t, 0, 0).Interface(), true
+	}
+	return v, signatureOfType(rv.Type()) == sig
+}
+
+// structForSignature converts the members vs of a struct to a Go struct
+// whose signature is sig, converting each member like valueForSignature, so
+// that the result is encoded as a struct rather than as an array. It reports
+// whether this was possible.
+func structForSignature(vs []interface{}, sig string) (interface{}, bool) {
+	types, err := splitSignature(sig[1 : len(sig)-1])
+	if err != nil || len(types) != len(vs) {
+		return nil, false
+	}
+	members := make([]reflect.Value, len(vs))
+	fields := make([]reflect.StructField, len(vs))
+	for i, v := range vs {
+		m, ok := valueForSignature(v, types[i])
+		if !ok {
+			return nil, false
+		}
+		members[i] = reflect.ValueOf(m)
+		fields[i] = reflect.StructField{Name: "F" + strconv.Itoa(i), Type: members[i].Type()}
+	}
+	st := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, m := range members {
+		st.Field(i).Set(m)
+	}
+	return st.Interface(), true
+}
+
 // ParseVariant parses the given string as a variant as described at
 // https://developer.gnome.org/glib/stable/gvariant-text.html. If sig is not
 // empty, it is taken to be the expected signature for the variant.
@@ -45,7 +139,258 @@ func ParseVariant(s string, sig Signature) (Variant, error) {
 	if err != nil {
 		return Variant{}, err
 	}
-	return MakeVariant(v), nil
+	return Variant{sig, v}, nil
+}
+
+// MakeBody builds a message body for the given signature from one textual
+// argument per complete type in sig, similar to the arguments of dbus-send.
+// Arguments for strings, object paths and signatures are taken literally;
+// arguments for variants are parsed with ParseVariant, inferring their type;
+// all other arguments are parsed with ParseVariant as the respective type,
+// e.g. "42" for "u", "[1, 2]" for "ai" or "{'a': <1>}" for "a{sv}".
+func MakeBody(sig Signature, args ...string) ([]interface{}, error) {
+	body := make([]interface{}, 0, len(args))
+	s := sig.str
+	for i, arg := range args {
+		if s == "" {
+			return nil, fmt.Errorf("dbus: too many arguments for signature %q", sig.str)
+		}
+		err, rem := validSingle(s, &depthCounter{})
+		if err != nil {
+			return nil, err
+		}
+		v, err := makeBodyValue(s[:len(s)-len(rem)], arg)
+		if err != nil {
+			return nil, fmt.Errorf("dbus: argument %d: %w", i+1, err)
+		}
+		body = append(body, v)
+		s = rem
+	}
+	if s != "" {
+		return nil, fmt.Errorf("dbus: too few arguments for signature %q", sig.str)
+	}
+	return body, nil
+}
+
+// makeBodyValue converts a single argument of MakeBody to the given type.
+func makeBodyValue(sig, arg string) (interface{}, error) {
+	switch sig {
+	case "s":
+		return arg, nil
+	case "o":
+		if !ObjectPath(arg).IsValid() {
+			return nil, fmt.Errorf("invalid object path %q", arg)
+		}
+		return ObjectPath(arg), nil
+	case "g":
+		return ParseSignature(arg)
+	case "v":
+		return ParseVariant(arg, Signature{})
+	}
+	v, err := ParseVariant(arg, Signature{sig})
+	if err != nil {
+		return nil, err
+	}
+	return v.value, nil
+}
+
+// splitSignature splits s into its single complete types.
+func splitSignature(s string) ([]string, error) {
+	var types []string
+	for s != "" {
+		err, rem := validSingle(s, &depthCounter{})
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, s[:len(s)-len(rem)])
+		s = rem
+	}
+	return types, nil
+}
+
+// genericTypeFor returns the Go type that coerceValue produces for the single
+// complete type sig. Unlike typeFor, it maps structs to anonymous struct types
+// with one field per member, so that they are encoded as structs.
+func genericTypeFor(sig string) reflect.Type {
+	switch sig[0] {
+	case 'a':
+		if sig[1] == '{' {
+			i := strings.LastIndex(sig, "}")
+			return reflect.MapOf(genericTypeFor(sig[2:3]), genericTypeFor(sig[3:i]))
+		}
+		return reflect.SliceOf(genericTypeFor(sig[1:]))
+	case '(':
+		members, _ := splitSignature(sig[1 : len(sig)-1])
+		fields := make([]reflect.StructField, len(members))
+		for i, m := range members {
+			fields[i] = reflect.StructField{Name: "F" + strconv.Itoa(i), Type: genericTypeFor(m)}
+		}
+		return reflect.StructOf(fields)
+	case 'h':
+		return unixFDType
+	}
+	return sigToType[sig[0]]
+}
+
+// signatureOfValue returns the signature of v, or false if v can't be
+// represented as a D-Bus type.
+func signatureOfValue(v interface{}) (sig string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			sig, ok = "", false
+		}
+	}()
+	return SignatureOf(v).str, true
+}
+
+// coerceBody converts args to a message body with the signature sig, using
+// coerceValue for arguments whose signature differs from the respective type
+// in sig.
+func coerceBody(sig Signature, args []interface{}) ([]interface{}, error) {
+	types, err := splitSignature(sig.str)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(types) {
+		return nil, fmt.Errorf("dbus: got %d arguments for signature %q", len(args), sig.str)
+	}
+	body := make([]interface{}, len(args))
+	for i, arg := range args {
+		if s, ok := signatureOfValue(arg); ok && s == types[i] {
+			body[i] = arg
+			continue
+		}
+		v, err := coerceValue(reflect.ValueOf(arg), types[i])
+		if err != nil {
+			return nil, fmt.Errorf("dbus: argument %d: %w", i+1, err)
+		}
+		body[i] = v.Interface()
+	}
+	return body, nil
+}
+
+// coerceValue converts v to a value of type genericTypeFor(sig). Numbers are
+// converted if the value is representable, strings are parsed with
+// makeBodyValue unless sig is a string-like type, slices or structs are
+// accepted for D-Bus structs and variants are unwrapped.
+func coerceValue(v reflect.Value, sig string) (reflect.Value, error) {
+	if v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return reflect.Value{}, fmt.Errorf("nil value for %q", sig)
+	}
+	if sig == "v" {
+		if v.Type() == variantType {
+			return v, nil
+		}
+		return reflect.ValueOf(MakeVariant(v.Interface())), nil
+	}
+	if v.Type() == variantType {
+		return coerceValue(reflect.ValueOf(v.Interface().(Variant).value), sig)
+	}
+	t := genericTypeFor(sig)
+	if v.Kind() == reflect.String && sig != "s" && sig != "o" && sig != "g" {
+		parsed, err := makeBodyValue(sig, v.String())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return coerceValue(reflect.ValueOf(parsed), sig)
+	}
+	incompatible := fmt.Errorf("cannot use value of type %s as %q", v.Type(), sig)
+	switch sig[0] {
+	case 'a':
+		if sig[1] == '{' {
+			if v.Kind() != reflect.Map {
+				return reflect.Value{}, incompatible
+			}
+			i := strings.LastIndex(sig, "}")
+			m := reflect.MakeMapWithSize(t, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				k, err := coerceValue(iter.Key(), sig[2:3])
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				e, err := coerceValue(iter.Value(), sig[3:i])
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				m.SetMapIndex(k, e)
+			}
+			return m, nil
+		}
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return reflect.Value{}, incompatible
+		}
+		s := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := coerceValue(v.Index(i), sig[1:])
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			s.Index(i).Set(e)
+		}
+		return s, nil
+	case '(':
+		var members []reflect.Value
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				members = append(members, v.Index(i))
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if f := v.Type().Field(i); f.PkgPath == "" && f.Tag.Get("dbus") != "-" {
+					members = append(members, v.Field(i))
+				}
+			}
+		default:
+			return reflect.Value{}, incompatible
+		}
+		types, _ := splitSignature(sig[1 : len(sig)-1])
+		if len(members) != len(types) {
+			return reflect.Value{}, fmt.Errorf("got %d values for struct %q", len(members), sig)
+		}
+		st := reflect.New(t).Elem()
+		for i, m := range members {
+			f, err := coerceValue(m, types[i])
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			st.Field(i).Set(f)
+		}
+		return st, nil
+	case 'o':
+		if v.Kind() != reflect.String || !ObjectPath(v.String()).IsValid() {
+			return reflect.Value{}, incompatible
+		}
+		return reflect.ValueOf(ObjectPath(v.String())), nil
+	case 'g':
+		if v.Type() == signatureType {
+			return v, nil
+		}
+		if v.Kind() != reflect.String {
+			return reflect.Value{}, incompatible
+		}
+		s, err := ParseSignature(v.String())
+		return reflect.ValueOf(s), err
+	case 'b', 's':
+		if v.Kind() != t.Kind() {
+			return reflect.Value{}, incompatible
+		}
+		return v.Convert(t), nil
+	}
+	if v.Type() == t {
+		return v, nil
+	}
+	if !isNumber(v.Kind()) {
+		return reflect.Value{}, incompatible
+	}
+	if !numberFits(v, t) {
+		return reflect.Value{}, fmt.Errorf("value %v out of range for %q", v.Interface(), sig)
+	}
+	return v.Convert(t), nil
 }
 
 // format returns a formatted version of v and whether this string can be parsed
@@ -63,13 +408,23 @@ func (v Variant) format() (string, bool) {
 	case 'g':
 		return strconv.Quote(v.value.(Signature).str), false
 	case 'v':
-		s, unamb := v.value.(Variant).format()
+		inner, ok := v.value.(Variant)
+		if !ok {
+			// e.g. an element of a []interface{}
+			inner = MakeVariant(v.value)
+		}
+		s, unamb := inner.format()
 		if !unamb {
-			return "<@" + v.value.(Variant).sig.str + " " + s + ">", true
+			return "<@" + inner.sig.str + " " + s + ">", true
 		}
 		return "<" + s + ">", true
 	case 'y':
 		return fmt.Sprintf("%#x", v.value.(byte)), false
+	case '(':
+		return v.formatStruct()
+	}
+	if m, ok := v.value.(OrderedMap); ok {
+		return v.formatOrderedMap(m)
 	}
 	rv := reflect.ValueOf(v.value)
 	switch rv.Kind() {
@@ -77,11 +432,12 @@ func (v Variant) format() (string, bool) {
 		if rv.Len() == 0 {
 			return "[]", false
 		}
+		esig := Signature{v.sig.str[1:]}
 		unamb := true
 		buf := bytes.NewBuffer([]byte("["))
 		for i := 0; i < rv.Len(); i++ {
 			// TODO: slooow
-			s, b := MakeVariant(rv.Index(i).Interface()).format()
+			s, b := Variant{esig, rv.Index(i).Interface()}.format()
 			unamb = unamb && b
 			buf.WriteString(s)
 			if i != rv.Len()-1 {
@@ -94,16 +450,18 @@ func (v Variant) format() (string, bool) {
 		if rv.Len() == 0 {
 			return "{}", false
 		}
+		ksig := Signature{v.sig.str[2:3]}
+		vsig := Signature{v.sig.str[3 : len(v.sig.str)-1]}
 		unamb := true
 		var buf bytes.Buffer
 		kvs := make([]string, rv.Len())
 		for i, k := range rv.MapKeys() {
-			s, b := MakeVariant(k.Interface()).format()
+			s, b := Variant{ksig, k.Interface()}.format()
 			unamb = unamb && b
 			buf.Reset()
 			buf.WriteString(s)
 			buf.WriteString(": ")
-			s, b = MakeVariant(rv.MapIndex(k).Interface()).format()
+			s, b = Variant{vsig, rv.MapIndex(k).Interface()}.format()
 			unamb = unamb && b
 			buf.WriteString(s)
 			kvs[i] = buf.String()
@@ -123,6 +481,73 @@ func (v Variant) format() (string, bool) {
 	return `"INVALID"`, true
 }
 
+// formatOrderedMap formats m like a map, keeping the order of its entries.
+func (v Variant) formatOrderedMap(m OrderedMap) (string, bool) {
+	if len(m.Entries) == 0 {
+		return "{}", false
+	}
+	ksig := Signature{v.sig.str[2:3]}
+	vsig := Signature{v.sig.str[3 : len(v.sig.str)-1]}
+	unamb := true
+	buf := bytes.NewBuffer([]byte("{"))
+	for i, e := range m.Entries {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		s, b := Variant{ksig, e.Key}.format()
+		unamb = unamb && b
+		buf.WriteString(s)
+		buf.WriteString(": ")
+		s, b = Variant{vsig, e.Value}.format()
+		unamb = unamb && b
+		buf.WriteString(s)
+	}
+	buf.WriteByte('}')
+	return buf.String(), unamb
+}
+
+// formatStruct formats a struct value, which is either a []interface{} as
+// returned by the decoder or a Go struct, as a tuple.
+func (v Variant) formatStruct() (string, bool) {
+	var fields []interface{}
+	switch rv := reflect.ValueOf(v.value); {
+	case rv.Kind() == reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			fields = append(fields, rv.Index(i).Interface())
+		}
+	case rv.Kind() == reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			field := rv.Type().Field(i)
+			if field.PkgPath == "" && field.Tag.Get("dbus") != "-" {
+				fields = append(fields, rv.Field(i).Interface())
+			}
+		}
+	default:
+		return `"INVALID"`, true
+	}
+	unamb := true
+	buf := bytes.NewBuffer([]byte("("))
+	s := v.sig.str[1 : len(v.sig.str)-1]
+	for i, field := range fields {
+		err, rem := validSingle(s, &depthCounter{})
+		if err != nil {
+			return `"INVALID"`, true
+		}
+		fs, b := Variant{Signature{s[:len(s)-len(rem)]}, field}.format()
+		unamb = unamb && b
+		buf.WriteString(fs)
+		if i != len(fields)-1 {
+			buf.WriteString(", ")
+		}
+		s = rem
+	}
+	if len(fields) == 1 {
+		buf.WriteByte(',')
+	}
+	buf.WriteByte(')')
+	return buf.String(), unamb
+}
+
 // Signature returns the D-Bus signature of the underlying value of v.
 func (v Variant) Signature() Signature {
 	return v.sig
@@ -138,6 +563,15 @@ func (v Variant) String() string {
 	return s
 }
 
+// MarshalJSON encodes v as an object holding its signature and its value, e.g.
+// {"signature":"u","value":42}. Nested variants are encoded the same way.
+func (v Variant) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Signature string      `json:"signature"`
+		Value     interface{} `json:"value"`
+	}{v.sig.str, v.value})
+}
+
 // Value returns the underlying value of v.
 func (v Variant) Value() interface{} {
 	return v.value
@@ -148,3 +582,77 @@ func (v Variant) Value() interface{} {
 func (v Variant) Store(value interface{}) error {
 	return storeInterfaces(v.value, value)
 }
+
+// Equal reports whether v and other have the same signature and equal values.
+// Values are compared element by element, so nested variants are compared
+// with Equal, and nil and empty arrays or dictionaries are equal as they are
+// encoded identically. Values of different Go types are never equal, e.g. a
+// struct decoded as []interface{} and the Go struct it was encoded from.
+func (v Variant) Equal(other Variant) bool {
+	return v.sig.str == other.sig.str &&
+		valuesEqual(reflect.ValueOf(v.value), reflect.ValueOf(other.value))
+}
+
+// valuesEqual compares two values of the types used for D-Bus values.
+func valuesEqual(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !valuesEqual(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			bv := b.MapIndex(iter.Key())
+			if !bv.IsValid() || !valuesEqual(iter.Value(), bv) {
+				return false
+			}
+		}
+		return true
+	case reflect.Ptr, reflect.Interface:
+		return valuesEqual(a.Elem(), b.Elem())
+	case reflect.Struct:
+		switch a.Type() {
+		case signatureType:
+			return a.Field(0).String() == b.Field(0).String()
+		case variantType:
+			return a.Field(0).Field(0).String() == b.Field(0).Field(0).String() &&
+				valuesEqual(a.Field(1).Elem(), b.Field(1).Elem())
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if !valuesEqual(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
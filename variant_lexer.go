@@ -32,6 +32,8 @@ const (
 	tokColon
 	tokType
 	tokByteString
+	tokTupleStart
+	tokTupleEnd
 )
 
 type varLexer struct {
@@ -118,6 +120,10 @@ func varLexNormal(l *varLexer) lexState {
 			l.emit(tokDictStart)
 		case r == '}':
 			l.emit(tokDictEnd)
+		case r == '(':
+			l.emit(tokTupleStart)
+		case r == ')':
+			l.emit(tokTupleEnd)
 		case r == '<':
 			l.emit(tokVariantStart)
 		case r == '>':
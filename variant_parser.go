@@ -65,6 +65,8 @@ func varMakeNode(p *varParser) (varNode, error) {
 			return varMakeVariantNode(p, sig)
 		case tokDictStart:
 			return varMakeDictNode(p, sig)
+		case tokTupleStart:
+			return varMakeTupleNode(p, sig)
 		case tokType:
 			if sig.str != "" {
 				return nil, errors.New("unexpected type annotation")
@@ -548,7 +550,7 @@ func (n variantNode) Value(sig Signature) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return MakeVariant(v), nil
+	return Variant{sig, v}, nil
 }
 
 func varMakeVariantNode(p *varParser, sig Signature) (varNode, error) {
@@ -729,6 +731,145 @@ Loop:
 	return n, nil
 }
 
+// maxTupleSigs limits the number of candidate signatures tracked for a tuple
+// without type annotation, as they multiply with every element.
+const maxTupleSigs = 256
+
+type tupleNode struct {
+	sig      Signature
+	children []varNode
+}
+
+func (n tupleNode) Infer() (Signature, error) {
+	s := "("
+	for _, v := range n.children {
+		csig, err := varInfer(v)
+		if err != nil {
+			return Signature{}, err
+		}
+		s += csig.str
+	}
+	return Signature{s + ")"}, nil
+}
+
+func (n tupleNode) String() string {
+	s := "("
+	for i, v := range n.children {
+		s += v.String()
+		if i != len(n.children)-1 {
+			s += ", "
+		}
+	}
+	if len(n.children) == 1 {
+		s += ","
+	}
+	return s + ")"
+}
+
+func (n tupleNode) Sigs() sigSet {
+	if n.sig.str != "" {
+		return sigSet{n.sig: true}
+	}
+	prefixes := []string{"("}
+	for _, v := range n.children {
+		cset := v.Sigs()
+		if cset.Empty() || len(prefixes)*len(cset) > maxTupleSigs {
+			// not enough (or too much) type information; the tuple has to
+			// be inferred or checked against a given signature instead
+			return sigSet{}
+		}
+		next := make([]string, 0, len(prefixes)*len(cset))
+		for _, prefix := range prefixes {
+			for csig := range cset {
+				next = append(next, prefix+csig.str)
+			}
+		}
+		prefixes = next
+	}
+	r := make(sigSet, len(prefixes))
+	for _, prefix := range prefixes {
+		r[Signature{prefix + ")"}] = true
+	}
+	return r
+}
+
+func (n tupleNode) Value(sig Signature) (interface{}, error) {
+	if n.sig.str != "" && n.sig != sig {
+		return nil, varTypeError{n.String(), sig}
+	}
+	if len(sig.str) < 2 || sig.str[0] != '(' {
+		return nil, varTypeError{n.String(), sig}
+	}
+	v := make([]interface{}, 0, len(n.children))
+	s := sig.str[1 : len(sig.str)-1]
+	for _, cn := range n.children {
+		err, rem := validSingle(s, &depthCounter{})
+		if err != nil {
+			return nil, varTypeError{n.String(), sig}
+		}
+		cv, err := cn.Value(Signature{s[:len(s)-len(rem)]})
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, cv)
+		s = rem
+	}
+	if s != "" {
+		return nil, varTypeError{n.String(), sig}
+	}
+	return v, nil
+}
+
+func varMakeTupleNode(p *varParser, sig Signature) (varNode, error) {
+	var n tupleNode
+	if sig.str != "" {
+		if sig.str[0] != '(' {
+			return nil, fmt.Errorf("invalid signature %q for tuple type", sig)
+		}
+		n.sig = sig
+	}
+	if t := p.next(); t.typ == tokTupleEnd {
+		return nil, errors.New("empty tuples are not supported")
+	} else {
+		p.backup()
+	}
+Loop:
+	for {
+		t := p.next()
+		switch t.typ {
+		case tokEOF:
+			return nil, io.ErrUnexpectedEOF
+		case tokError:
+			return nil, errors.New(t.val)
+		case tokTupleEnd:
+			// trailing comma, as in "(1,)"
+			if len(n.children) == 0 {
+				return nil, fmt.Errorf("unexpected %q", t.val)
+			}
+			break Loop
+		}
+		p.backup()
+		cn, err := varMakeNode(p)
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, cn)
+		switch t := p.next(); t.typ {
+		case tokEOF:
+			return nil, io.ErrUnexpectedEOF
+		case tokError:
+			return nil, errors.New(t.val)
+		case tokTupleEnd:
+			break Loop
+		case tokComma:
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected %q", t.val)
+		}
+	}
+	return n, nil
+}
+
 type byteStringNode []byte
 
 var byteStringSet = sigSet{
@@ -1,6 +1,8 @@
 package dbus
 
 import (
+	"bytes"
+	"encoding/binary"
 	"reflect"
 	"testing"
 )
@@ -21,6 +23,11 @@ var variantFormatTests = []struct {
 	{map[string]int32{"one": 1, "two": 2}, `{"one": 1, "two": 2}`},
 	{map[int32]ObjectPath{1: "/org/foo"}, `@a{io} {1: "/org/foo"}`},
 	{map[string]Variant{}, `@a{sv} {}`},
+	{struct {
+		A int32
+		B string
+	}{1, "foo"}, `(1, "foo")`},
+	{struct{ A uint32 }{1}, `@(u) (1,)`},
 }
 
 func TestFormatVariant(t *testing.T) {
@@ -66,6 +73,22 @@ var variantParseTests = []struct {
 	{`[[0], b""]`, [][]byte{{0}, {0}}},
 	{"int16 0", int16(0)},
 	{"byte 0", byte(0)},
+	{`(1, "foo")`, []interface{}{int32(1), "foo"}},
+	{`(1,)`, []interface{}{int32(1)}},
+	{`@(us) (1, "x")`, []interface{}{uint32(1), "x"}},
+	{`(1, @as [])`, []interface{}{int32(1), []string{}}},
+	{`[(1, "a"), (2, "b")]`, [][]interface{}{{int32(1), "a"}, {int32(2), "b"}}},
+	{
+		`@a{sv} {"a": <1>, "b": <(1, "x")>}`,
+		map[string]Variant{
+			"a": MakeVariant(int32(1)),
+			"b": MakeVariantWithSignature([]interface{}{int32(1), "x"}, Signature{"(is)"}),
+		},
+	},
+	{
+		`<(int32 1, [<"a">])>`,
+		MakeVariantWithSignature([]interface{}{int32(1), []Variant{MakeVariant("a")}}, Signature{"(iav)"}),
+	},
 }
 
 func TestParseVariant(t *testing.T) {
@@ -93,3 +116,254 @@ func TestVariantStore(t *testing.T) {
 		t.Fatalf("expected %s, got %s\n", str, result)
 	}
 }
+
+func TestMakeValidVariant(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		sig  string
+		want interface{}
+		wire string
+	}{
+		{"/org/foo", "o", ObjectPath("/org/foo"), `@o "/org/foo"`},
+		{"a{sv}", "g", Signature{"a{sv}"}, `@g "a{sv}"`},
+		{map[string]interface{}{}, "a{sv}", map[string]Variant{}, `@a{sv} {}`},
+		{[]string{}, "ao", []ObjectPath{}, `@ao []`},
+		{int32(1), "v", MakeVariant(int32(1)), `<1>`},
+		{uint32(2), "u", uint32(2), `@u 2`},
+	} {
+		v, err := MakeValidVariant(tc.v, Signature{tc.sig})
+		if err != nil {
+			t.Errorf("%#v as %s: %v", tc.v, tc.sig, err)
+			continue
+		}
+		if v.Signature().String() != tc.sig {
+			t.Errorf("%#v as %s: got signature %s", tc.v, tc.sig, v.Signature())
+		}
+		if !reflect.DeepEqual(v.Value(), tc.want) {
+			t.Errorf("%#v as %s: got value %#v, want %#v", tc.v, tc.sig, v.Value(), tc.want)
+		}
+		if s := v.String(); s != tc.wire {
+			t.Errorf("%#v as %s: got %q, want %q", tc.v, tc.sig, s, tc.wire)
+		}
+		buf := new(bytes.Buffer)
+		if err := newEncoder(buf, binary.LittleEndian, nil).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		vs, err := newDecoder(buf, binary.LittleEndian, nil).Decode(Signature{"v"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := vs[0].(Variant).Signature().String(); got != tc.sig {
+			t.Errorf("%#v as %s: decoded signature %s", tc.v, tc.sig, got)
+		}
+	}
+}
+
+func TestMakeValidVariantStruct(t *testing.T) {
+	members := []interface{}{int32(1), "/org/foo", []interface{}{uint32(2), []string{}}}
+	v, err := MakeValidVariant(members, Signature{"(io(uas))"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := v.String(); s != `@(io(uas)) (1, "/org/foo", (2, []))` {
+		t.Errorf("got %s", s)
+	}
+	buf := new(bytes.Buffer)
+	if err := newEncoder(buf, binary.LittleEndian, nil).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := newDecoder(buf, binary.LittleEndian, nil).Decode(Signature{"v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := vs[0].(Variant)
+	want := []interface{}{int32(1), ObjectPath("/org/foo"), []interface{}{uint32(2), []string{}}}
+	if got.Signature().String() != "(io(uas))" || !reflect.DeepEqual(got.Value(), want) {
+		t.Errorf("decoded %#v, want %#v", got, want)
+	}
+}
+
+func TestMakeValidVariantInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		v   interface{}
+		sig string
+	}{
+		{int32(1), "s"},
+		{"not a path", "o"},
+		{"a{", "g"},
+		{[]string{"foo"}, "ao"},
+		{"foo", "ss"},
+		{"foo", ""},
+		{map[string]int32{}, "a(ss)"},
+		{[]interface{}{}, "(is)"},
+		{[]interface{}{int32(1)}, "(is)"},
+		{[]interface{}{int32(1), int32(2)}, "(is)"},
+		{[]interface{}{int32(1), []interface{}{"x"}}, "(i(o))"},
+	} {
+		if _, err := MakeValidVariant(tc.v, Signature{tc.sig}); err == nil {
+			t.Errorf("%#v as %q: expected an error", tc.v, tc.sig)
+		}
+		// MakeVariantWithSignature keeps taking the value as is
+		if v := MakeVariantWithSignature(tc.v, Signature{tc.sig}); !reflect.DeepEqual(v.Value(), tc.v) || v.Signature().String() != tc.sig {
+			t.Errorf("%#v as %q: got %#v", tc.v, tc.sig, v)
+		}
+	}
+}
+
+func TestParseVariantRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		`(1, "foo")`,
+		`@(us) (1, "x")`,
+		`[(1, "a"), (2, "b")]`,
+		`{"a": <1>, "b": <(1, [<"x">])>}`,
+		`<@a{sv} {"c": <@o "/org/foo">}>`,
+	} {
+		v, err := ParseVariant(s, Signature{})
+		if err != nil {
+			t.Errorf("parsing %q failed: %s", s, err)
+			continue
+		}
+		nv, err := ParseVariant(v.String(), Signature{})
+		if err != nil {
+			t.Errorf("parsing %q (from %q) failed: %s", v.String(), s, err)
+			continue
+		}
+		if !reflect.DeepEqual(nv, v) {
+			t.Errorf("%q: got %#v after round trip, want %#v", s, nv, v)
+		}
+	}
+}
+
+func TestMakeBody(t *testing.T) {
+	for _, tc := range []struct {
+		sig  string
+		args []string
+		want []interface{}
+	}{
+		{"", nil, []interface{}{}},
+		{"su", []string{"foo bar", "42"}, []interface{}{"foo bar", uint32(42)}},
+		{"bd", []string{"true", "1.5"}, []interface{}{true, 1.5}},
+		{"og", []string{"/org/foo", "a{sv}"}, []interface{}{ObjectPath("/org/foo"), Signature{"a{sv}"}}},
+		{"ai", []string{"[1, 2]"}, []interface{}{[]int32{1, 2}}},
+		{"v", []string{"42"}, []interface{}{MakeVariant(int32(42))}},
+		{"a{sv}", []string{`{"a": <1>, "b": <"x">}`}, []interface{}{
+			map[string]Variant{"a": MakeVariant(int32(1)), "b": MakeVariant("x")},
+		}},
+		{"aa{ss}", []string{`[{"a": "b"}, {}]`}, []interface{}{
+			[]map[string]string{{"a": "b"}, {}},
+		}},
+		{"(ias)t", []string{`(1, ["x", "y"])`, "7"}, []interface{}{
+			[]interface{}{int32(1), []string{"x", "y"}}, uint64(7),
+		}},
+	} {
+		body, err := MakeBody(Signature{tc.sig}, tc.args...)
+		if err != nil {
+			t.Errorf("%q %q: %s", tc.sig, tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(body, tc.want) {
+			t.Errorf("%q %q: got %#v, want %#v", tc.sig, tc.args, body, tc.want)
+		}
+	}
+}
+
+func TestMakeBodyInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		sig  string
+		args []string
+	}{
+		{"s", nil},
+		{"s", []string{"a", "b"}},
+		{"o", []string{"not a path"}},
+		{"u", []string{"-1"}},
+		{"ai", []string{`["x"]`}},
+		{"a{sv}", []string{`{"a": 1}`}},
+	} {
+		if _, err := MakeBody(Signature{tc.sig}, tc.args...); err == nil {
+			t.Errorf("%q %q: expected an error", tc.sig, tc.args)
+		}
+	}
+}
+
+func TestVariantEqual(t *testing.T) {
+	type pair struct {
+		A int32
+		B []string
+	}
+	tests := []struct {
+		a, b  Variant
+		equal bool
+	}{
+		{MakeVariant(uint32(1)), MakeVariant(uint32(1)), true},
+		{MakeVariant(uint32(1)), MakeVariant(uint32(2)), false},
+		{MakeVariant(uint32(1)), MakeVariant(int32(1)), false},
+		{MakeVariant("foo"), MakeVariant("foo"), true},
+		{MakeVariant("/foo"), MakeVariant(ObjectPath("/foo")), false},
+		{MakeVariant(Signature{"as"}), MakeVariant(Signature{"as"}), true},
+		{MakeVariant(Signature{"as"}), MakeVariant(Signature{"ai"}), false},
+		{MakeVariant([]string{"a", "b"}), MakeVariant([]string{"a", "b"}), true},
+		{MakeVariant([]string{"a", "b"}), MakeVariant([]string{"b", "a"}), false},
+		{MakeVariant([]string(nil)), MakeVariant([]string{}), true},
+		{MakeVariant([]byte{1}), MakeVariant([]byte{1, 2}), false},
+		{MakeVariant(map[string]Variant{"x": MakeVariant(int32(1))}), MakeVariant(map[string]Variant{"x": MakeVariant(int32(1))}), true},
+		{MakeVariant(map[string]Variant{"x": MakeVariant(int32(1))}), MakeVariant(map[string]Variant{"x": MakeVariant(uint32(1))}), false},
+		{MakeVariant(map[string]Variant{"x": MakeVariant(int32(1))}), MakeVariant(map[string]Variant{"y": MakeVariant(int32(1))}), false},
+		{MakeVariant(map[string]int32(nil)), MakeVariant(map[string]int32{}), true},
+		{MakeVariant(MakeVariant([]int64{1})), MakeVariant(MakeVariant([]int64{1})), true},
+		{MakeVariant(MakeVariant([]int64{1})), MakeVariant(MakeVariant([]int64{2})), false},
+		{MakeVariant(pair{1, []string{"a"}}), MakeVariant(pair{1, []string{"a"}}), true},
+		{MakeVariant(pair{1, []string{"a"}}), MakeVariant(pair{1, []string{"b"}}), false},
+		{MakeVariant([]interface{}{int32(1), "a"}), MakeVariant([]interface{}{int32(1), "a"}), true},
+		{MakeVariant(1.5), MakeVariant(1.5), true},
+	}
+	for i, test := range tests {
+		if got := test.a.Equal(test.b); got != test.equal {
+			t.Errorf("test %d: %v.Equal(%v) = %v, want %v", i, test.a, test.b, got, test.equal)
+		}
+		if got := test.b.Equal(test.a); got != test.equal {
+			t.Errorf("test %d: %v.Equal(%v) = %v, want %v", i, test.b, test.a, got, test.equal)
+		}
+	}
+}
+
+func TestCoerceBodyNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		sig  string
+		want interface{}
+	}{
+		{int(5), "t", uint64(5)},
+		{int32(-1), "x", int64(-1)},
+		{uint8(3), "d", float64(3)},
+		{float64(2), "i", int32(2)},
+	} {
+		body, err := coerceBody(ParseSignatureMust(tc.sig), []interface{}{tc.v})
+		if err != nil {
+			t.Errorf("%T(%v) as %q: %v", tc.v, tc.v, tc.sig, err)
+			continue
+		}
+		if body[0] != tc.want {
+			t.Errorf("%T(%v) as %q: got %T(%v), want %T(%v)", tc.v, tc.v, tc.sig, body[0], body[0], tc.want, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		v   interface{}
+		sig string
+	}{
+		{int32(-1), "u"},
+		{int(-1), "u"},
+		{int(-1), "t"},
+		{int64(-5), "t"},
+		{int16(-1), "q"},
+		{int(300), "y"},
+		{uint64(1 << 32), "u"},
+		{uint64(1 << 63), "x"},
+		{float64(1.5), "i"},
+		{float64(-1), "u"},
+	} {
+		if body, err := coerceBody(ParseSignatureMust(tc.sig), []interface{}{tc.v}); err == nil {
+			t.Errorf("%T(%v) as %q: got %T(%v), want an error", tc.v, tc.v, tc.sig, body[0], body[0])
+		}
+	}
+}